@@ -0,0 +1,66 @@
+// Example action plugin: computes a discount of the lot total.
+//
+// The node params set the percent, e.g. {"percent": 15}, 10 by default.
+// The lot stays on the node until the total variable is set.
+//
+//	go run ./cmd/plugin-example -addr 127.0.0.1:50051
+//	OMS2_PLUGINS_ADDRESSES=discount=127.0.0.1:50051
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+
+	"oms2/internal/pkg/plugin"
+)
+
+const defaultPercent = 10.0
+
+func main() {
+
+	addr := flag.String("addr", "127.0.0.1:50051", "listen address")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the address line is read by whoever started the plugin when the port is chosen by the system
+	fmt.Printf("listening on %s\n", listener.Addr())
+
+	if err := plugin.Serve(listener, plugin.HandlerFunc(discount)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func discount(_ context.Context, request plugin.Request) (plugin.Response, error) {
+
+	total, ok := request.Variables["total"].(float64)
+	if !ok {
+		return plugin.Response{Outcome: "stay"}, nil
+	}
+
+	percent := defaultPercent
+	if value, ok := request.Node.Params["percent"]; ok {
+		percent, ok = value.(float64)
+		if !ok || percent < 0 || percent > 100 {
+			return plugin.Response{}, errors.New("percent must be a number from 0 to 100")
+		}
+	}
+
+	amount := math.Round(total*percent) / 100
+
+	return plugin.Response{
+		Outcome: "next",
+		Variables: map[string]interface{}{
+			"discount":            amount,
+			"total_with_discount": total - amount,
+		},
+	}, nil
+}
@@ -0,0 +1,64 @@
+package action
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/action"
+)
+
+type Controller struct {
+	service *action.Service
+}
+
+func NewController(service *action.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type waitsRequest struct {
+	LotId int64 `json:"lot_id"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/action")
+	{
+		apiRoute.POST("/callback", c.Callback)
+		apiRoute.POST("/waits", c.Waits)
+	}
+}
+
+func (c *Controller) Callback(ctx *gin.Context) {
+
+	var req action.Completion
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	wait, err := c.service.Complete(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, wait)
+}
+
+func (c *Controller) Waits(ctx *gin.Context) {
+
+	var req waitsRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	waits, err := c.service.Waits(ctx, req.LotId)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, waits)
+}
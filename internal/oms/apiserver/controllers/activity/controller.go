@@ -0,0 +1,70 @@
+package activity
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/activity"
+)
+
+type Controller struct {
+	service *activity.Service
+}
+
+func NewController(service *activity.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type heldRequest struct {
+	InstanceId string `json:"instance_id"`
+	Pool       string `json:"pool"`
+}
+
+type reclaimedRequest struct {
+	OrderId int64  `json:"order_id"`
+	Limit   uint64 `json:"limit"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/activity")
+	{
+		apiRoute.POST("/held", c.Held)
+		apiRoute.POST("/reclaimed", c.Reclaimed)
+	}
+}
+
+func (c *Controller) Held(ctx *gin.Context) {
+
+	var req heldRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	held, err := c.service.Held(ctx, req.InstanceId, req.Pool)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, held)
+}
+
+func (c *Controller) Reclaimed(ctx *gin.Context) {
+
+	var req reclaimedRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	reclaimed, err := c.service.Reclaimed(ctx, req.OrderId, req.Limit)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, reclaimed)
+}
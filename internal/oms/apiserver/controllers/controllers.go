@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+)
+
+// Bind decodes the data field of the request envelope into v
+func Bind(ctx *gin.Context, v interface{}) error {
+	return json.Unmarshal(ctx.MustGet(oms.KeyRequest).(json.RawMessage), v)
+}
+
+// Fail marks the request as failed, the response middleware renders the error envelope
+func Fail(ctx *gin.Context, err error) {
+	ctx.Set(oms.KeyResponse, ctx.Error(err).Error())
+}
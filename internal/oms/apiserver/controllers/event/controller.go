@@ -0,0 +1,265 @@
+package event
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/event"
+)
+
+type Controller struct {
+	service *event.Service
+}
+
+func NewController(service *event.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type idRequest struct {
+	Id int64 `json:"id"`
+}
+
+type duplicateListRequest struct {
+	EventId *int64  `json:"event_id"`
+	Source  *string `json:"source"`
+	Limit   uint64  `json:"limit"`
+}
+
+type scheduledListRequest struct {
+	LotId     *int64  `json:"lot_id"`
+	Status    *string `json:"status"`
+	CancelKey *string `json:"cancel_key"`
+	Limit     uint64  `json:"limit"`
+}
+
+type cancelScheduledRequest struct {
+	Id        int64  `json:"id"`
+	CancelKey string `json:"cancel_key"`
+	LotId     int64  `json:"lot_id"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	typeRoute := r.Group("/api/event-type")
+	{
+		typeRoute.POST("/create", c.CreateType)
+		typeRoute.POST("/update", c.UpdateType)
+		typeRoute.POST("/delete", c.DeleteType)
+		typeRoute.POST("/get", c.Type)
+		typeRoute.POST("/list", c.TypeList)
+	}
+
+	eventRoute := r.Group("/api/event")
+	{
+		eventRoute.POST("/create", c.CreateEvent)
+		eventRoute.POST("/get", c.Event)
+		eventRoute.POST("/duplicate/list", c.DuplicateList)
+		eventRoute.POST("/schedule", c.ScheduleEvent)
+		eventRoute.POST("/scheduled/list", c.ScheduledList)
+		eventRoute.POST("/scheduled/cancel", c.CancelScheduled)
+	}
+}
+
+func (c *Controller) CreateType(ctx *gin.Context) {
+
+	var req event.Type
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.CreateType(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) UpdateType(ctx *gin.Context) {
+
+	var req event.Type
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.UpdateType(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) DeleteType(ctx *gin.Context) {
+
+	var req codeRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	if err := c.service.DeleteType(ctx, req.Code); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"code": req.Code})
+}
+
+func (c *Controller) Type(ctx *gin.Context) {
+
+	var req codeRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	eventType, err := c.service.Type(ctx, req.Code)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, eventType)
+}
+
+func (c *Controller) TypeList(ctx *gin.Context) {
+
+	list, err := c.service.TypeList(ctx)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
+
+func (c *Controller) CreateEvent(ctx *gin.Context) {
+
+	var req event.Event
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	result, err := c.service.CreateEvent(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, result)
+}
+
+func (c *Controller) Event(ctx *gin.Context) {
+
+	var req idRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	result, err := c.service.Event(ctx, req.Id)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, result)
+}
+
+func (c *Controller) DuplicateList(ctx *gin.Context) {
+
+	var req duplicateListRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	params := make(map[string]interface{})
+	if req.EventId != nil {
+		params["event_id"] = *req.EventId
+	}
+	if req.Source != nil {
+		params["source"] = *req.Source
+	}
+
+	list, err := c.service.DuplicateList(ctx, params, req.Limit)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
+
+func (c *Controller) ScheduleEvent(ctx *gin.Context) {
+
+	var req event.ScheduledEvent
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.ScheduleEvent(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) ScheduledList(ctx *gin.Context) {
+
+	var req scheduledListRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	params := make(map[string]interface{})
+	if req.LotId != nil {
+		params["lot_id"] = *req.LotId
+	}
+	if req.Status != nil {
+		params["status"] = *req.Status
+	}
+	if req.CancelKey != nil {
+		params["cancel_key"] = *req.CancelKey
+	}
+
+	list, err := c.service.ScheduledList(ctx, params, req.Limit)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
+
+func (c *Controller) CancelScheduled(ctx *gin.Context) {
+
+	var req cancelScheduledRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	list, err := c.service.CancelScheduled(ctx, req.Id, req.CancelKey, req.LotId)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
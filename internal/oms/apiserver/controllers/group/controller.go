@@ -0,0 +1,111 @@
+package group
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/group"
+)
+
+type Controller struct {
+	service *group.Service
+}
+
+func NewController(service *group.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type idRequest struct {
+	Id int64 `json:"id"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/processing-group")
+	{
+		apiRoute.POST("/create", c.CreateGroup)
+		apiRoute.POST("/update", c.UpdateGroup)
+		apiRoute.POST("/delete", c.DeleteGroup)
+		apiRoute.POST("/get", c.Group)
+		apiRoute.POST("/list", c.GroupList)
+	}
+}
+
+func (c *Controller) CreateGroup(ctx *gin.Context) {
+
+	var req group.Group
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.CreateGroup(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) UpdateGroup(ctx *gin.Context) {
+
+	var req group.Group
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.UpdateGroup(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) DeleteGroup(ctx *gin.Context) {
+
+	var req idRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	if err := c.service.DeleteGroup(ctx, req.Id); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": req.Id})
+}
+
+func (c *Controller) Group(ctx *gin.Context) {
+
+	var req idRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	processingGroup, err := c.service.Group(ctx, req.Id)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, processingGroup)
+}
+
+func (c *Controller) GroupList(ctx *gin.Context) {
+
+	list, err := c.service.GroupList(ctx)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
@@ -0,0 +1,88 @@
+package lot
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/lot"
+)
+
+type Controller struct {
+	service *lot.Service
+}
+
+func NewController(service *lot.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type variablesRequest struct {
+	LotId     int64                  `json:"lot_id"`
+	Variables map[string]interface{} `json:"variables"`
+	Replace   bool                   `json:"replace"`
+}
+
+type lotRequest struct {
+	LotId int64 `json:"lot_id"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/lot")
+	{
+		apiRoute.POST("/variables/get", c.Variables)
+		apiRoute.POST("/variables/update", c.UpdateVariables)
+		apiRoute.POST("/triggers", c.Triggers)
+	}
+}
+
+func (c *Controller) Variables(ctx *gin.Context) {
+
+	var req variablesRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	variables, err := c.service.Variables(ctx, req.LotId)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, variables)
+}
+
+func (c *Controller) UpdateVariables(ctx *gin.Context) {
+
+	var req variablesRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	variables, err := c.service.UpdateVariables(ctx, req.LotId, req.Variables, req.Replace)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, variables)
+}
+
+func (c *Controller) Triggers(ctx *gin.Context) {
+
+	var req lotRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	triggers, err := c.service.Triggers(ctx, req.LotId)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, triggers)
+}
@@ -0,0 +1,68 @@
+package robot
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/robot"
+)
+
+type Controller struct {
+	service *robot.Service
+}
+
+func NewController(service *robot.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type modelRequest struct {
+	Model string `json:"model"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/robot")
+	{
+		apiRoute.POST("/model", c.Model)
+		apiRoute.POST("/model/set", c.SetModel)
+		apiRoute.POST("/breakers", c.Breakers)
+		apiRoute.POST("/pools", c.Pools)
+	}
+}
+
+func (c *Controller) Model(ctx *gin.Context) {
+
+	state, err := c.service.ModelState(ctx)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, state)
+}
+
+func (c *Controller) SetModel(ctx *gin.Context) {
+
+	var req modelRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	state, err := c.service.SetModel(ctx, req.Model)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, state)
+}
+
+func (c *Controller) Breakers(ctx *gin.Context) {
+	ctx.Set(oms.KeyResponse, c.service.GuardStates())
+}
+
+func (c *Controller) Pools(ctx *gin.Context) {
+	ctx.Set(oms.KeyResponse, c.service.PoolStates())
+}
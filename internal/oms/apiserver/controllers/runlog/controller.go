@@ -0,0 +1,60 @@
+package runlog
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/runlog"
+)
+
+type Controller struct {
+	service *runlog.Service
+}
+
+func NewController(service *runlog.Service) *Controller {
+	return &Controller{service: service}
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/run-log")
+	{
+		apiRoute.POST("/list", c.List)
+		apiRoute.POST("/stats", c.Stats)
+	}
+}
+
+func (c *Controller) List(ctx *gin.Context) {
+
+	var req runlog.Query
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	runs, err := c.service.List(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, runs)
+}
+
+func (c *Controller) Stats(ctx *gin.Context) {
+
+	var req runlog.Query
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	stats, err := c.service.Stats(ctx, req)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, stats)
+}
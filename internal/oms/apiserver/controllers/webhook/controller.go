@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"oms2/internal/oms"
+	"oms2/internal/oms/apiserver/controllers"
+	"oms2/internal/pkg/service/webhook"
+)
+
+type Controller struct {
+	service *webhook.Service
+}
+
+func NewController(service *webhook.Service) *Controller {
+	return &Controller{service: service}
+}
+
+type subscriptionRequest struct {
+	Id          int64   `json:"id"`
+	Url         *string `json:"url"`
+	Secret      *string `json:"secret"`
+	NodeId      *int64  `json:"node_id"`
+	EventTypeId *int64  `json:"event_type_id"`
+	Kind        *string `json:"kind"`
+	Active      *bool   `json:"active"`
+}
+
+func (r subscriptionRequest) values() map[string]interface{} {
+
+	values := make(map[string]interface{})
+	if r.Url != nil {
+		values["url"] = *r.Url
+	}
+	if r.Secret != nil {
+		values["secret"] = *r.Secret
+	}
+	if r.NodeId != nil {
+		values["node_id"] = *r.NodeId
+	}
+	if r.EventTypeId != nil {
+		values["event_type_id"] = *r.EventTypeId
+	}
+	if r.Kind != nil {
+		values["kind"] = *r.Kind
+	}
+	if r.Active != nil {
+		values["active"] = *r.Active
+	}
+
+	return values
+}
+
+type deliveryListRequest struct {
+	SubscriptionId *int64  `json:"subscription_id"`
+	LotId          *int64  `json:"lot_id"`
+	Status         *string `json:"status"`
+	Limit          uint64  `json:"limit"`
+}
+
+type idRequest struct {
+	Id int64 `json:"id"`
+}
+
+func (c *Controller) RegisterRoutes(r *gin.Engine) {
+
+	apiRoute := r.Group("/api/webhook")
+	{
+		apiRoute.POST("/subscription/create", c.CreateSubscription)
+		apiRoute.POST("/subscription/update", c.UpdateSubscription)
+		apiRoute.POST("/subscription/delete", c.DeleteSubscription)
+		apiRoute.POST("/subscription/list", c.SubscriptionList)
+		apiRoute.POST("/delivery/list", c.DeliveryList)
+		apiRoute.POST("/delivery/redeliver", c.Redeliver)
+	}
+}
+
+func (c *Controller) CreateSubscription(ctx *gin.Context) {
+
+	var req subscriptionRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.CreateSubscription(ctx, req.values())
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) UpdateSubscription(ctx *gin.Context) {
+
+	var req subscriptionRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	id, err := c.service.UpdateSubscription(ctx, req.Id, req.values())
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": id})
+}
+
+func (c *Controller) DeleteSubscription(ctx *gin.Context) {
+
+	var req idRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	if err := c.service.DeleteSubscription(ctx, req.Id); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": req.Id})
+}
+
+func (c *Controller) SubscriptionList(ctx *gin.Context) {
+
+	list, err := c.service.SubscriptionList(ctx)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
+
+func (c *Controller) DeliveryList(ctx *gin.Context) {
+
+	var req deliveryListRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	params := make(map[string]interface{})
+	if req.SubscriptionId != nil {
+		params["subscription_id"] = *req.SubscriptionId
+	}
+	if req.LotId != nil {
+		params["lot_id"] = *req.LotId
+	}
+	if req.Status != nil {
+		params["status"] = *req.Status
+	}
+
+	list, err := c.service.DeliveryList(ctx, params, req.Limit)
+	if err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, list)
+}
+
+func (c *Controller) Redeliver(ctx *gin.Context) {
+
+	var req idRequest
+	if err := controllers.Bind(ctx, &req); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	if err := c.service.Redeliver(ctx, req.Id); err != nil {
+		controllers.Fail(ctx, err)
+		return
+	}
+
+	ctx.Set(oms.KeyResponse, map[string]interface{}{"id": req.Id})
+}
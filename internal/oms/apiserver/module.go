@@ -5,7 +5,16 @@ import (
 	"go.uber.org/zap"
 	"oms2/internal/oms"
 
+	"oms2/internal/oms/apiserver/controllers/action"
+	"oms2/internal/oms/apiserver/controllers/activity"
+	"oms2/internal/oms/apiserver/controllers/event"
+	"oms2/internal/oms/apiserver/controllers/group"
 	"oms2/internal/oms/apiserver/controllers/health"
+	"oms2/internal/oms/apiserver/controllers/lot"
+	"oms2/internal/oms/apiserver/controllers/metrics"
+	"oms2/internal/oms/apiserver/controllers/robot"
+	"oms2/internal/oms/apiserver/controllers/runlog"
+	"oms2/internal/oms/apiserver/controllers/webhook"
 )
 
 type ApiServer struct {
@@ -13,17 +22,44 @@ type ApiServer struct {
 	Cfg *oms.Config
 	Zl  *zap.Logger
 
-	Health *health.Controller
+	Health   *health.Controller
+	Webhook  *webhook.Controller
+	Event    *event.Controller
+	Lot      *lot.Controller
+	Action   *action.Controller
+	Metrics  *metrics.Controller
+	Activity *activity.Controller
+	Robot    *robot.Controller
+	Group    *group.Controller
+	RunLog   *runlog.Controller
 }
 
 func Module() fx.Option {
 	return fx.Options(
 
 		fx.Provide(health.NewController),
+		fx.Provide(webhook.NewController),
+		fx.Provide(event.NewController),
+		fx.Provide(lot.NewController),
+		fx.Provide(action.NewController),
+		fx.Provide(metrics.NewController),
+		fx.Provide(activity.NewController),
+		fx.Provide(robot.NewController),
+		fx.Provide(group.NewController),
+		fx.Provide(runlog.NewController),
 
 		fx.Provide(func(a ApiServer) *APIServer {
 			return NewAPIServer(&a.Cfg.APIServer, a.Cfg, a.Zl).
-				AddController(a.Health)
+				AddController(a.Health).
+				AddController(a.Webhook).
+				AddController(a.Event).
+				AddController(a.Lot).
+				AddController(a.Action).
+				AddController(a.Metrics).
+				AddController(a.Activity).
+				AddController(a.Robot).
+				AddController(a.Group).
+				AddController(a.RunLog)
 		}),
 
 		fx.Invoke(
@@ -2,9 +2,12 @@ package oms
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+	uuid "github.com/satori/go.uuid"
 
 	"oms2/internal/pkg/config"
 	"oms2/internal/pkg/storage/postgres"
@@ -15,17 +18,32 @@ const CoreEnvironmentPrefix = "OMS2"
 const EnvDev = "dev"
 
 type Config struct {
-	Env                string           `envconfig:"env"`
-	Debug              bool             `envconfig:"debug"`
-	ProfilerEnable     bool             `envconfig:"pprof"`
-	StartTimeout       time.Duration    `envconfig:"start_timeout" default:"20s"`
-	StopTimeout        time.Duration    `envconfig:"stop_timeout" default:"60s"`
-	APIServer          config.APIServer `envconfig:"apiserver"`
-	Postgres           postgres.Config  `envconfig:"postgres"`
-	V7Elastic          config.Elastic   `envconfig:"v7_elastic"`
-	Logger             config.Logger    `envconfig:"zaplog"`
-	MaxCollectTime     time.Duration    `envconfig:"max_collect_time" default:"10m"`
-	MaxRobotGoroutines int              `envconfig:"max_robot_goroutines" default:"10"`
+	Env                string              `envconfig:"env"`
+	Debug              bool                `envconfig:"debug"`
+	ProfilerEnable     bool                `envconfig:"pprof"`
+	StartTimeout       time.Duration       `envconfig:"start_timeout" default:"20s"`
+	StopTimeout        time.Duration       `envconfig:"stop_timeout" default:"60s"`
+	APIServer          config.APIServer    `envconfig:"apiserver"`
+	Postgres           postgres.Config     `envconfig:"postgres"`
+	V7Elastic          config.Elastic      `envconfig:"v7_elastic"`
+	Logger             config.Logger       `envconfig:"zaplog"`
+	Webhook            config.Webhook      `envconfig:"webhook"`
+	Housekeeping       config.Housekeeping `envconfig:"housekeeping"`
+	Script             config.Script       `envconfig:"script"`
+	Plugins            config.Plugins      `envconfig:"plugins"`
+	Leader             config.Leader       `envconfig:"leader"`
+	Actions            config.Actions      `envconfig:"actions"`
+	Concurrency        config.Concurrency  `envconfig:"concurrency"`
+	NodePools          config.NodePools    `envconfig:"node_pools"`
+	RunLog             bool                `envconfig:"run_log" default:"true"`
+	EventWindow        time.Duration       `envconfig:"event_window" default:"24h"`
+	PendingTimeout     time.Duration       `envconfig:"pending_timeout" default:"24h"`
+	MaxRobotGoroutines int                 `envconfig:"max_robot_goroutines" default:"10"`
+	RobotModel         string              `envconfig:"robot_model" default:"Tiling"`
+	InstanceId         string              `envconfig:"instance_id"`
+	LeaseDuration      time.Duration       `envconfig:"lease_duration" default:"1m"`
+	HeartbeatInterval  time.Duration       `envconfig:"heartbeat_interval" default:"15s"`
+	ReapInterval       time.Duration       `envconfig:"reap_interval" default:"30s"`
 	Version            string
 	BuildDate          string
 	Commit             string
@@ -48,6 +66,12 @@ func NewConfig() (*Config, error) {
 		cfg.Logger.Debug = true
 	}
 
+	// the instance id tells the leases of the replicas of the robot apart
+	if len(cfg.InstanceId) == 0 {
+		hostname, _ := os.Hostname()
+		cfg.InstanceId = fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewV4().String()[:8])
+	}
+
 	return cfg, nil
 }
 
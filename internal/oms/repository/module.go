@@ -4,8 +4,15 @@ import (
 	"go.uber.org/fx"
 
 	"oms2/internal/pkg/repository/action"
+	"oms2/internal/pkg/repository/event"
+	"oms2/internal/pkg/repository/group"
+	"oms2/internal/pkg/repository/housekeeping"
+	"oms2/internal/pkg/repository/leader"
+	"oms2/internal/pkg/repository/lot"
 	"oms2/internal/pkg/repository/robot"
 	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/repository/runlog"
+	"oms2/internal/pkg/repository/webhook"
 )
 
 func Module() fx.Option {
@@ -13,5 +20,12 @@ func Module() fx.Option {
 		fx.Provide(root.NewRepository),
 		fx.Provide(robot.NewRepository),
 		fx.Provide(action.NewRepository),
+		fx.Provide(webhook.NewRepository),
+		fx.Provide(lot.NewRepository),
+		fx.Provide(event.NewRepository),
+		fx.Provide(housekeeping.NewRepository),
+		fx.Provide(group.NewRepository),
+		fx.Provide(leader.NewRepository),
+		fx.Provide(runlog.NewRepository),
 	)
 }
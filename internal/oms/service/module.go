@@ -2,9 +2,21 @@ package service
 
 import (
 	"go.uber.org/fx"
+	"oms2/internal/pkg/service/action"
+	"oms2/internal/pkg/service/activity"
+	"oms2/internal/pkg/service/event"
+	"oms2/internal/pkg/service/group"
 	"oms2/internal/pkg/service/health"
+	"oms2/internal/pkg/service/housekeeping"
+	"oms2/internal/pkg/service/leader"
 	"oms2/internal/pkg/service/log"
+	"oms2/internal/pkg/service/lot"
 	robot2 "oms2/internal/pkg/service/robot"
+	"oms2/internal/pkg/service/robot/httpaction"
+	"oms2/internal/pkg/service/robot/pluginaction"
+	"oms2/internal/pkg/service/robot/scriptaction"
+	"oms2/internal/pkg/service/runlog"
+	"oms2/internal/pkg/service/webhook"
 
 	"oms2/internal/oms"
 )
@@ -13,9 +25,78 @@ func Module() fx.Option {
 	return fx.Options(
 		fx.Provide(log.NewService),
 		fx.Provide(health.NewService),
+		fx.Provide(webhook.NewService),
+		fx.Provide(event.NewService),
+		fx.Provide(group.NewService),
+		fx.Provide(lot.NewService),
+		fx.Provide(action.NewService),
+		fx.Provide(leader.NewService),
+		fx.Provide(housekeeping.NewService),
+		fx.Provide(activity.NewService),
+		fx.Provide(runlog.NewService),
 		fx.Provide(robot2.NewAction),
+		fx.Provide(robot2.NewRegistry),
+		fx.Provide(httpaction.NewHandler),
+		fx.Provide(scriptaction.NewHandler),
+		fx.Provide(pluginaction.NewService),
 		fx.Provide(robot2.NewService),
 
+		fx.Invoke(func(registry *robot2.Registry, action *robot2.Action) error {
+			return action.Register(registry)
+		}),
+
+		fx.Invoke(func(registry *robot2.Registry, handler *httpaction.Handler) error {
+			return registry.Register(httpaction.Name, handler)
+		}),
+
+		fx.Invoke(func(registry *robot2.Registry, handler *scriptaction.Handler) error {
+			return registry.Register(scriptaction.Name, handler)
+		}),
+
+		fx.Invoke(func(registry *robot2.Registry, plugins *pluginaction.Service) error {
+			return plugins.Register(registry)
+		}),
+
+		fx.Invoke(func(service *health.Service, election *leader.Service, plugins *pluginaction.Service) {
+			service.Register(election)
+			service.Register(plugins)
+		}),
+
+		fx.Invoke(func(lc fx.Lifecycle, service *pluginaction.Service) {
+			lc.Append(fx.Hook{
+				OnStart: service.Start,
+				OnStop:  service.Stop,
+			})
+		}),
+
+		fx.Invoke(func(lc fx.Lifecycle, service *leader.Service) {
+			lc.Append(fx.Hook{
+				OnStart: service.Start,
+				OnStop:  service.Stop,
+			})
+		}),
+
+		fx.Invoke(func(lc fx.Lifecycle, service *webhook.Service) {
+			lc.Append(fx.Hook{
+				OnStart: service.Start,
+				OnStop:  service.Stop,
+			})
+		}),
+
+		fx.Invoke(func(lc fx.Lifecycle, service *housekeeping.Service) {
+			lc.Append(fx.Hook{
+				OnStart: service.Start,
+				OnStop:  service.Stop,
+			})
+		}),
+
+		fx.Invoke(func(lc fx.Lifecycle, service *activity.Service) {
+			lc.Append(fx.Hook{
+				OnStart: service.Start,
+				OnStop:  service.Stop,
+			})
+		}),
+
 		fx.Invoke(func(lc fx.Lifecycle, cfg *oms.Config, service *robot2.Service) {
 			lc.Append(fx.Hook{
 				OnStart: service.Start,
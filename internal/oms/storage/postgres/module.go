@@ -11,7 +11,9 @@ import (
 func Module() fx.Option {
 	return fx.Options(
 		fx.Provide(func(conf *oms.Config, log *zap.Logger) *postgres.Postgres {
-			return postgres.NewPostgres(conf.Postgres, log)
+			pg := conf.Postgres
+			pg.CountQueries = conf.Concurrency.Adaptive
+			return postgres.NewPostgres(pg, log)
 		}),
 		fx.Invoke(func(lc fx.Lifecycle, cfg *oms.Config, storage *postgres.Postgres) {
 			lc.Append(fx.Hook{
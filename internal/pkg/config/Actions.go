@@ -0,0 +1,61 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Actions struct {
+	Limits ActionLimits `envconfig:"limits"`
+	// TokenSecret signs the tokens of the asynchronous actions, it is the same for all the instances
+	TokenSecret string `envconfig:"token_secret" required:"true"`
+}
+
+// ActionLimit is the rate limit and the circuit breaker of an action.
+// A zero Rate does not limit the calls, a zero FailureThreshold disables the breaker.
+type ActionLimit struct {
+	Rate             float64       `json:"rate"`
+	Burst            int           `json:"burst"`
+	FailureThreshold int           `json:"failure_threshold"`
+	OpenTimeout      time.Duration `json:"-"`
+}
+
+// ActionLimits are the limits by action name, set as a json object, e.g.
+// {"http": {"rate": 10, "burst": 20, "failure_threshold": 5, "open_timeout": "30s"}}
+type ActionLimits map[string]ActionLimit
+
+func (a *ActionLimits) Decode(value string) error {
+
+	var raw map[string]struct {
+		ActionLimit
+		OpenTimeout string `json:"open_timeout"`
+	}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("invalid action limits: %w", err)
+	}
+
+	limits := make(ActionLimits, len(raw))
+	for name, item := range raw {
+		limit := item.ActionLimit
+		limit.OpenTimeout = 30 * time.Second
+
+		if len(item.OpenTimeout) > 0 {
+			timeout, err := time.ParseDuration(item.OpenTimeout)
+			if err != nil {
+				return fmt.Errorf("invalid open_timeout of action %s: %w", name, err)
+			}
+			limit.OpenTimeout = timeout
+		}
+
+		if limit.Rate < 0 || limit.Burst < 0 || limit.FailureThreshold < 0 || limit.OpenTimeout <= 0 {
+			return fmt.Errorf("invalid limits of action %s", name)
+		}
+
+		limits[name] = limit
+	}
+
+	*a = limits
+
+	return nil
+}
@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+// Concurrency adapts the concurrent threads of the robot between Min and OMS2_MAX_ROBOT_GOROUTINES
+type Concurrency struct {
+	Adaptive      bool          `envconfig:"adaptive" default:"false"`
+	Min           int           `envconfig:"min" default:"1"`
+	Interval      time.Duration `envconfig:"interval" default:"1s"`
+	TargetLatency time.Duration `envconfig:"target_latency" default:"20ms"`
+	TargetWait    time.Duration `envconfig:"target_wait" default:"5ms"`
+	Backoff       float64       `envconfig:"backoff" default:"0.7"`
+}
@@ -0,0 +1,10 @@
+package config
+
+import "time"
+
+type Housekeeping struct {
+	Interval        time.Duration `envconfig:"interval" default:"10m"`
+	BatchSize       int           `envconfig:"batch_size" default:"1000"`
+	EventRetention  time.Duration `envconfig:"event_retention" default:"720h"`
+	RunLogRetention time.Duration `envconfig:"run_log_retention" default:"168h"`
+}
@@ -0,0 +1,7 @@
+package config
+
+import "time"
+
+type Leader struct {
+	RetryInterval time.Duration `envconfig:"retry_interval" default:"2s"`
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// NodePool is a worker pool of the robot for the nodes of a group, Queue defaults to Size
+type NodePool struct {
+	Size  int `json:"size"`
+	Queue int `json:"queue"`
+}
+
+// NodePools are the pools by the group_id of _Ref_M, set as a json object, e.g.
+// {"500": {"size": 4, "queue": 8}}
+type NodePools map[int32]NodePool
+
+func (n *NodePools) Decode(value string) error {
+
+	var raw map[string]NodePool
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("invalid node pools: %w", err)
+	}
+
+	pools := make(NodePools, len(raw))
+	for key, pool := range raw {
+		groupId, err := strconv.ParseInt(key, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid node group %s: %w", key, err)
+		}
+
+		if pool.Queue == 0 {
+			pool.Queue = pool.Size
+		}
+		if pool.Size < 1 || pool.Queue < 1 {
+			return fmt.Errorf("invalid pool of node group %s", key)
+		}
+
+		pools[int32(groupId)] = pool
+	}
+
+	*n = pools
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Plugins struct {
+	Addresses      PluginAddresses `envconfig:"addresses"`
+	Timeout        time.Duration   `envconfig:"timeout" default:"10s"`
+	HealthInterval time.Duration   `envconfig:"health_interval" default:"15s"`
+}
+
+// PluginAddresses are gRPC addresses of action plugins by action name,
+// set as comma separated name=address pairs, e.g. "discount=127.0.0.1:50051,fraud=unix:///run/fraud.sock"
+type PluginAddresses map[string]string
+
+func (a *PluginAddresses) Decode(value string) error {
+
+	addresses := make(PluginAddresses)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("invalid plugin address %q, expected name=address", pair)
+		}
+		addresses[parts[0]] = parts[1]
+	}
+
+	*a = addresses
+
+	return nil
+}
@@ -0,0 +1,8 @@
+package config
+
+import "time"
+
+type Script struct {
+	MaxSteps uint64        `envconfig:"max_steps" default:"1000000"`
+	Timeout  time.Duration `envconfig:"timeout" default:"1s"`
+}
@@ -0,0 +1,12 @@
+package config
+
+import "time"
+
+type Webhook struct {
+	Timeout          time.Duration `envconfig:"timeout" default:"10s"`
+	MaxAttempts      int           `envconfig:"max_attempts" default:"8"`
+	BackoffBase      time.Duration `envconfig:"backoff_base" default:"5s"`
+	BackoffMax       time.Duration `envconfig:"backoff_max" default:"1h"`
+	DispatchInterval time.Duration `envconfig:"dispatch_interval" default:"1s"`
+	BatchSize        int           `envconfig:"batch_size" default:"100"`
+}
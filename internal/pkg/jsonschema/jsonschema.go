@@ -0,0 +1,48 @@
+package jsonschema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ValidationError lists every rule of the schema the document violates
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "document does not match schema: " + strings.Join(e.Violations, "; ")
+}
+
+// Check returns an error if schema is not a valid JSON Schema
+func Check(schema []byte) error {
+
+	_, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return fmt.Errorf("invalid json schema: %w", err)
+	}
+
+	return nil
+}
+
+// Validate validates document against schema, violations are returned as *ValidationError
+func Validate(schema []byte, document []byte) error {
+
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewBytesLoader(document))
+	if err != nil {
+		return fmt.Errorf("cannot validate document: %w", err)
+	}
+
+	if result.Valid() {
+		return nil
+	}
+
+	violations := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		violations = append(violations, e.String())
+	}
+
+	return &ValidationError{Violations: violations}
+}
@@ -0,0 +1,36 @@
+package jsonschema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const paymentSchema = `{
+	"type": "object",
+	"required": ["amount", "currency"],
+	"properties": {
+		"amount": {"type": "number"},
+		"currency": {"type": "string"}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+
+	err := Validate([]byte(paymentSchema), []byte(`{"amount": 10.5, "currency": "RUB"}`))
+	require.NoError(t, err)
+
+	err = Validate([]byte(paymentSchema), []byte(`{"currency": 643}`))
+	require.Error(t, err)
+
+	validationError, ok := err.(*ValidationError)
+	require.True(t, ok)
+	require.Len(t, validationError.Violations, 2)
+	require.Contains(t, err.Error(), "amount is required")
+	require.Contains(t, err.Error(), "currency")
+}
+
+func TestCheck(t *testing.T) {
+	require.NoError(t, Check([]byte(paymentSchema)))
+	require.Error(t, Check([]byte(`{"type": "unknown"}`)))
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const Namespace = "oms2"
+
+var (
+	EventDuplicates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "event_duplicates_total",
+		Help:      "Incoming events rejected as duplicates by idempotency key.",
+	}, []string{"source", "event_type"})
+
+	ArchivedRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "housekeeping_archived_rows_total",
+		Help:      "Rows moved to archive tables by the housekeeping job.",
+	}, []string{"table"})
+
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "leader",
+		Help:      "1 if the instance is the leader running the singleton robot duties.",
+	})
+
+	ActivityLeases = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "activity_leases",
+		Help:      "Order leases of the robot threads by state, held or expired.",
+	}, []string{"state"})
+
+	ActivityReclaimed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "activity_reclaimed_total",
+		Help:      "Order leases released by the reaper after their heartbeat expired.",
+	})
+
+	ActivityHeartbeatErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "activity_heartbeat_errors_total",
+		Help:      "Failed or lost heartbeats of the robot threads.",
+	})
+
+	ActivityLeaseLost = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "activity_lease_lost_total",
+		Help:      "Threads and steps stopped because their order lease was reclaimed.",
+	})
+
+	RobotCycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "robot_cycle_duration_seconds",
+		Help:      "Duration of a cycle of the robot by scheduling model.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"model"})
+
+	RobotThreads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "robot_threads_total",
+		Help:      "Threads queued to the worker pool by scheduling model.",
+	}, []string{"model"})
+
+	RobotBatchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "robot_batch_lots",
+		Help:      "Lots per thread by scheduling model.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"model"})
+
+	RobotThreadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "robot_thread_duration_seconds",
+		Help:      "Time a worker spends on a thread by scheduling model.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"model"})
+
+	GroupQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "robot_group_queue_depth",
+		Help:      "Due orders of a processing group waiting for a thread.",
+	}, []string{"group"})
+
+	GroupWaitSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "robot_group_wait_seconds",
+		Help:      "Time the oldest due order of a processing group has been waiting for a thread.",
+	}, []string{"group"})
+
+	GroupThreads = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "robot_group_threads",
+		Help:      "Threads of a processing group allocated by the fair scheduler.",
+	}, []string{"group"})
+
+	ActionBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "action_breaker_state",
+		Help:      "Circuit breaker of an action: 0 closed, 1 half-open, 2 open.",
+	}, []string{"action"})
+
+	ActionDeferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "action_deferred_total",
+		Help:      "Action runs put off by the rate limit or the open circuit breaker of the action.",
+	}, []string{"action", "reason"})
+
+	RobotConcurrencyLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "robot_concurrency_limit",
+		Help:      "Threads in flight a worker pool of the robot keeps to under the adaptive limit.",
+	}, []string{"pool"})
+
+	RobotDBLatency = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "robot_db_latency_seconds",
+		Help:      "Mean duration of the queries of the instance over the interval of the adaptive limit.",
+	})
+
+	RobotDBPoolWait = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "robot_db_pool_wait_seconds",
+		Help:      "Mean wait for a connection of the pool over the sampling interval of the adaptive limit.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		EventDuplicates,
+		ArchivedRows,
+		Leader,
+		ActivityLeases,
+		ActivityReclaimed,
+		ActivityHeartbeatErrors,
+		ActivityLeaseLost,
+		RobotCycleDuration,
+		RobotThreads,
+		RobotBatchSize,
+		RobotThreadDuration,
+		GroupQueueDepth,
+		GroupWaitSeconds,
+		GroupThreads,
+		ActionBreakerState,
+		ActionDeferred,
+		RobotConcurrencyLimit,
+		RobotDBLatency,
+		RobotDBPoolWait,
+	)
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Client of a plugin
+type Client struct {
+	conn   *grpc.ClientConn
+	health grpc_health_v1.HealthClient
+}
+
+// Dial connects to the plugin lazily, address is a gRPC target such as host:port or unix:///path
+func Dial(address string, opts ...grpc.DialOption) (*Client, error) {
+
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn:   conn,
+		health: grpc_health_v1.NewHealthClient(conn),
+	}, nil
+}
+
+func (c *Client) Execute(ctx context.Context, request Request) (Response, error) {
+
+	in, err := encode(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	out := new(structpb.Struct)
+	if err := c.conn.Invoke(ctx, ExecuteMethod, in, out); err != nil {
+		return Response{}, err
+	}
+
+	var response Response
+	if err := decode(out, &response); err != nil {
+		return Response{}, err
+	}
+
+	return response, nil
+}
+
+// Check returns the health status of the plugin service, or of the server
+// if the plugin does not report the service separately
+func (c *Client) Check(ctx context.Context) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+
+	response, err := c.health.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: ServiceName})
+	if status.Code(err) == codes.NotFound {
+		response, err = c.health.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	}
+	if err != nil {
+		return grpc_health_v1.HealthCheckResponse_UNKNOWN, err
+	}
+
+	return response.GetStatus(), nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,158 @@
+// Package plugin is the protocol of out-of-process actions described in api/plugin/action.proto:
+// a plugin serves oms2.plugin.v1.ActionPlugin/Execute over gRPC with google.protobuf.Struct
+// request and response and the standard gRPC health service.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	ServiceName   = "oms2.plugin.v1.ActionPlugin"
+	ExecuteMethod = "/" + ServiceName + "/Execute"
+)
+
+// Request is the lot on the node of the plugin action.
+// IdempotencyKey stays the same when the action is run again after a failed step,
+// Token correlates the completion if the plugin answers with the pending outcome.
+type Request struct {
+	Lot            Lot                    `json:"lot"`
+	Node           Node                   `json:"node"`
+	Variables      map[string]interface{} `json:"variables"`
+	Event          *Event                 `json:"event"`
+	IdempotencyKey string                 `json:"idempotency_key"`
+	Token          string                 `json:"token"`
+}
+
+type Lot struct {
+	LotId   int64 `json:"lot_id"`
+	OrderId int64 `json:"order_id"`
+}
+
+type Node struct {
+	Id     int64                  `json:"id"`
+	Name   string                 `json:"name"`
+	Action string                 `json:"action"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type Event struct {
+	Id      int64                  `json:"id"`
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// Response is the outcome of the action and the patch of the lot variables, a nil value removes the variable.
+// For the pending outcome Token replaces the token of the request and TimeoutSeconds the timeout of the node.
+type Response struct {
+	Outcome        string                 `json:"outcome"`
+	Variables      map[string]interface{} `json:"variables"`
+	Token          string                 `json:"token,omitempty"`
+	TimeoutSeconds int64                  `json:"timeout_seconds,omitempty"`
+}
+
+// Handler is implemented by plugins
+type Handler interface {
+	Execute(ctx context.Context, request Request) (Response, error)
+}
+
+// HandlerFunc adapts a function to Handler
+type HandlerFunc func(ctx context.Context, request Request) (Response, error)
+
+func (f HandlerFunc) Execute(ctx context.Context, request Request) (Response, error) {
+	return f(ctx, request)
+}
+
+// ServiceDesc of ActionPlugin, the service handler is a Handler
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Handler)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    execute,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/plugin/action.proto",
+}
+
+func execute(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+
+		var request Request
+		if err := decode(req.(*structpb.Struct), &request); err != nil {
+			return nil, err
+		}
+
+		response, err := srv.(Handler).Execute(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		return encode(response)
+	}
+
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: ExecuteMethod}, handler)
+}
+
+// NewServer returns a gRPC server with the handler and a health service reporting it as serving
+func NewServer(handler Handler, opts ...grpc.ServerOption) *grpc.Server {
+
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&ServiceDesc, handler)
+
+	checker := health.NewServer()
+	checker.SetServingStatus(ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, checker)
+
+	return server
+}
+
+// Serve serves the handler on the listener until the listener fails
+func Serve(listener net.Listener, handler Handler) error {
+	return NewServer(handler).Serve(listener)
+}
+
+func encode(value interface{}) (*structpb.Struct, error) {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(structpb.Struct)
+	if err := protojson.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func decode(value *structpb.Struct, result interface{}) error {
+
+	data, err := protojson.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
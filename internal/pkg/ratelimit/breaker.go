@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// Breaker opens after Threshold failures in a row and rejects the calls for OpenTimeout,
+// then lets one probe through: its success closes the breaker, its failure opens it again
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	timeout   time.Duration
+
+	state     string
+	failures  int
+	openUntil time.Time
+	probing   bool
+	lastError string
+}
+
+// BreakerState is the state of a breaker for the admin output
+type BreakerState struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func NewBreaker(threshold int, timeout time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		timeout:   timeout,
+		state:     StateClosed,
+	}
+}
+
+// Allow tells if a call may go at now, a rejected call gets the time the breaker lets a probe through
+func (b *Breaker) Allow(now time.Time) (bool, time.Time) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if now.Before(b.openUntil) {
+			return false, b.openUntil
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return true, time.Time{}
+	case StateHalfOpen:
+		if b.probing {
+			return false, now.Add(b.timeout)
+		}
+		b.probing = true
+		return true, time.Time{}
+	}
+
+	return true, time.Time{}
+}
+
+// Record counts the result of an allowed call at now
+func (b *Breaker) Record(now time.Time, err error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if err == nil {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures += 1
+	b.lastError = err.Error()
+
+	if b.state == StateHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = StateOpen
+		b.openUntil = now.Add(b.timeout)
+	}
+}
+
+// Cancel releases the probe of a call that gave no result, e.g. cancelled on shutdown
+func (b *Breaker) Cancel() {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *Breaker) State() BreakerState {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := BreakerState{
+		State:     b.state,
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+
+	if b.state == StateOpen {
+		openUntil := b.openUntil
+		state.OpenUntil = &openUntil
+	}
+
+	return state
+}
@@ -0,0 +1,75 @@
+// Package ratelimit limits the calls of a downstream service with a token bucket
+// and stops them with a circuit breaker while the service fails
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled with Rate tokens a second up to Burst
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate float64, burst int) *Bucket {
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Take takes a token at now, without one it returns the wait for the next token
+func (b *Bucket) Take(now time.Time) (bool, time.Duration) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+
+	if b.rate <= 0 {
+		return false, time.Duration(1<<63 - 1)
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Tokens returns the tokens available at now
+func (b *Bucket) Tokens(now time.Time) float64 {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	return b.tokens
+}
+
+func (b *Bucket) refill(now time.Time) {
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+
+	if now.After(b.last) {
+		b.last = now
+	}
+}
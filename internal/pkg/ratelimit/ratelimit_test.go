@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	b := NewBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Take(now); !ok {
+			t.Fatalf("take %d of the burst failed", i)
+		}
+	}
+
+	ok, wait := b.Take(now)
+	if ok {
+		t.Fatal("take over the burst succeeded")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want 500ms", wait)
+	}
+
+	if ok, _ := b.Take(now.Add(500 * time.Millisecond)); !ok {
+		t.Error("take after the refill failed")
+	}
+
+	if tokens := b.Tokens(now.Add(time.Hour)); tokens != 3 {
+		t.Errorf("tokens = %v, want the burst 3", tokens)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("unavailable")
+	b := NewBreaker(2, time.Minute)
+
+	b.Record(now, failure)
+	if ok, _ := b.Allow(now); !ok {
+		t.Fatal("breaker opened before the threshold")
+	}
+
+	b.Record(now, failure)
+	ok, until := b.Allow(now.Add(time.Second))
+	if ok || !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Allow() = %v, %v, want rejected until %v", ok, until, now.Add(time.Minute))
+	}
+	if state := b.State(); state.State != StateOpen || state.LastError != failure.Error() {
+		t.Errorf("state = %+v", state)
+	}
+
+	// one probe after the timeout, the others wait for it
+	probe := now.Add(time.Minute)
+	if ok, _ := b.Allow(probe); !ok {
+		t.Fatal("probe rejected")
+	}
+	if ok, _ := b.Allow(probe); ok {
+		t.Fatal("second call allowed while probing")
+	}
+
+	b.Record(probe, failure)
+	if ok, _ := b.Allow(probe.Add(time.Second)); ok {
+		t.Fatal("failed probe did not open the breaker")
+	}
+
+	probe = probe.Add(time.Minute)
+	if ok, _ := b.Allow(probe); !ok {
+		t.Fatal("second probe rejected")
+	}
+	b.Record(probe, nil)
+
+	if state := b.State(); state.State != StateClosed || state.Failures != 0 {
+		t.Errorf("state after a successful probe = %+v", state)
+	}
+	if ok, _ := b.Allow(probe); !ok {
+		t.Error("closed breaker rejected a call")
+	}
+}
@@ -1,6 +1,10 @@
 package action
 
 import (
+	"context"
+	"time"
+
+	"github.com/Masterminds/squirrel"
 	"go.uber.org/zap"
 
 	"oms2/internal/pkg/repository/root"
@@ -20,3 +24,70 @@ func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger)
 		RootRepository: root,
 	}
 }
+
+// NodeParams returns the params of the node
+func (r *Repository) NodeParams(ctx context.Context, nodeId int64) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("n.params").
+		From("_Ref_M as n").
+		Where(squirrel.Eq{"n.id": nodeId}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// Wait returns the wait of an asynchronous action by token
+func (r *Repository) Wait(ctx context.Context, token string) ([]map[string]interface{}, error) {
+	return r.waits(ctx, squirrel.Eq{"aw.token": token})
+}
+
+// Waits returns the waits of asynchronous actions of the lot, the latest first
+func (r *Repository) Waits(ctx context.Context, lotId int64) ([]map[string]interface{}, error) {
+	return r.waits(ctx, squirrel.Eq{"aw.lot_id": lotId})
+}
+
+func (r *Repository) waits(ctx context.Context, where squirrel.Sqlizer) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("aw.id, aw.lot_id, aw.node_id, n.name as node_name, aw.token, aw.status, aw.created_time, aw.deadline, " +
+			"aw.completed_time, aw.resolved_time, aw.result, aw.error, aw.event_id").
+		From("_InfoReg_AW as aw").
+		InnerJoin("_Ref_M as n on n.id = aw.node_id").
+		Where(where).
+		OrderBy("aw.id desc").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// CompleteWait stores the result of a pending wait, the robot moves the lot on the next iteration
+func (r *Repository) CompleteWait(ctx context.Context, token string, status string, result string, errorText interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_AW").
+		Set("status", status).
+		Set("result", result).
+		Set("error", errorText).
+		Set("completed_time", time.Now()).
+		Where(squirrel.Eq{"token": token, "status": "pending", "resolved_time": nil}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
@@ -0,0 +1,433 @@
+package event
+
+import (
+	"context"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/lot"
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+	lotRepository  *lot.Repository
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, lots *lot.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+		lotRepository:  lots,
+	}
+}
+
+func (r *Repository) CreateType(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_Ref_ET").
+		SetMap(data).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) UpdateType(ctx context.Context, code string, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_Ref_ET").
+		SetMap(data).
+		Where(squirrel.Eq{"code": code}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) DeleteType(ctx context.Context, code string) error {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Delete("_Ref_ET").
+		Where(squirrel.Eq{"code": code}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.RootRepository.Delete(ctx, _sql, args...)
+}
+
+func (r *Repository) TypeList(ctx context.Context) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("et.id, et.code, et.name, et.description, et.payload_schema, et.merge_rule, et.window_seconds").
+		From("_Ref_ET as et").
+		OrderBy("et.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func (r *Repository) TypeByCode(ctx context.Context, code string) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("et.id, et.code, et.name, et.description, et.payload_schema, et.merge_rule, et.window_seconds").
+		From("_Ref_ET as et").
+		Where(squirrel.Eq{"et.code": code}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// TypeUsage returns the number of the rows referencing the event type by table: events and their semaphores,
+// map nodes waiting for the type, trigger conditions and triggers of the nodes, scheduled events and webhook subscriptions
+func (r *Repository) TypeUsage(ctx context.Context, code string) ([]map[string]interface{}, error) {
+
+	_sql := `select
+				(select count(*) from _ref_e as e where e.event_type_id = et.id) as events,
+				(select count(*) from _inforeg_es as es where es.semaphore_id = et.id) as semaphores,
+				(select count(*) from _refvt_me as me where me.event_type_id = et.id) as nodes,
+				(select count(*) from _refvt_mt as mt where mt.event_type_id = et.id) as trigger_conditions,
+				(select count(*) from _ref_m as m where m.event_trigger = et.id) as triggers,
+				(select count(*) from _inforeg_se as se where se.event_type_id = et.id) as scheduled_events,
+				(select count(*) from _ref_ws as ws where ws.event_type_id = et.id) as subscriptions
+			from _ref_et as et
+			where et.code = $1`
+
+	var args []interface{}
+	args = append(args, code)
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func (r *Repository) Lot(ctx context.Context, lotId int64) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("l.id, l.order_id").
+		From("_Ref_L as l").
+		Where(squirrel.Eq{"l.id": lotId}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// Event returns the event, from the archive _Ref_EA if it has been archived
+func (r *Repository) Event(ctx context.Context, id int64) ([]map[string]interface{}, error) {
+
+	_sql := `select e.id, e.name, e.event_type_id, et.code as event_type, e.lot_id, e.payload, e.source, e.idempotency_key, e.entry_time
+			from _ref_e as e
+				inner join _ref_et as et on et.id = e.event_type_id
+			where e.id = $1
+			union all
+			select ea.id, ea.name, ea.event_type_id, et.code, ea.lot_id, ea.payload, ea.source, ea.idempotency_key, ea.entry_time
+			from _ref_ea as ea
+				inner join _ref_et as et on et.id = ea.event_type_id
+			where ea.id = $1
+			limit 1`
+
+	return r.RootRepository.Get(ctx, _sql, id)
+}
+
+// CreateEvent registers the event, its processing semaphore and merges the payload
+// into the lot variables with merge in one transaction.
+// An event with an idempotency key already registered for its source is not created again:
+// the hit is logged and the id of the original event is returned with duplicate set.
+// The keys are kept in _InfoReg_EK, so an event moved to the archive still deduplicates.
+func (r *Repository) CreateEvent(ctx context.Context, event map[string]interface{}, orderId interface{}, merge func(map[string]interface{}) (map[string]interface{}, error)) (id int64, duplicate bool, err error) {
+
+	err = r.storage.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+
+		err := tx.QueryRow(ctx, "select nextval(pg_get_serial_sequence('_ref_e', 'id'))").Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if event["idempotency_key"] != nil {
+			var key string
+			err = tx.QueryRow(ctx, `insert into _InfoReg_EK(source, idempotency_key, event_id)
+				values ($1, $2, $3)
+				on conflict (source, idempotency_key) do nothing
+				returning idempotency_key`, event["source"], event["idempotency_key"], id).Scan(&key)
+			if err == pgx.ErrNoRows {
+				duplicate = true
+				return r.registerDuplicate(ctx, tx, event, &id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		values := make(map[string]interface{}, len(event)+1)
+		for key, value := range event {
+			values[key] = value
+		}
+		values["id"] = id
+
+		_sql, args, err := squirrel.
+			StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			Insert("_Ref_E").
+			SetMap(values).
+			ToSql()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, _sql, args...); err != nil {
+			return err
+		}
+
+		_, err = r.lotRepository.UpdateVariablesTx(ctx, tx, event["lot_id"], merge)
+		if err != nil {
+			return err
+		}
+
+		_sql, args, err = squirrel.
+			StatementBuilder.
+			PlaceholderFormat(squirrel.Dollar).
+			Insert("_InfoReg_ES").
+			Columns("lot_id", "semaphore_id", "event_id", "order_id").
+			Values(event["lot_id"], event["event_type_id"], id, orderId).
+			ToSql()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, _sql, args...)
+
+		return err
+	})
+
+	return id, duplicate, err
+}
+
+func (r *Repository) registerDuplicate(ctx context.Context, tx pgx.Tx, event map[string]interface{}, id *int64) error {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("ek.event_id").
+		From("_InfoReg_EK as ek").
+		Where(squirrel.Eq{"ek.source": event["source"], "ek.idempotency_key": event["idempotency_key"]}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx, _sql, args...).Scan(id)
+	if err != nil {
+		return err
+	}
+
+	_sql, args, err = squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_InfoReg_ED").
+		Columns("event_id", "source", "idempotency_key").
+		Values(*id, event["source"], event["idempotency_key"]).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, _sql, args...)
+
+	return err
+}
+
+// DuplicateList returns duplicate hits, newest first
+func (r *Repository) DuplicateList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error) {
+
+	query := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("ed.id, ed.event_id, ed.source, ed.idempotency_key, ed.hit_time").
+		From("_InfoReg_ED as ed").
+		OrderBy("ed.id desc").
+		Limit(limit)
+
+	for key, value := range params {
+		query = query.Where(squirrel.Eq{"ed." + key: value})
+	}
+
+	_sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func (r *Repository) CreateScheduled(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_InfoReg_SE").
+		SetMap(data).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) ScheduledList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error) {
+
+	query := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("se.id, et.code as event_type, se.lot_id, se.name, se.payload, se.fire_at, se.cancel_key, " +
+			"se.status, se.event_id, se.last_error, se.created_at, se.completed_at").
+		From("_InfoReg_SE as se").
+		InnerJoin("_Ref_ET as et on et.id = se.event_type_id").
+		OrderBy("se.fire_at").
+		Limit(limit)
+
+	for key, value := range params {
+		query = query.Where(squirrel.Eq{"se." + key: value})
+	}
+
+	_sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// InTransaction runs fn in a transaction carried by the context
+func (r *Repository) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.RootRepository.InTransaction(ctx, fn)
+}
+
+// CancelScheduled moves scheduled events matching params from status to cancelled and returns their ids
+func (r *Repository) CancelScheduled(ctx context.Context, params map[string]interface{}, status string, cancelled string) ([]map[string]interface{}, error) {
+
+	query := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_SE").
+		Set("status", cancelled).
+		Set("completed_at", time.Now()).
+		Where(squirrel.Eq{"status": status})
+
+	for key, value := range params {
+		query = query.Where(squirrel.Eq{key: value})
+	}
+
+	_sql, args, err := query.Suffix("RETURNING id").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// ClaimDueScheduled leases due scheduled events in status, so that concurrent robots skip them
+func (r *Repository) ClaimDueScheduled(ctx context.Context, status string, limit uint64, lease time.Duration) ([]map[string]interface{}, error) {
+
+	_sql := `update _inforeg_se as se
+			set lease_until = $1
+			from _ref_et as et
+			where et.id = se.event_type_id
+				and se.id in (select
+							id
+						from _inforeg_se
+						where status = $2
+							and fire_at <= $3
+							and (lease_until is null or lease_until < $3)
+						order by fire_at
+						limit $4
+						for update skip locked)
+			returning se.id, et.code as event_type, se.lot_id, se.name, se.payload`
+
+	now := time.Now()
+
+	var args []interface{}
+	args = append(args, now.Add(lease))
+	args = append(args, status)
+	args = append(args, now)
+	args = append(args, limit)
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// LockScheduled locks the scheduled event in status until the end of the transaction of the context,
+// an event in another status or locked by another robot is not returned
+func (r *Repository) LockScheduled(ctx context.Context, id int64, status string) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("se.id, et.code as event_type, se.lot_id, se.name, se.payload").
+		From("_InfoReg_SE as se").
+		InnerJoin("_Ref_ET as et on et.id = se.event_type_id").
+		Where(squirrel.Eq{"se.id": id, "se.status": status}).
+		Suffix("FOR UPDATE OF se SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// UpdateScheduled updates the scheduled event still in status and returns its id, 0 if it has left the status
+func (r *Repository) UpdateScheduled(ctx context.Context, id int64, status string, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_SE").
+		SetMap(data).
+		Where(squirrel.Eq{"id": id, "status": status}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
@@ -0,0 +1,112 @@
+package group
+
+import (
+	"context"
+
+	"github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+const columns = "pg.id, pg.code, pg.name, pg.concurrency_limit, pg.batch_size, pg.poll_interval_seconds, pg.enabled, pg.weight, pg.min_threads, pg.updated_time"
+
+// Repository keeps the settings of the processing groups in _Ref_PG
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+	}
+}
+
+func (r *Repository) CreateGroup(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_Ref_PG").
+		SetMap(data).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) UpdateGroup(ctx context.Context, id int64, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_Ref_PG").
+		SetMap(data).
+		Set("updated_time", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) DeleteGroup(ctx context.Context, id int64) error {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Delete("_Ref_PG").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.RootRepository.Delete(ctx, _sql, args...)
+}
+
+// GroupList returns the configured groups with the number of their orders
+func (r *Repository) GroupList(ctx context.Context) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select(columns, "(select count(*) from _InfoReg_PG as o where o.group_id = pg.id) as orders").
+		From("_Ref_PG as pg").
+		OrderBy("pg.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func (r *Repository) GroupById(ctx context.Context, id int64) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select(columns, "(select count(*) from _InfoReg_PG as o where o.group_id = pg.id) as orders").
+		From("_Ref_PG as pg").
+		Where(squirrel.Eq{"pg.id": id}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
@@ -0,0 +1,113 @@
+package housekeeping
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+	"oms2/internal/pkg/util"
+)
+
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+	}
+}
+
+// ArchiveSemaphores moves up to limit consumed semaphores and semaphores out of the window
+// of their event type to _InfoReg_ESA, window is used for types without their own
+func (r *Repository) ArchiveSemaphores(ctx context.Context, window time.Duration, limit int) (int64, error) {
+
+	_sql := `with moved as (
+				delete from _inforeg_es as es
+				where es.id in (select
+							s.id
+						from _inforeg_es as s
+							inner join _ref_et as et on et.id = s.semaphore_id
+						where s.consumed_time is not null
+							or s.entry_time < $1::timestamptz - coalesce(et.window_seconds, $2) * interval '1 second'
+						limit $3
+						for update of s skip locked)
+				returning es.id, es.lot_id, es.semaphore_id, es.event_id, es.order_id, es.entry_time, es.consumed_time),
+			archived as (
+				insert into _inforeg_esa (id, lot_id, semaphore_id, event_id, order_id, entry_time, consumed_time)
+				select * from moved
+				on conflict (id) do nothing)
+			select count(*) as moved from moved`
+
+	var args []interface{}
+	args = append(args, time.Now())
+	args = append(args, int64(window/time.Second))
+	args = append(args, limit)
+
+	return r.moved(ctx, _sql, args...)
+}
+
+// ArchiveEvents moves up to limit events registered before and no longer referenced by semaphores
+// to _Ref_EA. Their idempotency keys stay in _InfoReg_EK, the duplicate log keeps pointing to the archived events.
+func (r *Repository) ArchiveEvents(ctx context.Context, before time.Time, limit int) (int64, error) {
+
+	_sql := `with moved as (
+				delete from _ref_e as e
+				where e.id in (select
+							ev.id
+						from _ref_e as ev
+						where ev.entry_time < $1
+							and not exists(select 1 from _inforeg_es as es where es.event_id = ev.id)
+						limit $2
+						for update skip locked)
+				returning e.id, e.name, e.event_type_id, e.lot_id, e.payload, e.source, e.idempotency_key, e.entry_time),
+			archived as (
+				insert into _ref_ea (id, name, event_type_id, lot_id, payload, source, idempotency_key, entry_time)
+				select * from moved
+				on conflict (id) do nothing)
+			select count(*) as moved from moved`
+
+	var args []interface{}
+	args = append(args, before)
+	args = append(args, limit)
+
+	return r.moved(ctx, _sql, args...)
+}
+
+// PurgeRunLog deletes up to limit rows of the run log started before
+func (r *Repository) PurgeRunLog(ctx context.Context, before time.Time, limit int) (int64, error) {
+
+	_sql := `with moved as (
+				delete from _inforeg_rl as rl
+				where rl.id in (select
+							id
+						from _inforeg_rl
+						where start_time < $1
+						limit $2
+						for update skip locked)
+				returning rl.id)
+			select count(*) as moved from moved`
+
+	var args []interface{}
+	args = append(args, before)
+	args = append(args, limit)
+
+	return r.moved(ctx, _sql, args...)
+}
+
+func (r *Repository) moved(ctx context.Context, _sql string, args ...interface{}) (int64, error) {
+
+	result, err := r.RootRepository.Get(ctx, _sql, args...)
+	if err != nil || len(result) == 0 {
+		return 0, err
+	}
+
+	return util.ToInt64(result[0]["moved"]), nil
+}
@@ -0,0 +1,134 @@
+package housekeeping
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+func TestRepository_ArchiveDuplicatedEvent(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	zl := zap.L()
+	p := postgres.NewPostgres(postgres.Config{
+		DBUser:     "postgres",
+		DBPassword: "postgres",
+		DBHost:     "localhost",
+		DBPort:     "5432",
+		DBName:     "oms",
+		LogLevel:   "error",
+		MaxConns:   4,
+	}, zl)
+	if err := p.Start(ctx); err != nil {
+		t.Skipf("postgres is not available: %v", err)
+	}
+	defer p.Stop(ctx)
+
+	conn, err := p.Conn(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, PrepareTestDB(ctx, conn))
+
+	repository := NewRepository(p, root.NewRepository(p, zl), zl)
+
+	moved, err := repository.ArchiveEvents(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), moved)
+
+	var archived, left, duplicates, keys int
+	require.NoError(t, conn.QueryRow(ctx, "select count(*) from _ref_ea where id in (1, 2)").Scan(&archived))
+	require.NoError(t, conn.QueryRow(ctx, "select count(*) from _ref_e").Scan(&left))
+	require.NoError(t, conn.QueryRow(ctx, "select count(*) from _inforeg_ed where event_id = 1").Scan(&duplicates))
+	require.NoError(t, conn.QueryRow(ctx, "select count(*) from _inforeg_ek where event_id = 1").Scan(&keys))
+
+	// the deduplicated event is archived, its duplicates and its key stay
+	require.Equal(t, 2, archived)
+	require.Equal(t, 1, left)
+	require.Equal(t, 2, duplicates)
+	require.Equal(t, 1, keys)
+}
+
+// PrepareTestDB creates the event tables with a deduplicated event, an event without duplicates
+// and an event still referenced by a semaphore
+func PrepareTestDB(ctx context.Context, conn *pgxpool.Pool) error {
+
+	qs := []string{
+		`DROP TABLE IF EXISTS _InfoReg_ES;`,
+		`DROP TABLE IF EXISTS _InfoReg_ED;`,
+		`DROP TABLE IF EXISTS _InfoReg_EK;`,
+		`DROP TABLE IF EXISTS _Ref_EA;`,
+		`DROP TABLE IF EXISTS _Ref_E;`,
+
+		`CREATE TABLE _Ref_E (
+			id              bigserial primary key,
+			name            varchar NOT NULL,
+			event_type_id   int,
+			lot_id          int,
+			payload         jsonb,
+			source          varchar NOT NULL DEFAULT '',
+			idempotency_key varchar,
+			entry_time      timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP);`,
+		`INSERT INTO _Ref_E(name, event_type_id, lot_id, source, idempotency_key, entry_time)
+			VALUES ('paid', 1, 1, 'shop', 'payment-1', now() - interval '2 days'),
+			('shipped', 2, 1, '', null, now() - interval '2 days'),
+			('delivered', 3, 2, '', null, now() - interval '2 days');`,
+
+		`CREATE TABLE _Ref_EA (
+			id              bigint NOT NULL primary key,
+			name            varchar NOT NULL,
+			event_type_id   int,
+			lot_id          int,
+			payload         jsonb,
+			source          varchar,
+			idempotency_key varchar,
+			entry_time      timestamp WITH TIME ZONE,
+			archive_time    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP);`,
+
+		`CREATE TABLE _InfoReg_EK (
+			source          varchar     NOT NULL,
+			idempotency_key varchar     NOT NULL,
+			event_id        bigint      NOT NULL,
+			entry_time      timestamptz NOT NULL DEFAULT now(),
+			PRIMARY KEY (source, idempotency_key));`,
+		`INSERT INTO _InfoReg_EK(source, idempotency_key, event_id)
+			VALUES ('shop', 'payment-1', 1);`,
+
+		`CREATE TABLE _InfoReg_ED (
+			id              bigserial primary key,
+			event_id        int,
+			source          varchar NOT NULL,
+			idempotency_key varchar NOT NULL,
+			hit_time        timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP);`,
+		`INSERT INTO _InfoReg_ED(event_id, source, idempotency_key)
+			VALUES (1, 'shop', 'payment-1'), (1, 'shop', 'payment-1');`,
+
+		`CREATE TABLE _InfoReg_ES (
+			id            bigserial,
+			lot_id        int,
+			semaphore_id  int,
+			event_id      int REFERENCES _Ref_E (id),
+			order_id      int,
+			entry_time    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			consumed_time timestamp WITH TIME ZONE,
+			PRIMARY KEY (lot_id, semaphore_id));`,
+		`INSERT INTO _InfoReg_ES(lot_id, semaphore_id, event_id)
+			VALUES (2, 3, 3);`,
+	}
+
+	for _, q := range qs {
+		if _, err := conn.Exec(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+	}
+}
+
+// Session is a connection of its own outside of the pool, the advisory lock is held by its session
+// and is released by the server when the connection is closed or lost
+type Session struct {
+	conn *pgx.Conn
+}
+
+// Session opens a session on a dedicated connection, so the leader does not hold a connection of the pool
+func (r *Repository) Session(ctx context.Context) (*Session, error) {
+
+	conn, err := r.storage.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{conn: conn}, nil
+}
+
+// Ping checks that the session is alive
+func (s *Session) Ping(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
+
+// Close ends the session, the locks of the session are released with it
+func (s *Session) Close(ctx context.Context) error {
+	return s.conn.Close(ctx)
+}
+
+// TryLock takes the session advisory lock of the name without waiting
+func (s *Session) TryLock(ctx context.Context, name string) (bool, error) {
+
+	var locked bool
+	err := s.conn.QueryRow(ctx, "select pg_try_advisory_lock(hashtext($1))", name).Scan(&locked)
+
+	return locked, err
+}
+
+// Unlock releases the session advisory lock of the name
+func (s *Session) Unlock(ctx context.Context, name string) error {
+
+	_, err := s.conn.Exec(ctx, "select pg_advisory_unlock(hashtext($1))", name)
+
+	return err
+}
+
+// NextEpoch starts a new epoch of the leadership and returns its number, the fencing token of the leader
+func (s *Session) NextEpoch(ctx context.Context, name string, instanceId string) (int64, error) {
+
+	var epoch int64
+	err := s.conn.QueryRow(ctx, `insert into _InfoReg_LE(name, epoch, instance_id, acquired_time)
+		values ($1, 1, $2, $3)
+		on conflict (name) do update
+			set epoch = _InfoReg_LE.epoch + 1, instance_id = excluded.instance_id, acquired_time = excluded.acquired_time
+		returning epoch`, name, instanceId, time.Now()).Scan(&epoch)
+
+	return epoch, err
+}
+
+// Epoch returns the current epoch of the name locked for share,
+// in a transaction it holds off a new leader until the transaction ends
+func (r *Repository) Epoch(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	return r.RootRepository.Get(ctx, "select epoch, instance_id from _InfoReg_LE where name = $1 for share", name)
+}
+
+// InTransaction runs fn in a transaction carried by the context
+func (r *Repository) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.RootRepository.InTransaction(ctx, fn)
+}
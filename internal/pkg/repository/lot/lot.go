@@ -0,0 +1,152 @@
+package lot
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+	"oms2/internal/pkg/util"
+)
+
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+	}
+}
+
+func (r *Repository) Lot(ctx context.Context, lotId interface{}) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("l.id, l.name, l.order_id, l.variables").
+		From("_Ref_L as l").
+		Where(squirrel.Eq{"l.id": lotId}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// UpdateVariables applies fn to the variables of the lot in its own transaction
+func (r *Repository) UpdateVariables(ctx context.Context, lotId interface{}, fn func(map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, error) {
+
+	var result map[string]interface{}
+
+	err := r.storage.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) (err error) {
+		result, err = r.UpdateVariablesTx(ctx, tx, lotId, fn)
+		return err
+	})
+
+	return result, err
+}
+
+// UpdateVariablesTx locks the lot row, applies fn to its variables and stores the result.
+// pgx.ErrNoRows is returned for an unknown lot.
+func (r *Repository) UpdateVariablesTx(ctx context.Context, tx pgx.Tx, lotId interface{}, fn func(map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("l.variables::text").
+		From("_Ref_L as l").
+		Where(squirrel.Eq{"l.id": lotId}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var current string
+	err = tx.QueryRow(ctx, _sql, args...).Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+
+	variables, err := util.ToMap(current)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := fn(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	_sql, args, err = squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_Ref_L").
+		Set("variables", string(data)).
+		Where(squirrel.Eq{"id": lotId}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, _sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// TriggerState returns trigger nodes waiting for a combination of events with the event types
+// they require and the ones the lot has received: nodes with a state for the lot and nodes
+// ahead of its current node. With nodeId the result is limited to the node.
+func (r *Repository) TriggerState(ctx context.Context, lotId interface{}, nodeId interface{}) ([]map[string]interface{}, error) {
+
+	_sql := `select
+				m.id as node_id,
+				m.name as name,
+				m.trigger_mode as trigger_mode,
+				m.trigger_count as trigger_count,
+				(select
+					coalesce(jsonb_agg(required.event_type_id order by required.event_type_id), '[]')
+				from (select m.event_trigger as event_type_id
+					where m.event_trigger is not null
+					union
+					select mt.event_type_id
+					from _refvt_mt as mt
+					where mt.node_id = m.id) as required) as required,
+				(select
+					coalesce(jsonb_agg(ts.event_type_id order by ts.event_type_id), '[]')
+				from _inforeg_ts as ts
+				where ts.lot_id = $1
+					and ts.node_id = m.id) as received
+			from _ref_m as m
+			where m.trigger_mode <> 'any'
+				and (exists(select 1 from _inforeg_ts as ts where ts.lot_id = $1 and ts.node_id = m.id)
+					or m.id >= (select min(csr.node_id) from _inforeg_csr as csr where csr.lot_id = $1))`
+
+	var args []interface{}
+	args = append(args, lotId)
+
+	if nodeId != nil {
+		_sql += ` and m.id = $2`
+		args = append(args, nodeId)
+	}
+
+	return r.RootRepository.Get(ctx, _sql+` order by m.id`, args...)
+}
@@ -6,12 +6,40 @@ import (
 	"time"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
 	"go.uber.org/zap"
 
 	"oms2/internal/pkg/repository/root"
 	"oms2/internal/pkg/storage/postgres"
+	"oms2/internal/pkg/trigger"
 )
 
+// triggerEvents returns the event types of trigger nodes in the given modes as node_id, event_trigger:
+// the event_trigger of the node and the event types of its _RefVT_MT
+func triggerEvents(modes ...string) string {
+
+	in := ""
+	for i, mode := range modes {
+		if i > 0 {
+			in += ", "
+		}
+		in += "'" + mode + "'"
+	}
+
+	return fmt.Sprintf(`select m.id as node_id, m.event_trigger as event_trigger
+			from _Ref_M as m
+			where m.event_trigger is not null and m.trigger_mode in (%[1]s)
+			union
+			select mt.node_id, mt.event_type_id
+			from _RefVT_MT as mt
+				inner join _Ref_M as m on m.id = mt.node_id
+			where m.trigger_mode in (%[1]s)`, in)
+}
+
+// windowCondition keeps semaphores received within the window of their event type,
+// the arguments are the current time and the default window in seconds
+const windowCondition = "semaphores.entry_time >= ?::timestamptz - coalesce(et.window_seconds, ?) * interval '1 second'"
+
 //var (
 //	ErrBadModel         = errors.New("bad model")
 //	ErrValidationFailed = errors.New("validation failed")
@@ -31,6 +59,8 @@ func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger)
 	}
 }
 
+// Processing returns the current steps of the lots that are due, a step put off by DeferProcessing
+// is not returned until its next_run_time like in the lot selection
 func (r *Repository) Processing(ctx context.Context, lots []map[string]interface{}) ([]map[string]interface{}, error) {
 
 	lotsId := make([]int32, 0)
@@ -42,16 +72,32 @@ func (r *Repository) Processing(ctx context.Context, lots []map[string]interface
 		Select("ln.thread as thread," +
 			"ln.id as proc_id," +
 			"l.id as lot_id," +
+			"l.variables as variables," +
 			"n.id as node_id," +
 			"n.action as action," +
+			"n.params as params," +
 			"n.name as name," +
 			"n.type as type," +
 			"n.waiting_time as waiting_time," +
-			"ln.entry_time as entry_time").
+			"n.fail_node_id as fail_node_id," +
+			"n.pending_timeout_seconds as pending_timeout_seconds," +
+			"ln.entry_time as entry_time," +
+			"ln.attempt as attempt," +
+			"l.order_id as order_id," +
+			"le.id as event_id," +
+			"le.event_type as event_type," +
+			"le.payload as event_payload").
 		From("_InfoReg_CSR as ln").
 		InnerJoin("_Ref_L as l ON ln.lot_id = l.id").
 		InnerJoin("_Ref_M as n ON ln.node_id = n.id").
+		LeftJoin("lateral (select e.id, et.code as event_type, e.payload " +
+			"from _Ref_E as e inner join _Ref_ET as et on et.id = e.event_type_id " +
+			"where e.lot_id = l.id order by e.id desc limit 1) as le on true").
 		Where(squirrel.Eq{"lot_id": lotsId}).
+		Where(squirrel.Or{
+			squirrel.Eq{"ln.next_run_time": nil},
+			squirrel.LtOrEq{"ln.next_run_time": time.Now()},
+		}).
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 
@@ -63,7 +109,10 @@ func (r *Repository) Processing(ctx context.Context, lots []map[string]interface
 	return r.RootRepository.Get(ctx, _sql, args...)
 }
 
-func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]interface{}) ([]map[string]interface{}, error) {
+// FindEventsPerStep returns unconsumed semaphores received within the event type window
+// that move their lots from the current node to a trigger node waiting for any of its event types.
+// Trigger nodes waiting for a combination of events are handled by FindTriggerEvents.
+func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]interface{}, window time.Duration) ([]map[string]interface{}, error) {
 
 	nodes, _, err := squirrel.Select("nodes.id as node_id," +
 		"nodes.type as node_type," +
@@ -87,10 +136,11 @@ func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]in
 
 		_sql, args, err := squirrel.StatementBuilder.
 			Select(
-				"ltnds.id as proc_id," +
-					"events.lot_id as lot_id," +
-					"events.event_type_id as event_type_id," +
-					"ne.node_id as node_id," +
+				"ltnds.id as proc_id,"+
+					"semaphores.id as semaphore_id,"+
+					"events.lot_id as lot_id,"+
+					"events.event_type_id as event_type_id,"+
+					"ne.node_id as node_id,"+
 					"ltnds.node_id as prev_id").
 			From("_InfoReg_ES as semaphores").
 			LeftJoin("_Ref_E as events on semaphores.event_id = events.id").
@@ -98,8 +148,11 @@ func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]in
 			InnerJoin(fmt.Sprintf("(%s) as nodes on events.event_type_id = nodes.event_type_id "+
 				"and ltnds.node_id = nodes.node_id", nodes)).
 			InnerJoin(fmt.Sprintf("(%s) as ne on events.event_type_id = ne.event_trigger "+
-				"and nodes.node_id <= ne.node_id", nodes)).
+				"and nodes.node_id <= ne.node_id", triggerEvents(trigger.ModeAny))).
+			InnerJoin("_Ref_ET as et on et.id = semaphores.semaphore_id").
 			Where(squirrel.Eq{"semaphores.lot_id": lotsId}).
+			Where(squirrel.Eq{"semaphores.consumed_time": nil}).
+			Where(windowCondition, time.Now(), windowSeconds(window)).
 			PlaceholderFormat(squirrel.Dollar).
 			ToSql()
 		if err != nil {
@@ -113,10 +166,11 @@ func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]in
 
 	_sql, args, err := squirrel.StatementBuilder.
 		Select(
-			"ltnds.id as proc_id," +
-				"events.lot_id as lot_id," +
-				"events.event_type_id as event_type_id," +
-				"ne.node_id as node_id," +
+			"ltnds.id as proc_id,"+
+				"semaphores.id as semaphore_id,"+
+				"events.lot_id as lot_id,"+
+				"events.event_type_id as event_type_id,"+
+				"ne.node_id as node_id,"+
 				"ltnds.node_id as prev_id").
 		From("_InfoReg_ES as semaphores").
 		LeftJoin("_Ref_E as events on semaphores.event_id = events.id").
@@ -124,7 +178,10 @@ func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]in
 		InnerJoin(fmt.Sprintf("(%s) as nodes on events.event_type_id = nodes.event_type_id "+
 			"and ltnds.node_id = nodes.node_id", nodes)).
 		InnerJoin(fmt.Sprintf("(%s) as ne on events.event_type_id = ne.event_trigger "+
-			"and nodes.node_id <= ne.node_id", nodes)).
+			"and nodes.node_id <= ne.node_id", triggerEvents(trigger.ModeAny))).
+		InnerJoin("_Ref_ET as et on et.id = semaphores.semaphore_id").
+		Where(squirrel.Eq{"semaphores.consumed_time": nil}).
+		Where(windowCondition, time.Now(), windowSeconds(window)).
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
@@ -136,72 +193,319 @@ func (r *Repository) FindEventsPerStep(ctx context.Context, lots []map[string]in
 
 }
 
-func (r *Repository) UpdateProcessing(ctx context.Context, data map[string]interface{}, nodeId int64) (uint, error) {
+// ActionNodes returns the action nodes of the maps with their actions
+func (r *Repository) ActionNodes(ctx context.Context) ([]map[string]interface{}, error) {
 
-	var _sql string
-	var args []interface{}
-	var err error
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("n.id, n.name, n.action, n.params").
+		From("_Ref_M as n").
+		Where(squirrel.Eq{"n.type": "action"}).
+		OrderBy("n.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
 
-	if data["proc_id"] == 0 {
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
 
-		_sql, args, err = squirrel.
+// ActionWait returns the unresolved wait of the asynchronous action of the lot created on the node
+// since the lot entered it, with the last event of the lot carrying the token of the wait
+func (r *Repository) ActionWait(ctx context.Context, lotId interface{}, nodeId interface{}, entryTime interface{}) ([]map[string]interface{}, error) {
+
+	_sql := `select aw.id, aw.token, aw.status, aw.deadline, aw.result, aw.error,
+			te.id as token_event_id, te.payload as token_event_payload
+		from _InfoReg_AW as aw
+			left join lateral (select e.id, e.payload
+				from _Ref_E as e
+				where e.lot_id = aw.lot_id and e.payload ->> 'action_token' = aw.token
+				order by e.id desc
+				limit 1) as te on true
+		where aw.lot_id = $1 and aw.node_id = $2 and aw.created_time >= $3 and aw.resolved_time is null
+		order by aw.id desc
+		limit 1`
+
+	return r.RootRepository.Get(ctx, _sql, lotId, nodeId, entryTime)
+}
+
+// CreateActionWait registers the wait of an asynchronous action
+func (r *Repository) CreateActionWait(ctx context.Context, values map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_InfoReg_AW").
+		SetMap(values).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+// ResolveActionWait sets the final status of the wait once the robot has moved the lot
+func (r *Repository) ResolveActionWait(ctx context.Context, id interface{}, status string, errorText interface{}, eventId interface{}) (uint, error) {
+
+	now := time.Now()
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_AW").
+		Set("status", status).
+		Set("error", squirrel.Expr("coalesce(?, error)", errorText)).
+		Set("event_id", eventId).
+		Set("completed_time", squirrel.Expr("coalesce(completed_time, ?)", now)).
+		Set("resolved_time", now).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+// DecisionNodes returns the decision nodes of the maps
+func (r *Repository) DecisionNodes(ctx context.Context) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("n.id, n.name").
+		From("_Ref_M as n").
+		Where(squirrel.Eq{"n.type": "decision"}).
+		OrderBy("n.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// DecisionBranches returns the branches of decision nodes in order of evaluation,
+// all of them if no node is given
+func (r *Repository) DecisionBranches(ctx context.Context, nodeIds ...interface{}) ([]map[string]interface{}, error) {
+
+	query := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("mb.node_id, n.name as node_name, mb.position, mb.condition, mb.target_node_id").
+		From("_RefVT_MB as mb").
+		InnerJoin("_Ref_M as n on n.id = mb.node_id").
+		OrderBy("mb.node_id", "mb.position")
+
+	if len(nodeIds) > 0 {
+		query = query.Where(squirrel.Eq{"mb.node_id": nodeIds})
+	}
+
+	_sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// FindTriggerEvents returns unconsumed semaphores received within the event type window
+// for trigger nodes waiting for a combination of events, ahead of the current node of the lot
+// that listens for the event type
+func (r *Repository) FindTriggerEvents(ctx context.Context, lots []map[string]interface{}, window time.Duration) ([]map[string]interface{}, error) {
+
+	query := squirrel.StatementBuilder.
+		Select(
+			"ltnds.id as proc_id,"+
+				"semaphores.id as semaphore_id,"+
+				"semaphores.event_id as event_id,"+
+				"semaphores.lot_id as lot_id,"+
+				"semaphores.semaphore_id as event_type_id,"+
+				"triggers.node_id as node_id,"+
+				"ltnds.node_id as prev_id").
+		From("_InfoReg_ES as semaphores").
+		InnerJoin("_Ref_ET as et on et.id = semaphores.semaphore_id").
+		InnerJoin("_InfoReg_CSR as ltnds on semaphores.lot_id = ltnds.lot_id").
+		InnerJoin("_RefVT_ME as me on me.node_id = ltnds.node_id and me.event_type_id = semaphores.semaphore_id").
+		InnerJoin(fmt.Sprintf("(%s) as triggers on semaphores.semaphore_id = triggers.event_trigger "+
+			"and ltnds.node_id <= triggers.node_id", triggerEvents(trigger.ModeAll, trigger.ModeNOfM))).
+		Where(squirrel.Eq{"semaphores.consumed_time": nil}).
+		Where(windowCondition, time.Now(), windowSeconds(window)).
+		OrderBy("semaphores.id").
+		PlaceholderFormat(squirrel.Dollar)
+
+	if lots != nil {
+		var lotsId []interface{}
+		for _, lot := range lots {
+			lotsId = append(lotsId, lot["lot_id"])
+		}
+		query = query.Where(squirrel.Eq{"semaphores.lot_id": lotsId})
+	}
+
+	_sql, args, err := query.ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// RecordTriggerEvent adds the event type of the semaphore to the trigger state of the lot
+// and consumes the semaphore, the state keeps the first event of each type
+func (r *Repository) RecordTriggerEvent(ctx context.Context, data map[string]interface{}) error {
+
+	return r.storage.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+
+		_sql, args, err := squirrel.
 			StatementBuilder.
 			PlaceholderFormat(squirrel.Dollar).
-			Insert("_InfoReg_CSR").
-			Columns("lot_id", "node_id", "entry_time").
-			Values(data["lotId"], nodeId, time.Now()).
-			Suffix("RETURNING id").
+			Insert("_InfoReg_TS").
+			Columns("lot_id", "node_id", "event_type_id", "event_id").
+			Values(data["lot_id"], data["node_id"], data["event_type_id"], data["event_id"]).
+			Suffix("ON CONFLICT (lot_id, node_id, event_type_id) DO NOTHING").
 			ToSql()
-
 		if err != nil {
-			r.zl.Sugar().Error(err)
-			return 0, err
+			return err
 		}
 
-	} else {
-
-		values := make(map[string]interface{})
-		values["node_id"] = nodeId
-		values["entry_time"] = time.Now()
+		if _, err = tx.Exec(ctx, _sql, args...); err != nil {
+			return err
+		}
 
 		_sql, args, err = squirrel.
 			StatementBuilder.
 			PlaceholderFormat(squirrel.Dollar).
-			Update("_InfoReg_CSR").
-			SetMap(values).
-			Where(squirrel.Eq{"id": data["proc_id"]}).
-			Suffix("RETURNING id").
+			Update("_InfoReg_ES").
+			Set("consumed_time", time.Now()).
+			Where(squirrel.Eq{"id": data["semaphore_id"]}).
 			ToSql()
-
 		if err != nil {
-			r.zl.Sugar().Error(err)
-			return 0, err
+			return err
 		}
 
+		_, err = tx.Exec(ctx, _sql, args...)
+
+		return err
+	})
+}
+
+// ClearTriggerState removes the trigger state of the lot for trigger nodes up to nodeId,
+// with nil nodeId the whole state of the lot is removed
+func (r *Repository) ClearTriggerState(ctx context.Context, lotId interface{}, nodeId interface{}) error {
+
+	query := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Delete("_InfoReg_TS").
+		Where(squirrel.Eq{"lot_id": lotId})
+
+	if nodeId != nil {
+		query = query.Where(squirrel.LtOrEq{"node_id": nodeId})
+	}
+
+	_sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.RootRepository.Delete(ctx, _sql, args...)
+}
+
+// IncrementAttempt counts a committed action run of the lot, if the lot is still on the node with the attempt.
+// It returns 0 for a lot that has moved or whose attempt was counted by another run.
+func (r *Repository) IncrementAttempt(ctx context.Context, procId interface{}, nodeId int64, attempt int64) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_CSR").
+		Set("attempt", squirrel.Expr("attempt + 1")).
+		Where(squirrel.Eq{"id": procId, "node_id": nodeId, "attempt": attempt}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+// DeferProcessing puts the current step of the lot off until the time
+func (r *Repository) DeferProcessing(ctx context.Context, procId interface{}, until time.Time) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_CSR").
+		Set("next_run_time", until).
+		Where(squirrel.Eq{"id": procId}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+// RecordStep writes a move of the lot to the step history
+func (r *Repository) RecordStep(ctx context.Context, values map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_InfoReg_SH").
+		SetMap(values).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
 	}
 
 	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
 }
 
-func (r *Repository) UpdateProcessingActivity(ctx context.Context, data []map[string]interface{}, threadKey string) (uint, error) {
+// ConsumeSemaphore marks the semaphore that moved its lot,
+// it is not matched again and is moved to the archive by the housekeeping
+func (r *Repository) ConsumeSemaphore(ctx context.Context, semaphoreId interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_ES").
+		Set("consumed_time", time.Now()).
+		Where(squirrel.Eq{"id": semaphoreId}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) UpdateProcessing(ctx context.Context, data map[string]interface{}, nodeId int64) (uint, error) {
 
 	var _sql string
 	var args []interface{}
 	var err error
 
-	if len(threadKey) == 0 {
+	if data["proc_id"] == 0 {
 
-		insertQuery := squirrel.
+		_sql, args, err = squirrel.
 			StatementBuilder.
 			PlaceholderFormat(squirrel.Dollar).
-			Insert("_InfoReg_PA")
-
-		for _, item := range data {
-			insertQuery = insertQuery.
-				SetMap(item)
-		}
-
-		_sql, args, err = insertQuery.Suffix("RETURNING id").ToSql()
+			Insert("_InfoReg_CSR").
+			Columns("lot_id", "node_id", "entry_time").
+			Values(data["lotId"], nodeId, time.Now()).
+			Suffix("RETURNING id").
+			ToSql()
 
 		if err != nil {
 			r.zl.Sugar().Error(err)
@@ -210,18 +514,16 @@ func (r *Repository) UpdateProcessingActivity(ctx context.Context, data []map[st
 
 	} else {
 
-		updateQuery := squirrel.
+		values := make(map[string]interface{})
+		values["node_id"] = nodeId
+		values["entry_time"] = time.Now()
+
+		_sql, args, err = squirrel.
 			StatementBuilder.
 			PlaceholderFormat(squirrel.Dollar).
-			Update("_InfoReg_PA")
-
-		for _, item := range data {
-			updateQuery = updateQuery.
-				SetMap(item)
-		}
-
-		_sql, args, err = updateQuery.
-			Where(squirrel.Eq{"id": threadKey}).
+			Update("_InfoReg_CSR").
+			SetMap(values).
+			Where(squirrel.Eq{"id": data["proc_id"]}).
 			Suffix("RETURNING id").
 			ToSql()
 
@@ -235,7 +537,9 @@ func (r *Repository) UpdateProcessingActivity(ctx context.Context, data []map[st
 	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
 }
 
-func (r *Repository) GetOrderByLotsFromProcessingRegister(ctx context.Context) ([]map[string]interface{}, error) {
+// GetOrderByLotsFromProcessingRegister returns lots due to run and lots with unconsumed semaphores
+// received within the window of their event type, window is used for types without their own
+func (r *Repository) GetOrderByLotsFromProcessingRegister(ctx context.Context, window time.Duration) ([]map[string]interface{}, error) {
 
 	_sql := `select
 				inner_query.lot_id as lot_id,
@@ -254,10 +558,12 @@ func (r *Repository) GetOrderByLotsFromProcessingRegister(ctx context.Context) (
 				   max(5000),
 				   max(0)
 			from _inforeg_es as es
+				inner join _ref_et as et on et.id = es.semaphore_id
 				inner join _inforeg_csr ic on es.lot_id = ic.lot_id
 				inner join _refvt_me rme on ic.node_id = rme.node_id
 					and rme.event_type_id = es.semaphore_id
-			where es.entry_time >= $2
+			where es.entry_time >= $2::timestamptz - coalesce(et.window_seconds, $3) * interval '1 second'
+				and es.consumed_time is null
 			group by
 				es.lot_id) as inner_query
 			
@@ -270,18 +576,81 @@ func (r *Repository) GetOrderByLotsFromProcessingRegister(ctx context.Context) (
 
 	var args []interface{}
 	args = append(args, time.Now())
-	args = append(args, time.Now().Add(-24*time.Hour))
+	args = append(args, time.Now())
+	args = append(args, windowSeconds(window))
 
 	return r.RootRepository.Get(ctx, _sql, args...)
 }
 
-func (r *Repository) GetRegisterActivityList(ctx context.Context) ([]map[string]interface{}, error) {
+// reclaimExpired moves the expired leases matching the condition to the _InfoReg_PAR log,
+// $1 is the current time, $2 the instance reclaiming them. Rows locked by another replica are skipped.
+const reclaimExpired = `with expired as (
+		delete from _InfoReg_PA
+		where id in (select pa.id
+			from _InfoReg_PA as pa
+			where pa.lease_until <= $1 and %s
+			for update skip locked)
+		returning order_id, thread_key, thread_id, group_id, instance_id, start_time, heartbeat_time, lease_until, pool, lot_ids)
+	insert into _InfoReg_PAR(order_id, thread_key, thread_id, group_id, instance_id, start_time, heartbeat_time, lease_until, pool, lot_ids, reclaimed_time, reclaimed_by)
+	select order_id, thread_key, thread_id, group_id, instance_id, start_time, heartbeat_time, lease_until, pool, lot_ids, $1, $2
+	from expired
+	returning id, order_id, thread_key, thread_id, group_id, instance_id, start_time, heartbeat_time, lease_until, pool, to_json(lot_ids) as lot_ids, reclaimed_time, reclaimed_by`
+
+// ClaimOrders leases the orders of the lots to the thread of the pool of the instance until the lease expires
+// and returns the claimed ones, lotIds[i] is a lot of the order orderIds[i] and the lease keeps the lots of its order.
+// Expired leases are taken over and logged as reclaimed, rows locked by another replica are skipped,
+// an order leased by another replica is left out by the unique order_id.
+func (r *Repository) ClaimOrders(ctx context.Context, orderIds []int64, lotIds []int64, threadKey string, groupId interface{}, pool string, instanceId string, lease time.Duration) ([]int64, error) {
+
+	claimed := make([]int64, 0, len(orderIds))
+	if len(orderIds) == 0 {
+		return claimed, nil
+	}
+
+	now := time.Now()
+
+	err := r.storage.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+
+		_, err := tx.Exec(ctx, fmt.Sprintf(reclaimExpired, "pa.order_id = any($3)"), now, instanceId, orderIds)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `insert into _InfoReg_PA(order_id, thread_key, thread_id, group_id, start_time, instance_id, lease_until, heartbeat_time, pool, lot_ids)
+			select o.order_id, $2, $2, $3, $4, $5, $6, $4, $7, array_agg(distinct o.lot_id)
+			from unnest($1::int[], $8::int[]) as o(order_id, lot_id)
+			group by o.order_id
+			on conflict (order_id) do nothing
+			returning order_id`, orderIds, threadKey, groupId, now, instanceId, now.Add(lease), pool, lotIds)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var orderId int64
+			if err := rows.Scan(&orderId); err != nil {
+				return err
+			}
+			claimed = append(claimed, orderId)
+		}
+
+		return rows.Err()
+	})
+
+	return claimed, err
+}
+
+// GetRegisterActivityList returns the threads of the instance holding live leases
+func (r *Repository) GetRegisterActivityList(ctx context.Context, instanceId string) ([]map[string]interface{}, error) {
 
 	_sql, args, err := squirrel.
 		StatementBuilder.
 		PlaceholderFormat(squirrel.Dollar).
 		Select("pa.thread_key, pa.thread_id, pa.group_id, max(pa.start_time) as start_time").
 		From("_InfoReg_PA as pa").
+		Where(squirrel.Eq{"pa.instance_id": instanceId}).
+		Where(squirrel.Gt{"pa.lease_until": time.Now()}).
 		GroupBy("thread_key, thread_id, group_id, start_time").
 		ToSql()
 	if err != nil {
@@ -292,12 +661,168 @@ func (r *Repository) GetRegisterActivityList(ctx context.Context) ([]map[string]
 
 }
 
-func (r *Repository) GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx context.Context, params map[string]interface{}) ([]map[string]interface{}, error) {
+// Heartbeat extends the leases of the thread of the instance and returns the number of rows renewed,
+// zero means the leases were reclaimed and the orders may be processed by another thread
+func (r *Repository) Heartbeat(ctx context.Context, threadKey string, instanceId string, lease time.Duration) (int, error) {
+
+	now := time.Now()
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_PA").
+		Set("heartbeat_time", now).
+		Set("lease_until", now.Add(lease)).
+		Where(squirrel.Eq{"thread_key": threadKey, "instance_id": instanceId}).
+		Suffix("RETURNING order_id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	renewed, err := r.RootRepository.Get(ctx, _sql, args...)
+
+	return len(renewed), err
+}
+
+// HoldsLease checks that the thread of the instance holds a live lease of the order of the lot.
+// The lease row is locked against the reaper until the end of the transaction,
+// the heartbeat can still renew it.
+func (r *Repository) HoldsLease(ctx context.Context, threadKey string, instanceId string, lotId int64) (bool, error) {
+
+	held, err := r.RootRepository.Get(ctx, `select pa.order_id
+		from _InfoReg_PA as pa
+		where pa.thread_key = $1
+			and pa.instance_id = $2
+			and pa.lease_until > $3
+			and $4 = any(pa.lot_ids)
+		for key share`, threadKey, instanceId, time.Now(), lotId)
+
+	return len(held) > 0, err
+}
+
+// ReclaimExpired releases up to limit leases whose heartbeat has expired and returns the reclaimed rows
+func (r *Repository) ReclaimExpired(ctx context.Context, reclaimedBy string, limit int) ([]map[string]interface{}, error) {
+
+	condition := fmt.Sprintf("pa.id in (select id from _InfoReg_PA where lease_until <= $1 order by lease_until limit %d)", limit)
+
+	return r.RootRepository.Get(ctx, fmt.Sprintf(reclaimExpired, condition), time.Now(), reclaimedBy)
+}
+
+// HeldActivity returns the live leases, of one instance and one pool if instanceId and pool are set
+func (r *Repository) HeldActivity(ctx context.Context, instanceId string, pool string) ([]map[string]interface{}, error) {
+
+	builder := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("pa.order_id, pa.thread_key, pa.thread_id, pa.group_id, pa.pool, to_json(pa.lot_ids) as lot_ids, pa.instance_id, pa.start_time, pa.heartbeat_time, pa.lease_until").
+		From("_InfoReg_PA as pa").
+		Where(squirrel.Gt{"pa.lease_until": time.Now()}).
+		OrderBy("pa.start_time")
+
+	if len(instanceId) > 0 {
+		builder = builder.Where(squirrel.Eq{"pa.instance_id": instanceId})
+	}
+	if len(pool) > 0 {
+		builder = builder.Where(squirrel.Eq{"pa.pool": pool})
+	}
+
+	_sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// CountActivity returns the number of live and expired leases
+func (r *Repository) CountActivity(ctx context.Context) ([]map[string]interface{}, error) {
+
+	return r.RootRepository.Get(ctx, `select
+			count(*) filter (where lease_until > $1) as held,
+			count(*) filter (where lease_until <= $1) as expired
+		from _InfoReg_PA`, time.Now())
+}
+
+// ReclaimedActivity returns the latest reclaimed leases, of one order if orderId is set
+func (r *Repository) ReclaimedActivity(ctx context.Context, orderId int64, limit uint64) ([]map[string]interface{}, error) {
+
+	builder := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("par.id, par.order_id, par.thread_key, par.thread_id, par.group_id, par.pool, to_json(par.lot_ids) as lot_ids, par.instance_id, par.start_time, par.heartbeat_time, par.lease_until, par.reclaimed_time, par.reclaimed_by").
+		From("_InfoReg_PAR as par").
+		OrderBy("par.reclaimed_time desc", "par.id desc").
+		Limit(limit)
+
+	if orderId != 0 {
+		builder = builder.Where(squirrel.Eq{"par.order_id": orderId})
+	}
+
+	_sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// groupLots selects the lots of the orders of the processing groups the robot takes next and no thread leases:
+// lots due by next_run_time and lots with an unconsumed semaphore of an event their node handles
+// within the window of the event type. $1 and $2 are now, $3 the window for types without their own.
+// The group selection and the demand of the groups share it, so the demand counts what is selected.
+const groupLots = `select
+			lots.group_id,
+			lots.order_id,
+			lots.lot_id,
+			lots.weight,
+			lots.thread,
+			lots.due_time
+		from (select
+				pg.group_id as group_id,
+				pg.order_id as order_id,
+				csr.lot_id as lot_id,
+				csr.weight as weight,
+				case when csr.thread >= 900 then csr.thread else 0 end as thread,
+				csr.next_run_time as due_time
+			from _inforeg_pg as pg
+				inner join _ref_o as ro on ro.id = pg.order_id
+				inner join _ref_l as rl on rl.order_id = ro.id
+				inner join _inforeg_csr as csr on csr.lot_id = rl.id
+			where csr.next_run_time <= $1
+
+			union all
+
+			select
+				pg.group_id,
+				pg.order_id,
+				es.lot_id,
+				max(5000),
+				max(0),
+				min(es.entry_time)
+			from _inforeg_pg as pg
+				inner join _inforeg_es as es on es.order_id = pg.order_id
+				inner join _ref_et as et on et.id = es.semaphore_id
+				inner join _inforeg_csr as csr on csr.lot_id = es.lot_id
+				inner join _refvt_me as rme on rme.node_id = csr.node_id
+					and rme.event_type_id = es.semaphore_id
+			where es.entry_time >= $2::timestamptz - coalesce(et.window_seconds, $3) * interval '1 second'
+				and es.consumed_time is null
+				and csr.next_run_time > $1
+			group by
+				pg.group_id,
+				pg.order_id,
+				es.lot_id) as lots
+			left join _inforeg_pa as pa on pa.order_id = lots.order_id and pa.lease_until > $1
+		where pa.order_id is null`
+
+func (r *Repository) GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx context.Context, params map[string]interface{}, window time.Duration) ([]map[string]interface{}, error) {
 
 	_sql := ``
 	var args []interface{}
 	args = append(args, time.Now())
-	args = append(args, time.Now().Add(-24*time.Hour))
+	args = append(args, time.Now())
+	args = append(args, windowSeconds(window))
 
 	groupId := params["group"]
 	if groupId == -1 {
@@ -320,15 +845,17 @@ func (r *Repository) GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx
 				   max(5000),
 				   max(0)
 			from _inforeg_es as es
+				inner join _ref_et as et on et.id = es.semaphore_id
 				inner join _inforeg_csr ic on es.lot_id = ic.lot_id
 				inner join _refvt_me rme on ic.node_id = rme.node_id
 					and rme.event_type_id = es.semaphore_id
-			where es.entry_time >= $2
+			where es.entry_time >= $2::timestamptz - coalesce(et.window_seconds, $3) * interval '1 second'
+				and es.consumed_time is null
 			group by
 				es.lot_id) as inner_query
 			
 			left join _ref_l as lots on inner_query.lot_id = lots.id
-			left join _inforeg_pa as pa on lots.order_id = pa.order_id
+			left join _inforeg_pa as pa on lots.order_id = pa.order_id and pa.lease_until > $1
 
 			where pa.order_id is null
 
@@ -340,53 +867,16 @@ func (r *Repository) GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx
 	} else {
 
 		_sql = `select
-					inner_query.lot_id as lot_id,
-					inner_query.order_id as order_id,
-					inner_query.thread as thread,
-					sum(inner_query.weight) as weight
-				from (select
-						  csr.lot_id as lot_id,
-						  csr.weight as weight,
-						  case when csr.thread >= 900 then csr.thread else 0 end as thread,
-						  pg.order_id as order_id
-					  from _inforeg_pg as pg
-								inner join _ref_o ro on ro.id = pg.order_id
-								inner join _ref_l rl on ro.id = rl.order_id
-							   inner join _inforeg_csr as csr on rl.id = csr.lot_id
-							   left join _inforeg_pa as pa on pg.order_id = pa.order_id
-					  where
-							  csr.next_run_time <= $1
-						and pa.order_id is null
-						and pg.group_id = $3
-				
-					  union all
-				
-					  select
-						  es.lot_id,
-						  max(5000),
-						  max(0),
-						  pg.order_id as order_id
-					  from _inforeg_pg as pg
-							   inner join _inforeg_es as es on pg.order_id = es.order_id
-							   inner join _inforeg_csr csr on es.lot_id = csr.lot_id
-							   inner join _refvt_me rme on csr.node_id = rme.node_id
-						  and rme.event_type_id = es.semaphore_id
-							   left join _inforeg_pa as pa
-										 on pg.order_id = pa.order_id
-					  where
-							  es.entry_time >= $2
-						and csr.next_run_time > $1
-						and pa.order_id is null
-						and pg.group_id = $3
-					  group by
-						  es.lot_id,
-						  pg.order_id
-					 ) as inner_query
-				
+					due.lot_id as lot_id,
+					due.order_id as order_id,
+					due.thread as thread,
+					sum(due.weight) as weight
+				from (` + groupLots + `) as due
+				where due.group_id = $4
 				group by
-					inner_query.lot_id,
-					inner_query.order_id,
-					inner_query.thread
+					due.lot_id,
+					due.order_id,
+					due.thread
 				order by weight desc`
 
 		args = append(args, groupId)
@@ -395,6 +885,24 @@ func (r *Repository) GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx
 	return r.RootRepository.Get(ctx, _sql, args...)
 }
 
+// LotNodeGroups returns the group_id of the current node of the lots
+func (r *Repository) LotNodeGroups(ctx context.Context, lotIds []int64) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("csr.lot_id, n.group_id").
+		From("_InfoReg_CSR as csr").
+		InnerJoin("_Ref_M as n on n.id = csr.node_id").
+		Where("csr.lot_id = any(?)", lotIds).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
 func (r *Repository) DeleteFromRegisterActivityByThreadId(ctx context.Context, uid string) error {
 
 	_sql, args, err := squirrel.
@@ -412,14 +920,24 @@ func (r *Repository) DeleteFromRegisterActivityByThreadId(ctx context.Context, u
 
 }
 
+// ProcessingGroupList returns the groups of the orders with their settings from _Ref_PG,
+// the settings of a group without them are null and enabled is true
 func (r *Repository) ProcessingGroupList(ctx context.Context) ([]map[string]interface{}, error) {
 
 	_sql, args, err := squirrel.
 		StatementBuilder.
 		PlaceholderFormat(squirrel.Dollar).
-		Select("pg.group_id as group_id").
+		Select("pg.group_id as group_id",
+			"ref.concurrency_limit as concurrency_limit",
+			"ref.batch_size as batch_size",
+			"coalesce(ref.poll_interval_seconds, 0) as poll_interval_seconds",
+			"coalesce(ref.enabled, true) as enabled",
+			"coalesce(ref.weight, 1) as weight",
+			"coalesce(ref.min_threads, 0) as min_threads").
 		From("_InfoReg_PG as pg").
-		GroupBy("group_id").
+		LeftJoin("_Ref_PG as ref on ref.id = pg.group_id").
+		GroupBy("pg.group_id", "ref.id").
+		OrderBy("pg.group_id").
 		ToSql()
 	if err != nil {
 		return nil, err
@@ -427,3 +945,46 @@ func (r *Repository) ProcessingGroupList(ctx context.Context) ([]map[string]inte
 
 	return r.RootRepository.Get(ctx, _sql, args...)
 }
+
+// ProcessingGroupDemand returns per group the number of orders the robot would take next as depth
+// and the due time of the oldest of them, it counts the lots the group selection takes by groupLots
+func (r *Repository) ProcessingGroupDemand(ctx context.Context, window time.Duration) ([]map[string]interface{}, error) {
+
+	_sql := `select
+			due.group_id as group_id,
+			count(distinct due.order_id) as depth,
+			min(due.due_time) as oldest_due_time
+		from (` + groupLots + `) as due
+		group by due.group_id`
+
+	return r.RootRepository.Get(ctx, _sql, time.Now(), time.Now(), windowSeconds(window))
+}
+
+// Load samples the load of the database pool for the adaptive concurrency
+func (r *Repository) Load() postgres.PoolLoad {
+	return r.storage.Load()
+}
+
+// Setting returns the robot setting of the name set at runtime, no rows if it is not set
+func (r *Repository) Setting(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	return r.RootRepository.Get(ctx, "select name, value, updated_time from _InfoReg_RS where name = $1", name)
+}
+
+// SaveSetting sets the robot setting of the name for all instances
+func (r *Repository) SaveSetting(ctx context.Context, name string, value string) error {
+	_, err := r.RootRepository.CreateOrUpdate(ctx, `insert into _InfoReg_RS(name, value, updated_time)
+		values ($1, $2, $3)
+		on conflict (name) do update set value = excluded.value, updated_time = excluded.updated_time
+		returning 1`, name, value, time.Now())
+
+	return err
+}
+
+// DeleteSetting returns the robot setting of the name to the configuration of the instances
+func (r *Repository) DeleteSetting(ctx context.Context, name string) error {
+	return r.RootRepository.Delete(ctx, "delete from _InfoReg_RS where name = $1", name)
+}
+
+func windowSeconds(window time.Duration) int64 {
+	return int64(window / time.Second)
+}
@@ -96,7 +96,7 @@ func TestRepository_FindEventsPerStep(t *testing.T) {
 	rootRepo := root.NewRepository(p, zl)
 
 	robotRepo := NewRepository(p, rootRepo, zl)
-	events, err := robotRepo.FindEventsPerStep(ctx, nil)
+	events, err := robotRepo.FindEventsPerStep(ctx, nil, 24*time.Hour)
 	require.NoError(t, err)
 	require.Greater(t, len(events), 1)
 }
@@ -129,7 +129,7 @@ func TestRepository_RecordToNextStep(t *testing.T) {
 	rootRepo := root.NewRepository(p, zl)
 
 	robotRepo := NewRepository(p, rootRepo, zl)
-	events, err := robotRepo.FindEventsPerStep(ctx, nil)
+	events, err := robotRepo.FindEventsPerStep(ctx, nil, 24*time.Hour)
 	require.NoError(t, err)
 	require.Greater(t, len(events), 1)
 
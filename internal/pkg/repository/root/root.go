@@ -2,6 +2,7 @@ package root
 
 import (
 	"context"
+	"github.com/jackc/pgx/v4"
 	"go.uber.org/zap"
 
 	"oms2/internal/pkg/storage/postgres"
@@ -31,29 +32,34 @@ func NewRepository(s *postgres.Postgres, log *zap.Logger) *Repository {
 	}
 }
 
+// CreateOrUpdate runs a statement returning id, a statement returning no row gives 0.
+// Inside a transaction of the context the statement runs in a savepoint of it.
 func (r *Repository) CreateOrUpdate(ctx context.Context, _sql string, args ...interface{}) (uint, error) {
 
-	conn, err := r.storage.Conn(ctx)
-	tx, err := conn.Begin(ctx)
-	if err != nil {
-		return 0, err
-	}
-
 	var result uint
-	err = tx.QueryRow(ctx, _sql, args...).Scan(&result)
+	err := r.storage.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		return tx.QueryRow(ctx, _sql, args...).Scan(&result)
+	})
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		err = tx.Rollback(ctx)
 		return 0, err
 	}
-	err = tx.Commit(ctx)
 
-	return result, err
+	return result, nil
+}
 
+// InTransaction runs fn in a transaction carried by the context, repositories called with it take part in it
+func (r *Repository) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.storage.WithTransaction(ctx, func(ctx context.Context, _ pgx.Tx) error {
+		return fn(ctx)
+	})
 }
 
 func (r *Repository) Get(ctx context.Context, _sql string, args ...interface{}) ([]map[string]interface{}, error) {
 
-	conn, err := r.storage.Conn(ctx)
+	conn, err := r.storage.Querier(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -73,20 +79,8 @@ func (r *Repository) Get(ctx context.Context, _sql string, args ...interface{})
 }
 
 func (r *Repository) Delete(ctx context.Context, sql string, args ...interface{}) error {
-
-	conn, err := r.storage.Conn(ctx)
-	tx, err := conn.Begin(ctx)
-	if err != nil {
+	return r.storage.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, sql, args...)
 		return err
-	}
-
-	_, err = tx.Exec(ctx, sql, args...)
-	if err != nil {
-		_ = tx.Rollback(ctx)
-		return err
-	}
-
-	err = tx.Commit(ctx)
-
-	return err
+	})
 }
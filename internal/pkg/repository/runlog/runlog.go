@@ -0,0 +1,147 @@
+package runlog
+
+import (
+	"context"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+const columns = "rl.id, rl.kind, rl.cycle_id, rl.thread_key, rl.instance_id, rl.model, rl.group_id, rl.pool, rl.threads, rl.orders, rl.lots, rl.transitions, rl.action_errors, rl.error, rl.start_time, rl.end_time, rl.duration_ms"
+
+// Repository keeps the cycles and the threads of the robot in _InfoReg_RL
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+}
+
+// Filter selects the rows of the run log, zero fields do not filter
+type Filter struct {
+	Kind       string
+	CycleId    string
+	InstanceId string
+	Model      string
+	GroupId    *int64
+	Pool       string
+	From       time.Time
+	To         time.Time
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+	}
+}
+
+func (r *Repository) SaveRun(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_InfoReg_RL").
+		SetMap(data).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+// RunList returns up to limit rows of the filter, the latest first
+func (r *Repository) RunList(ctx context.Context, filter Filter, limit uint64) ([]map[string]interface{}, error) {
+
+	builder := where(squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select(columns).
+		From("_InfoReg_RL as rl").
+		OrderBy("rl.start_time desc", "rl.id desc").
+		Limit(limit), filter)
+
+	_sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// RunStats sums the thread rows of the filter by model and by bucket of start_time,
+// bucket is a unit of date_trunc
+func (r *Repository) RunStats(ctx context.Context, filter Filter, bucket string) ([]map[string]interface{}, error) {
+
+	filter.Kind = "thread"
+
+	builder := where(squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select().
+		Column(squirrel.Expr("date_trunc(?, rl.start_time) as bucket", bucket)).
+		Columns(
+			"rl.model",
+			"count(distinct rl.cycle_id) as cycles",
+			"count(*) as threads",
+			"sum(rl.orders) as orders",
+			"sum(rl.lots) as lots",
+			"coalesce(sum(rl.transitions), 0) as transitions",
+			"coalesce(sum(rl.action_errors), 0) as action_errors",
+			"count(rl.error) as failed_threads",
+			"round(avg(rl.duration_ms)) as avg_duration_ms",
+			"max(rl.duration_ms) as max_duration_ms",
+		).
+		From("_InfoReg_RL as rl").
+		GroupBy("1", "2").
+		OrderBy("1 desc", "2"), filter)
+
+	_sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func where(builder squirrel.SelectBuilder, filter Filter) squirrel.SelectBuilder {
+
+	eq := squirrel.Eq{}
+	if len(filter.Kind) > 0 {
+		eq["rl.kind"] = filter.Kind
+	}
+	if len(filter.CycleId) > 0 {
+		eq["rl.cycle_id"] = filter.CycleId
+	}
+	if len(filter.InstanceId) > 0 {
+		eq["rl.instance_id"] = filter.InstanceId
+	}
+	if len(filter.Model) > 0 {
+		eq["rl.model"] = filter.Model
+	}
+	if filter.GroupId != nil {
+		eq["rl.group_id"] = *filter.GroupId
+	}
+	if len(filter.Pool) > 0 {
+		eq["rl.pool"] = filter.Pool
+	}
+	if len(eq) > 0 {
+		builder = builder.Where(eq)
+	}
+
+	if !filter.From.IsZero() {
+		builder = builder.Where(squirrel.GtOrEq{"rl.start_time": filter.From})
+	}
+	if !filter.To.IsZero() {
+		builder = builder.Where(squirrel.Lt{"rl.start_time": filter.To})
+	}
+
+	return builder
+}
@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+type Repository struct {
+	zl             *zap.Logger
+	storage        *postgres.Postgres
+	RootRepository *root.Repository
+}
+
+func NewRepository(s *postgres.Postgres, root *root.Repository, zl *zap.Logger) *Repository {
+	return &Repository{
+		zl:             zl,
+		storage:        s,
+		RootRepository: root,
+	}
+}
+
+func (r *Repository) CreateSubscription(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_Ref_WS").
+		SetMap(data).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) UpdateSubscription(ctx context.Context, id int64, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_Ref_WS").
+		SetMap(data).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) DeleteSubscription(ctx context.Context, id int64) error {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Delete("_Ref_WS").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.RootRepository.Delete(ctx, _sql, args...)
+}
+
+// SubscriptionList returns subscriptions without their secrets
+func (r *Repository) SubscriptionList(ctx context.Context) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("ws.id, ws.url, ws.node_id, ws.event_type_id, ws.kind, ws.active, ws.created_at").
+		From("_Ref_WS as ws").
+		OrderBy("ws.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+// MatchSubscriptions returns active subscriptions whose filters accept the notification.
+// An empty filter column matches any value.
+func (r *Repository) MatchSubscriptions(ctx context.Context, kind string, nodeId interface{}, eventTypeId interface{}) ([]map[string]interface{}, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("ws.id, ws.url, ws.secret").
+		From("_Ref_WS as ws").
+		Where(squirrel.Eq{"ws.active": true}).
+		Where(squirrel.Or{squirrel.Eq{"ws.kind": nil}, squirrel.Eq{"ws.kind": kind}}).
+		Where(squirrel.Or{squirrel.Eq{"ws.node_id": nil}, squirrel.Eq{"ws.node_id": nodeId}}).
+		Where(squirrel.Or{squirrel.Eq{"ws.event_type_id": nil}, squirrel.Eq{"ws.event_type_id": eventTypeId}}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func (r *Repository) CreateDelivery(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Insert("_InfoReg_WD").
+		SetMap(data).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+// ClaimDueDeliveries moves the next attempt of due deliveries forward by lease, so that concurrent
+// dispatchers skip them, and returns the claimed rows together with the subscription url and secret.
+func (r *Repository) ClaimDueDeliveries(ctx context.Context, status string, limit uint64, lease time.Duration) ([]map[string]interface{}, error) {
+
+	_sql := `update _inforeg_wd as wd
+			set next_attempt_time = $1
+			from _ref_ws as ws
+			where ws.id = wd.subscription_id
+				and wd.id in (select
+							id
+						from _inforeg_wd
+						where status = $2
+							and next_attempt_time <= $3
+						order by next_attempt_time
+						limit $4
+						for update skip locked)
+			returning wd.id, wd.subscription_id, wd.kind, wd.payload, wd.attempts, ws.url, ws.secret`
+
+	now := time.Now()
+
+	var args []interface{}
+	args = append(args, now.Add(lease))
+	args = append(args, status)
+	args = append(args, now)
+	args = append(args, limit)
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
+
+func (r *Repository) UpdateDelivery(ctx context.Context, id int64, data map[string]interface{}) (uint, error) {
+
+	_sql, args, err := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Update("_InfoReg_WD").
+		SetMap(data).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		r.zl.Sugar().Error(err)
+		return 0, err
+	}
+
+	return r.RootRepository.CreateOrUpdate(ctx, _sql, args...)
+}
+
+func (r *Repository) DeliveryList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error) {
+
+	query := squirrel.
+		StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		Select("wd.id, wd.subscription_id, wd.kind, wd.lot_id, wd.node_id, wd.payload, wd.status, " +
+			"wd.attempts, wd.next_attempt_time, wd.response_code, wd.last_error, wd.created_at, wd.delivered_at").
+		From("_InfoReg_WD as wd").
+		OrderBy("wd.id desc").
+		Limit(limit)
+
+	for key, value := range params {
+		query = query.Where(squirrel.Eq{"wd." + key: value})
+	}
+
+	_sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RootRepository.Get(ctx, _sql, args...)
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/root"
+	"oms2/internal/pkg/storage/postgres"
+)
+
+func TestRepository_ClaimDueDeliveries(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	zl := zap.L()
+	p := postgres.NewPostgres(postgres.Config{
+		DBUser:     "postgres",
+		DBPassword: "postgres",
+		DBHost:     "localhost",
+		DBPort:     "5432",
+		DBName:     "oms",
+		LogLevel:   "error",
+		MaxConns:   4,
+	}, zl)
+	if err := p.Start(ctx); err != nil {
+		t.Skipf("postgres is not available: %v", err)
+	}
+	defer p.Stop(ctx)
+
+	conn, err := p.Conn(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, PrepareTestDB(ctx, conn))
+
+	rootRepo := root.NewRepository(p, zl)
+	repository := NewRepository(p, rootRepo, zl)
+
+	// a dispatcher holding a claimed delivery in its transaction, the others skip its row
+	err = rootRepo.InTransaction(ctx, func(ctx context.Context) error {
+
+		claimed, err := repository.ClaimDueDeliveries(ctx, "pending", 1, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.Equal(t, int64(1), claimed[0]["id"])
+		require.Equal(t, "http://receiver/hook", claimed[0]["url"])
+
+		others, err := repository.ClaimDueDeliveries(context.Background(), "pending", 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, others, 1)
+		require.Equal(t, int64(2), others[0]["id"])
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	// the claimed deliveries are leased, the failed and the future ones are not due
+	claimed, err := repository.ClaimDueDeliveries(ctx, "pending", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 0)
+
+	updated, err := repository.UpdateDelivery(ctx, 1, map[string]interface{}{"attempts": 1, "next_attempt_time": time.Now()})
+	require.NoError(t, err)
+	require.Equal(t, uint(1), updated)
+
+	claimed, err = repository.ClaimDueDeliveries(ctx, "pending", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, int32(1), claimed[0]["attempts"])
+}
+
+// PrepareTestDB creates a subscription with two due deliveries, a failed one and one due in an hour
+func PrepareTestDB(ctx context.Context, conn *pgxpool.Pool) error {
+
+	qs := []string{
+		`DROP TABLE IF EXISTS _InfoReg_WD;`,
+		`DROP TABLE IF EXISTS _Ref_WS;`,
+
+		`CREATE TABLE _Ref_WS (
+			id            bigserial primary key,
+			url           varchar NOT NULL,
+			secret        varchar NOT NULL,
+			node_id       int,
+			event_type_id int,
+			kind          varchar,
+			active        boolean NOT NULL DEFAULT true,
+			created_at    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP);`,
+		`INSERT INTO _Ref_WS(url, secret)
+			VALUES ('http://receiver/hook', 'secret');`,
+
+		`CREATE TABLE _InfoReg_WD (
+			id                bigserial primary key,
+			subscription_id   int REFERENCES _Ref_WS (id),
+			kind              varchar NOT NULL,
+			lot_id            int,
+			node_id           int,
+			payload           jsonb   NOT NULL,
+			status            varchar NOT NULL DEFAULT 'pending',
+			attempts          int     NOT NULL DEFAULT 0,
+			next_attempt_time timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			response_code     int,
+			last_error        varchar,
+			created_at        timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			delivered_at      timestamp WITH TIME ZONE);`,
+		`INSERT INTO _InfoReg_WD(subscription_id, kind, payload, status, next_attempt_time)
+			VALUES (1, 'node_entered', '{}', 'pending', now() - interval '2 minutes'),
+			(1, 'node_entered', '{}', 'pending', now() - interval '1 minute'),
+			(1, 'node_entered', '{}', 'failed', now() - interval '1 minute'),
+			(1, 'node_entered', '{}', 'pending', now() + interval '1 hour');`,
+	}
+
+	for _, q := range qs {
+		if _, err := conn.Exec(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
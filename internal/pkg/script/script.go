@@ -0,0 +1,321 @@
+// Package script runs Starlark scripts and conditions of the maps in a sandbox:
+// no load, no file or network access, limited built-ins and a step and time limit.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+var (
+	ErrTimeout        = errors.New("script time limit exceeded")
+	ErrNotConvertible = errors.New("script value cannot be converted")
+)
+
+// Limits of a single run
+type Limits struct {
+	MaxSteps uint64
+	Timeout  time.Duration
+}
+
+// Printer receives the output of print
+type Printer func(message string)
+
+// builtins available to scripts in addition to the Starlark universe
+var builtins = starlark.StringDict{
+	"json": json.Module,
+	"math": starlarkmath.Module,
+}
+
+func init() {
+	// top level if and for are what scripts of actions are written with,
+	// the resolver of this starlark version only has a package wide switch for them
+	resolve.AllowGlobalReassign = true
+}
+
+// Check parses and resolves source with the given global names predeclared
+func Check(source string, globals ...string) error {
+
+	_, _, err := starlark.SourceProgram("script", source, predeclared(globals).Has)
+
+	return err
+}
+
+// CheckExpr parses and resolves a condition expression with the given global names predeclared
+func CheckExpr(expr string, globals ...string) error {
+
+	e, err := syntax.ParseExpr("condition", expr, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = resolve.Expr(e, predeclared(globals).Has, starlark.Universe.Has)
+
+	return err
+}
+
+// Run executes source with globals converted to Starlark values and returns them converted back
+// with the global variables of the script, helpers such as functions are skipped
+func Run(ctx context.Context, source string, globals map[string]interface{}, limits Limits, print Printer) (map[string]interface{}, error) {
+
+	env, err := environment(globals)
+	if err != nil {
+		return nil, err
+	}
+
+	var result starlark.StringDict
+	err = run(ctx, limits, print, func(thread *starlark.Thread) (err error) {
+		result, err = starlark.ExecFile(thread, "script", source, env)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// predeclared values modified in place come first, globals of the script override them
+	values := make(map[string]interface{})
+	for name := range globals {
+		values[name], err = FromValue(env[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	for name, value := range result {
+		converted, err := FromValue(value)
+		if err != nil {
+			continue
+		}
+		values[name] = converted
+	}
+
+	return values, nil
+}
+
+// Eval evaluates a condition expression and returns its truth value
+func Eval(ctx context.Context, expr string, globals map[string]interface{}, limits Limits) (bool, error) {
+
+	env, err := environment(globals)
+	if err != nil {
+		return false, err
+	}
+
+	var result starlark.Value
+	err = run(ctx, limits, nil, func(thread *starlark.Thread) (err error) {
+		result, err = starlark.Eval(thread, "condition", expr, env)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return bool(result.Truth()), nil
+}
+
+func run(ctx context.Context, limits Limits, print Printer, fn func(thread *starlark.Thread) error) error {
+
+	thread := &starlark.Thread{Name: "script"}
+	thread.Print = func(_ *starlark.Thread, message string) {
+		if print != nil {
+			print(message)
+		}
+	}
+
+	if limits.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(limits.MaxSteps)
+	}
+
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ErrTimeout.Error())
+		case <-done:
+		}
+	}()
+
+	err := fn(thread)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %s", ErrTimeout, err)
+	}
+
+	return err
+}
+
+func environment(globals map[string]interface{}) (starlark.StringDict, error) {
+
+	env := make(starlark.StringDict, len(builtins)+len(globals))
+	for name, value := range builtins {
+		env[name] = value
+	}
+
+	for name, value := range globals {
+		v, err := ToValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		env[name] = v
+	}
+
+	return env, nil
+}
+
+type predeclared []string
+
+func (p predeclared) Has(name string) bool {
+
+	if _, ok := builtins[name]; ok {
+		return true
+	}
+
+	for _, global := range p {
+		if global == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Normalize returns value as a script would return it unchanged, e.g. integral floats become int64
+func Normalize(value interface{}) (interface{}, error) {
+
+	converted, err := ToValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromValue(converted)
+}
+
+// ToValue converts a json like Go value to a Starlark value, integral numbers become ints
+func ToValue(value interface{}) (starlark.Value, error) {
+
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case starlark.Value:
+		return v, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int32:
+		return starlark.MakeInt64(int64(v)), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case time.Time:
+		return starlark.String(v.Format(time.RFC3339Nano)), nil
+	case []interface{}:
+		items := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			converted, err := ToValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, converted)
+		}
+		return starlark.NewList(items), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		dict := starlark.NewDict(len(v))
+		for _, key := range keys {
+			converted, err := ToValue(v[key])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(key), converted); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", ErrNotConvertible, value)
+}
+
+// FromValue converts a Starlark value to a json like Go value
+func FromValue(value starlark.Value) (interface{}, error) {
+
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok {
+			return nil, fmt.Errorf("%w: int %s is too large", ErrNotConvertible, v)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return items(v)
+	case starlark.Tuple:
+		return items(v)
+	case *starlark.Dict:
+		result := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("%w: dict key %s", ErrNotConvertible, item[0].Type())
+			}
+
+			converted, err := FromValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			result[string(key)] = converted
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotConvertible, value.Type())
+}
+
+func items(iterable starlark.Indexable) ([]interface{}, error) {
+
+	result := make([]interface{}, 0, iterable.Len())
+	for i := 0; i < iterable.Len(); i++ {
+		converted, err := FromValue(iterable.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, converted)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var limits = Limits{MaxSteps: 100000, Timeout: time.Second}
+
+func TestRun(t *testing.T) {
+
+	source := `
+def total(items):
+    result = 0
+    for item in items:
+        result += item["qty"] * item["price"]
+    return result
+
+variables["total"] = total(variables["items"])
+variables.pop("draft")
+if params["express"]:
+    outcome = "stay"
+print("total", variables["total"])
+`
+	globals := map[string]interface{}{
+		"variables": map[string]interface{}{
+			"draft": true,
+			"items": []interface{}{
+				map[string]interface{}{"qty": 2.0, "price": 10.5},
+				map[string]interface{}{"qty": 1.0, "price": 4.0},
+			},
+		},
+		"params": map[string]interface{}{"express": true},
+	}
+
+	var printed []string
+	result, err := Run(context.Background(), source, globals, limits, func(message string) {
+		printed = append(printed, message)
+	})
+	require.NoError(t, err)
+
+	variables := result["variables"].(map[string]interface{})
+	require.Equal(t, 25.0, variables["total"])
+	require.NotContains(t, variables, "draft")
+	require.Equal(t, "stay", result["outcome"])
+	require.NotContains(t, result, "total")
+	require.Equal(t, []string{"total 25.0"}, printed)
+}
+
+func TestRunLimits(t *testing.T) {
+
+	loop := `
+def spin():
+    n = 0
+    for i in range(1000000000):
+        n += i
+    return n
+
+spin()
+`
+	_, err := Run(context.Background(), loop, nil, Limits{MaxSteps: 1000}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too many steps")
+
+	_, err = Run(context.Background(), loop, nil, Limits{Timeout: 20 * time.Millisecond}, nil)
+	require.True(t, errors.Is(err, ErrTimeout))
+}
+
+func TestSandbox(t *testing.T) {
+
+	_, err := Run(context.Background(), `load("os.star", "os")`, nil, limits, nil)
+	require.Error(t, err)
+
+	require.Error(t, Check(`x = open("/etc/passwd")`))
+	require.Error(t, Check(`variables["a"] = 1`))
+	require.NoError(t, Check(`variables["a"] = json.encode(math.floor(1.5))`, "variables"))
+}
+
+func TestEval(t *testing.T) {
+
+	globals := map[string]interface{}{
+		"variables": map[string]interface{}{"amount": 150.0, "country": "RU"},
+		"event":     nil,
+	}
+
+	ok, err := Eval(context.Background(), `variables["amount"] > 100 and variables["country"] in ("RU", "KZ")`, globals, limits)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = Eval(context.Background(), `event != None and event["type"] == "paid"`, globals, limits)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, CheckExpr(`variables.get("amount", 0) > 1`, "variables"))
+	require.Error(t, CheckExpr(`amount > 1`, "variables"))
+	require.Error(t, CheckExpr(`variables[`, "variables"))
+}
+
+func TestConvert(t *testing.T) {
+
+	value := map[string]interface{}{
+		"int":   3.0,
+		"float": 2.5,
+		"list":  []interface{}{"a", nil, true},
+		"map":   map[string]interface{}{"id": int32(7)},
+	}
+
+	converted, err := ToValue(value)
+	require.NoError(t, err)
+
+	back, err := FromValue(converted)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"int":   int64(3),
+		"float": 2.5,
+		"list":  []interface{}{"a", nil, true},
+		"map":   map[string]interface{}{"id": int64(7)},
+	}, back)
+
+	_, err = ToValue(struct{}{})
+	require.True(t, errors.Is(err, ErrNotConvertible))
+}
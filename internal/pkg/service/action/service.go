@@ -0,0 +1,109 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/repository/action"
+	"oms2/internal/pkg/service/robot"
+)
+
+var (
+	ErrEmptyToken     = errors.New("token is empty")
+	ErrInvalidStatus  = errors.New("status must be completed or failed")
+	ErrWaitNotFound   = errors.New("pending action not found")
+	ErrWaitNotPending = errors.New("action is not pending")
+)
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository *action.Repository
+}
+
+// Completion of an asynchronous action by token.
+// Status is completed if not set, Variables is a patch of the lot variables applied by the robot.
+type Completion struct {
+	Token     string                 `json:"token"`
+	Status    string                 `json:"status"`
+	Variables map[string]interface{} `json:"variables"`
+	Error     string                 `json:"error"`
+}
+
+func NewService(cfg *oms.Config, r *action.Repository, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+	}
+}
+
+// Complete completes or fails the pending action and returns its wait,
+// the robot moves the lot on the next iteration
+func (s *Service) Complete(ctx context.Context, c Completion) (map[string]interface{}, error) {
+
+	if len(c.Token) == 0 {
+		return nil, ErrEmptyToken
+	}
+
+	status := c.Status
+	if len(status) == 0 {
+		status = robot.WaitCompleted
+	}
+	if status != robot.WaitCompleted && status != robot.WaitFailed {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidStatus, status)
+	}
+
+	result, err := json.Marshal(c.Variables)
+	if err != nil {
+		return nil, err
+	}
+	if c.Variables == nil {
+		result = []byte("{}")
+	}
+
+	var errorText interface{}
+	if len(c.Error) > 0 {
+		errorText = c.Error
+	}
+
+	updated, err := s.repository.CompleteWait(ctx, c.Token, status, string(result), errorText)
+	if err != nil {
+		return nil, err
+	}
+
+	wait, err := s.Wait(ctx, c.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if updated == 0 {
+		return nil, fmt.Errorf("%w: %s is %v", ErrWaitNotPending, c.Token, wait["status"])
+	}
+
+	return wait, nil
+}
+
+func (s *Service) Wait(ctx context.Context, token string) (map[string]interface{}, error) {
+
+	waits, err := s.repository.Wait(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(waits) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWaitNotFound, token)
+	}
+
+	return waits[0], nil
+}
+
+// Waits returns the asynchronous actions of the lot, the latest first
+func (s *Service) Waits(ctx context.Context, lotId int64) ([]map[string]interface{}, error) {
+	return s.repository.Waits(ctx, lotId)
+}
@@ -0,0 +1,148 @@
+// Package activity reaps the order leases of the robot threads in _InfoReg_PA whose heartbeat has expired,
+// logs them to _InfoReg_PAR and lists the held and reclaimed leases
+package activity
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/metrics"
+	"oms2/internal/pkg/repository/robot"
+	"oms2/internal/pkg/service/leader"
+	"oms2/internal/pkg/util"
+)
+
+const (
+	reapBatchSize = 1000
+
+	DefaultReclaimedLimit = 100
+)
+
+// leases is the part of the repository reclaiming and listing the order leases
+type leases interface {
+	ReclaimExpired(ctx context.Context, reclaimedBy string, limit int) ([]map[string]interface{}, error)
+	CountActivity(ctx context.Context) ([]map[string]interface{}, error)
+	HeldActivity(ctx context.Context, instanceId string, pool string) ([]map[string]interface{}, error)
+	ReclaimedActivity(ctx context.Context, orderId int64, limit uint64) ([]map[string]interface{}, error)
+}
+
+// elector tells whether this instance is the leader running the reaper
+type elector interface {
+	IsLeader() bool
+}
+
+var (
+	_ leases  = (*robot.Repository)(nil)
+	_ elector = (*leader.Service)(nil)
+)
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository leases
+	leader     elector
+	batchSize  int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(cfg *oms.Config, r *robot.Repository, leader *leader.Service, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+		leader:     leader,
+		batchSize:  reapBatchSize,
+	}
+}
+
+// Start reaps the expired leases every ReapInterval on the leader
+func (s *Service) Start(_ context.Context) error {
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.ReapInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if !s.leader.IsLeader() {
+					continue
+				}
+				if _, err := s.Reap(s.ctx); err != nil {
+					s.zl.Sugar().Error(err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *Service) Stop(_ context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	return nil
+}
+
+// Reap releases the expired leases in batches, updates the lease metrics and returns the reclaimed rows
+func (s *Service) Reap(ctx context.Context) ([]map[string]interface{}, error) {
+
+	var result []map[string]interface{}
+	for {
+		reclaimed, err := s.repository.ReclaimExpired(ctx, s.cfg.InstanceId, s.batchSize)
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, reclaimed...)
+		metrics.ActivityReclaimed.Add(float64(len(reclaimed)))
+
+		if len(reclaimed) < s.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+
+	for _, item := range result {
+		message := fmt.Sprintf("Reclaimed lease of order %d: thread %s, instance %s, heartbeat %v",
+			util.ToInt64(item["order_id"]), item["thread_key"], item["instance_id"], item["heartbeat_time"])
+		s.zl.Sugar().Warn(message)
+	}
+
+	counts, err := s.repository.CountActivity(ctx)
+	if err != nil {
+		return result, err
+	}
+	if len(counts) > 0 {
+		metrics.ActivityLeases.WithLabelValues("held").Set(float64(util.ToInt64(counts[0]["held"])))
+		metrics.ActivityLeases.WithLabelValues("expired").Set(float64(util.ToInt64(counts[0]["expired"])))
+	}
+
+	return result, nil
+}
+
+// Held returns the live leases with their pools and lots, of one instance and one pool if instanceId and pool are set
+func (s *Service) Held(ctx context.Context, instanceId string, pool string) ([]map[string]interface{}, error) {
+	return s.repository.HeldActivity(ctx, instanceId, pool)
+}
+
+// Reclaimed returns the latest reclaimed leases, of one order if orderId is set
+func (s *Service) Reclaimed(ctx context.Context, orderId int64, limit uint64) ([]map[string]interface{}, error) {
+
+	if limit == 0 {
+		limit = DefaultReclaimedLimit
+	}
+
+	return s.repository.ReclaimedActivity(ctx, orderId, limit)
+}
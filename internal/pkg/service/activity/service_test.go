@@ -0,0 +1,157 @@
+package activity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+)
+
+// memoryLeases keeps the expired leases of _InfoReg_PA, a reclaimed lease is removed from them
+type memoryLeases struct {
+	expired     []map[string]interface{}
+	batches     []int
+	reclaimedBy []string
+	counted     int
+	fail        error
+}
+
+func newMemoryLeases(count int) *memoryLeases {
+
+	m := &memoryLeases{}
+	for i := 0; i < count; i++ {
+		m.expired = append(m.expired, map[string]interface{}{
+			"order_id":    int64(i + 1),
+			"thread_key":  "thread-1",
+			"instance_id": "dead",
+		})
+	}
+
+	return m
+}
+
+func (m *memoryLeases) ReclaimExpired(_ context.Context, reclaimedBy string, limit int) ([]map[string]interface{}, error) {
+
+	if m.fail != nil {
+		return nil, m.fail
+	}
+
+	n := len(m.expired)
+	if n > limit {
+		n = limit
+	}
+
+	reclaimed := m.expired[:n]
+	m.expired = m.expired[n:]
+	m.batches = append(m.batches, n)
+	m.reclaimedBy = append(m.reclaimedBy, reclaimedBy)
+
+	return reclaimed, nil
+}
+
+func (m *memoryLeases) CountActivity(_ context.Context) ([]map[string]interface{}, error) {
+	m.counted += 1
+	return []map[string]interface{}{{"held": int64(0), "expired": int64(len(m.expired))}}, nil
+}
+
+func (m *memoryLeases) HeldActivity(_ context.Context, _ string, _ string) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (m *memoryLeases) ReclaimedActivity(_ context.Context, _ int64, _ uint64) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+func newTestService(repository *memoryLeases, batchSize int) *Service {
+	return &Service{
+		zl:         zap.NewNop(),
+		cfg:        &oms.Config{InstanceId: "reaper"},
+		repository: repository,
+		batchSize:  batchSize,
+	}
+}
+
+func TestReapBatches(t *testing.T) {
+
+	repository := newMemoryLeases(25)
+
+	reclaimed, err := newTestService(repository, 10).Reap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reclaimed) != 25 {
+		t.Fatalf("expected 25 reclaimed, got %d", len(reclaimed))
+	}
+	if len(repository.expired) != 0 {
+		t.Fatalf("expected no expired leases left, got %d", len(repository.expired))
+	}
+
+	// a full batch is followed by another one, a short batch ends the run
+	expected := []int{10, 10, 5}
+	if len(repository.batches) != len(expected) {
+		t.Fatalf("expected batches %v, got %v", expected, repository.batches)
+	}
+	for i := range expected {
+		if repository.batches[i] != expected[i] {
+			t.Fatalf("expected batches %v, got %v", expected, repository.batches)
+		}
+	}
+
+	for _, by := range repository.reclaimedBy {
+		if by != "reaper" {
+			t.Fatalf("expected leases reclaimed by the instance, got %s", by)
+		}
+	}
+
+	if repository.counted != 1 {
+		t.Fatalf("expected the leases counted once, got %d", repository.counted)
+	}
+}
+
+func TestReapFullBatch(t *testing.T) {
+
+	repository := newMemoryLeases(20)
+
+	reclaimed, err := newTestService(repository, 10).Reap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reclaimed) != 20 || len(repository.batches) != 3 || repository.batches[2] != 0 {
+		t.Fatalf("expected an empty batch after the full ones, got %v", repository.batches)
+	}
+}
+
+func TestReapNothingExpired(t *testing.T) {
+
+	repository := newMemoryLeases(0)
+
+	reclaimed, err := newTestService(repository, 10).Reap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reclaimed) != 0 || len(repository.batches) != 1 {
+		t.Fatalf("expected one empty batch, got %v", repository.batches)
+	}
+}
+
+func TestReapError(t *testing.T) {
+
+	failure := errors.New("connection refused")
+	repository := newMemoryLeases(5)
+	repository.fail = failure
+
+	_, err := newTestService(repository, 10).Reap(context.Background())
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+
+	if repository.counted != 0 {
+		t.Fatal("leases counted after a failed reclaim")
+	}
+}
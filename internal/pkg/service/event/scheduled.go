@@ -0,0 +1,242 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"oms2/internal/pkg/util"
+)
+
+const (
+	ScheduledPending   = "pending"
+	ScheduledFired     = "fired"
+	ScheduledCancelled = "cancelled"
+	ScheduledFailed    = "failed"
+)
+
+// ScheduledSource is the source of events materialized from the schedule,
+// the idempotency key is derived from the scheduled event id
+const ScheduledSource = "scheduler"
+
+const (
+	scheduledBatchSize = 100
+	scheduledLease     = time.Minute
+)
+
+var (
+	ErrNoFireTime          = errors.New("either fire_at or delay_seconds is required")
+	ErrNoCancelTarget      = errors.New("either id or cancel_key is required")
+	ErrScheduledNotPending = errors.New("scheduled event is no longer pending")
+)
+
+// scheduledStore is the part of the repository cancelling and firing the scheduled events
+type scheduledStore interface {
+	CancelScheduled(ctx context.Context, params map[string]interface{}, status string, cancelled string) ([]map[string]interface{}, error)
+	ClaimDueScheduled(ctx context.Context, status string, limit uint64, lease time.Duration) ([]map[string]interface{}, error)
+	LockScheduled(ctx context.Context, id int64, status string) ([]map[string]interface{}, error)
+	UpdateScheduled(ctx context.Context, id int64, status string, data map[string]interface{}) (uint, error)
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// createError is a failure of the event of a scheduled event, the scheduled event is marked failed
+type createError struct {
+	err error
+}
+
+func (e *createError) Error() string {
+	return e.err.Error()
+}
+
+func (e *createError) Unwrap() error {
+	return e.err
+}
+
+// ScheduledEvent is an event that is registered at FireAt or after DelaySeconds,
+// unless it is cancelled by id or CancelKey before
+type ScheduledEvent struct {
+	Type         string          `json:"type"`
+	LotId        int64           `json:"lot_id"`
+	Name         string          `json:"name"`
+	Payload      json.RawMessage `json:"payload"`
+	FireAt       *time.Time      `json:"fire_at"`
+	DelaySeconds int64           `json:"delay_seconds"`
+	CancelKey    string          `json:"cancel_key"`
+}
+
+// ScheduleEvent validates the event now and stores it until its fire time
+func (s *Service) ScheduleEvent(ctx context.Context, e ScheduledEvent) (uint, error) {
+
+	var fireAt time.Time
+	switch {
+	case e.FireAt != nil:
+		fireAt = *e.FireAt
+	case e.DelaySeconds > 0:
+		fireAt = time.Now().Add(time.Duration(e.DelaySeconds) * time.Second)
+	default:
+		return 0, ErrNoFireTime
+	}
+
+	eventType, err := s.Type(ctx, e.Type)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, _, err := validatePayload(eventType, e.Type, e.Payload)
+	if err != nil {
+		return 0, err
+	}
+
+	lots, err := s.repository.Lot(ctx, e.LotId)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(lots) == 0 {
+		return 0, fmt.Errorf("%w: %d", ErrLotNotFound, e.LotId)
+	}
+
+	name := e.Name
+	if len(name) == 0 {
+		name = e.Type
+	}
+
+	values := make(map[string]interface{})
+	values["event_type_id"] = eventType["id"]
+	values["lot_id"] = e.LotId
+	values["name"] = name
+	values["payload"] = string(payload)
+	values["fire_at"] = fireAt
+	values["status"] = ScheduledPending
+	if len(e.CancelKey) > 0 {
+		values["cancel_key"] = e.CancelKey
+	}
+
+	return s.repository.CreateScheduled(ctx, values)
+}
+
+func (s *Service) ScheduledList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error) {
+
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+
+	return s.repository.ScheduledList(ctx, params, limit)
+}
+
+// CancelScheduled cancels pending scheduled events by id or by cancel key, optionally limited to a lot
+func (s *Service) CancelScheduled(ctx context.Context, id int64, cancelKey string, lotId int64) ([]map[string]interface{}, error) {
+
+	params := make(map[string]interface{})
+	if id != 0 {
+		params["id"] = id
+	}
+	if len(cancelKey) > 0 {
+		params["cancel_key"] = cancelKey
+	}
+	if len(params) == 0 {
+		return nil, ErrNoCancelTarget
+	}
+	if lotId != 0 {
+		params["lot_id"] = lotId
+	}
+
+	return s.scheduled.CancelScheduled(ctx, params, ScheduledPending, ScheduledCancelled)
+}
+
+// MaterializeDue registers due scheduled events as regular events and returns the number fired.
+// The event is created with an idempotency key of the scheduled event, so a repeated run
+// after a crash does not create it twice.
+func (s *Service) MaterializeDue(ctx context.Context) (int, error) {
+
+	due, err := s.scheduled.ClaimDueScheduled(ctx, ScheduledPending, scheduledBatchSize, scheduledLease)
+	if err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for _, item := range due {
+		ok, err := s.fireScheduled(ctx, item["id"].(int64))
+		if err != nil {
+			return fired, err
+		}
+		if ok {
+			fired += 1
+		}
+	}
+
+	return fired, nil
+}
+
+// fireScheduled creates the event of a claimed scheduled event and marks it fired in one transaction
+// holding the lock of the row, so a cancel either lands before and nothing fires, or waits for the commit
+// and finds the event fired. An event that fails to be created marks the scheduled event failed.
+func (s *Service) fireScheduled(ctx context.Context, id int64) (bool, error) {
+
+	fired := false
+	err := s.scheduled.InTransaction(ctx, func(ctx context.Context) error {
+
+		locked, err := s.scheduled.LockScheduled(ctx, id, ScheduledPending)
+		if err != nil || len(locked) == 0 {
+			return err
+		}
+		item := locked[0]
+
+		payload, err := util.ToJSON(item["payload"])
+		if err != nil {
+			return err
+		}
+
+		event, err := s.create(ctx, Event{
+			Type:           item["event_type"].(string),
+			LotId:          util.ToInt64(item["lot_id"]),
+			Name:           item["name"].(string),
+			Payload:        payload,
+			Source:         ScheduledSource,
+			IdempotencyKey: "scheduled-" + strconv.FormatInt(id, 10),
+		})
+		if err != nil {
+			return &createError{err: err}
+		}
+
+		values := make(map[string]interface{})
+		values["status"] = ScheduledFired
+		values["event_id"] = event["id"]
+		values["completed_at"] = time.Now()
+
+		updated, err := s.scheduled.UpdateScheduled(ctx, id, ScheduledPending, values)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return fmt.Errorf("%w: %d", ErrScheduledNotPending, id)
+		}
+
+		fired = true
+		return nil
+	})
+
+	if errors.Is(err, ErrScheduledNotPending) {
+		s.zl.Sugar().Warn(err)
+		return false, nil
+	}
+
+	var failed *createError
+	if !errors.As(err, &failed) {
+		return fired && err == nil, err
+	}
+
+	s.zl.Sugar().Error(fmt.Sprintf("scheduled event %d: %s", id, failed.err))
+
+	values := make(map[string]interface{})
+	values["status"] = ScheduledFailed
+	values["last_error"] = failed.err.Error()
+	values["completed_at"] = time.Now()
+
+	_, err = s.scheduled.UpdateScheduled(ctx, id, ScheduledPending, values)
+
+	return false, err
+}
@@ -0,0 +1,236 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryScheduled keeps the scheduled events like _InfoReg_SE: a transaction holds the locks of the rows
+// it locked until it ends, an update of a locked row waits for them
+type memoryScheduled struct {
+	mu     sync.Mutex
+	status map[int64]string
+	locks  map[int64]chan struct{}
+}
+
+type txKey struct{}
+
+type memoryTx struct {
+	locked []chan struct{}
+}
+
+func newMemoryScheduled(ids ...int64) *memoryScheduled {
+
+	m := &memoryScheduled{status: make(map[int64]string), locks: make(map[int64]chan struct{})}
+	for _, id := range ids {
+		m.status[id] = ScheduledPending
+		m.locks[id] = make(chan struct{}, 1)
+	}
+
+	return m
+}
+
+func (m *memoryScheduled) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	tx := &memoryTx{}
+	defer func() {
+		for _, lock := range tx.locked {
+			<-lock
+		}
+	}()
+
+	return fn(context.WithValue(ctx, txKey{}, tx))
+}
+
+func (m *memoryScheduled) get(id int64) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status[id]
+}
+
+func (m *memoryScheduled) set(id int64, from string, to string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.status[id] != from {
+		return false
+	}
+	m.status[id] = to
+
+	return true
+}
+
+func (m *memoryScheduled) ClaimDueScheduled(_ context.Context, status string, _ uint64, _ time.Duration) ([]map[string]interface{}, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []map[string]interface{}
+	for id, value := range m.status {
+		if value == status {
+			due = append(due, map[string]interface{}{"id": id})
+		}
+	}
+
+	return due, nil
+}
+
+func (m *memoryScheduled) LockScheduled(ctx context.Context, id int64, status string) ([]map[string]interface{}, error) {
+
+	// skip locked
+	select {
+	case m.locks[id] <- struct{}{}:
+	default:
+		return nil, nil
+	}
+	tx := ctx.Value(txKey{}).(*memoryTx)
+	tx.locked = append(tx.locked, m.locks[id])
+
+	if m.get(id) != status {
+		return nil, nil
+	}
+
+	return []map[string]interface{}{{"id": id, "event_type": "paid", "lot_id": int64(1), "name": "paid", "payload": map[string]interface{}{}}}, nil
+}
+
+func (m *memoryScheduled) UpdateScheduled(_ context.Context, id int64, status string, data map[string]interface{}) (uint, error) {
+
+	if !m.set(id, status, data["status"].(string)) {
+		return 0, nil
+	}
+
+	return uint(id), nil
+}
+
+// CancelScheduled waits for the lock of the row held by a transaction like an update does
+func (m *memoryScheduled) CancelScheduled(_ context.Context, params map[string]interface{}, status string, cancelled string) ([]map[string]interface{}, error) {
+
+	id := params["id"].(int64)
+	m.locks[id] <- struct{}{}
+	defer func() { <-m.locks[id] }()
+
+	if !m.set(id, status, cancelled) {
+		return nil, nil
+	}
+
+	return []map[string]interface{}{{"id": id}}, nil
+}
+
+func newScheduledService(store *memoryScheduled, create func(ctx context.Context, e Event) (map[string]interface{}, error)) *Service {
+	return &Service{zl: zap.NewNop(), scheduled: store, create: create}
+}
+
+func TestScheduledCancelBeforeFire(t *testing.T) {
+
+	store := newMemoryScheduled(1)
+	created := 0
+	s := newScheduledService(store, func(ctx context.Context, e Event) (map[string]interface{}, error) {
+		created += 1
+		return map[string]interface{}{"id": int64(10)}, nil
+	})
+
+	// the robot claims the event, the cancel lands before it fires
+	due, err := store.ClaimDueScheduled(context.Background(), ScheduledPending, 10, time.Minute)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("claim = %v, %v", due, err)
+	}
+
+	cancelled, err := s.CancelScheduled(context.Background(), 1, "", 0)
+	if err != nil || len(cancelled) != 1 {
+		t.Fatalf("cancel = %v, %v", cancelled, err)
+	}
+
+	fired, err := s.fireScheduled(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fired || created != 0 {
+		t.Errorf("fired = %v, events = %d, want a cancelled event not to fire", fired, created)
+	}
+	if status := store.get(1); status != ScheduledCancelled {
+		t.Errorf("status = %s, want %s", status, ScheduledCancelled)
+	}
+}
+
+func TestScheduledCancelWhileFiring(t *testing.T) {
+
+	store := newMemoryScheduled(1)
+
+	var s *Service
+	cancelled := make(chan []map[string]interface{})
+	s = newScheduledService(store, func(ctx context.Context, e Event) (map[string]interface{}, error) {
+		// the cancel comes while the event is created and waits for the end of the transaction
+		go func() {
+			list, _ := s.CancelScheduled(context.Background(), 1, "", 0)
+			cancelled <- list
+		}()
+
+		select {
+		case <-cancelled:
+			t.Error("cancel did not wait for the transaction firing the event")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		return map[string]interface{}{"id": int64(10)}, nil
+	})
+
+	fired, err := s.MaterializeDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fired != 1 {
+		t.Errorf("fired = %d, want 1", fired)
+	}
+
+	if list := <-cancelled; len(list) != 0 {
+		t.Errorf("cancel = %v, want nothing cancelled after the event fired", list)
+	}
+	if status := store.get(1); status != ScheduledFired {
+		t.Errorf("status = %s, want %s", status, ScheduledFired)
+	}
+}
+
+func TestScheduledCreateFailed(t *testing.T) {
+
+	store := newMemoryScheduled(1)
+	s := newScheduledService(store, func(ctx context.Context, e Event) (map[string]interface{}, error) {
+		return nil, ErrLotNotFound
+	})
+
+	fired, err := s.MaterializeDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fired != 0 {
+		t.Errorf("fired = %d, want 0", fired)
+	}
+	if status := store.get(1); status != ScheduledFailed {
+		t.Errorf("status = %s, want %s", status, ScheduledFailed)
+	}
+}
+
+func TestScheduledNotPendingOnUpdate(t *testing.T) {
+
+	store := newMemoryScheduled(1)
+	s := newScheduledService(store, func(ctx context.Context, e Event) (map[string]interface{}, error) {
+		// the row leaves pending behind the lock, the update must not overwrite it
+		store.set(1, ScheduledPending, ScheduledCancelled)
+		return map[string]interface{}{"id": int64(10)}, nil
+	})
+
+	fired, err := s.fireScheduled(context.Background(), 1)
+	if err != nil && !errors.Is(err, ErrScheduledNotPending) {
+		t.Fatal(err)
+	}
+	if fired {
+		t.Error("fired an event that is no longer pending")
+	}
+	if status := store.get(1); status != ScheduledCancelled {
+		t.Errorf("status = %s, want %s", status, ScheduledCancelled)
+	}
+}
@@ -0,0 +1,363 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/jsonschema"
+	"oms2/internal/pkg/metrics"
+	"oms2/internal/pkg/repository/event"
+	"oms2/internal/pkg/service/webhook"
+	"oms2/internal/pkg/util"
+	"oms2/internal/pkg/variables"
+)
+
+var (
+	ErrInvalidCode    = errors.New("event type code must match " + codePattern.String())
+	ErrTypeNotFound   = errors.New("event type not found")
+	ErrTypeInUse      = errors.New("event type is used by events, map nodes, scheduled events or webhook subscriptions")
+	ErrEmptyPatch     = errors.New("nothing to update")
+	ErrLotNotFound    = errors.New("lot not found")
+	ErrInvalidPayload = errors.New("event payload is not a json object")
+	ErrEventNotFound  = errors.New("event not found")
+	ErrInvalidWindow  = errors.New("event type window_seconds must be positive")
+	defaultListLimit  = uint64(100)
+)
+
+var codePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository *event.Repository
+	webhook    *webhook.Service
+
+	// scheduled and create fire the scheduled events, they are the repository and CreateEvent
+	scheduled scheduledStore
+	create    func(ctx context.Context, e Event) (map[string]interface{}, error)
+}
+
+// Event is an incoming event addressed to a lot.
+// IdempotencyKey is unique per Source, a repeated key returns the original event.
+type Event struct {
+	Type           string          `json:"type"`
+	LotId          int64           `json:"lot_id"`
+	Name           string          `json:"name"`
+	Payload        json.RawMessage `json:"payload"`
+	Source         string          `json:"source"`
+	IdempotencyKey string          `json:"idempotency_key"`
+}
+
+// Type is an entry of the event type registry
+type Type struct {
+	Code          string               `json:"code"`
+	Name          *string              `json:"name"`
+	Description   *string              `json:"description"`
+	PayloadSchema json.RawMessage      `json:"payload_schema"`
+	MergeRule     *variables.MergeRule `json:"merge_rule"`
+	WindowSeconds *int64               `json:"window_seconds"`
+}
+
+func NewService(cfg *oms.Config, r *event.Repository, webhook *webhook.Service, zl *zap.Logger) *Service {
+	s := &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+		webhook:    webhook,
+		scheduled:  r,
+	}
+	s.create = s.CreateEvent
+
+	return s
+}
+
+func (s *Service) CreateType(ctx context.Context, t Type) (uint, error) {
+
+	if !codePattern.MatchString(t.Code) {
+		return 0, ErrInvalidCode
+	}
+
+	values, err := typeValues(t)
+	if err != nil {
+		return 0, err
+	}
+
+	values["code"] = t.Code
+	if _, ok := values["name"]; !ok {
+		values["name"] = t.Code
+	}
+
+	return s.repository.CreateType(ctx, values)
+}
+
+func (s *Service) UpdateType(ctx context.Context, t Type) (uint, error) {
+
+	values, err := typeValues(t)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(values) == 0 {
+		return 0, ErrEmptyPatch
+	}
+
+	updated, err := s.repository.UpdateType(ctx, t.Code, values)
+	if err == nil && updated == 0 {
+		return 0, ErrTypeNotFound
+	}
+
+	return updated, err
+}
+
+func (s *Service) DeleteType(ctx context.Context, code string) error {
+
+	usage, err := s.repository.TypeUsage(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if len(usage) == 0 {
+		return ErrTypeNotFound
+	}
+
+	if used := typeUsed(usage[0]); len(used) > 0 {
+		return fmt.Errorf("%w: %s", ErrTypeInUse, strings.Join(used, ", "))
+	}
+
+	return s.repository.DeleteType(ctx, code)
+}
+
+// typeUsed returns the sorted tables referencing the event type by TypeUsage,
+// the rows referencing a deleted type would be deleted with it
+func typeUsed(usage map[string]interface{}) []string {
+
+	var used []string
+	for key, count := range usage {
+		if util.ToInt64(count) > 0 {
+			used = append(used, key)
+		}
+	}
+	sort.Strings(used)
+
+	return used
+}
+
+func (s *Service) TypeList(ctx context.Context) ([]map[string]interface{}, error) {
+	return s.repository.TypeList(ctx)
+}
+
+func (s *Service) Type(ctx context.Context, code string) (map[string]interface{}, error) {
+
+	types, err := s.repository.TypeByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(types) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTypeNotFound, code)
+	}
+
+	return types[0], nil
+}
+
+func (s *Service) Event(ctx context.Context, id int64) (map[string]interface{}, error) {
+
+	events, err := s.repository.Event(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrEventNotFound, id)
+	}
+
+	return events[0], nil
+}
+
+func (s *Service) DuplicateList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error) {
+
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+
+	return s.repository.DuplicateList(ctx, params, limit)
+}
+
+// CreateEvent validates the payload against the schema of the event type and registers the event.
+// The registered event is returned, for a duplicate it is the original one with "duplicate" set.
+func (s *Service) CreateEvent(ctx context.Context, e Event) (map[string]interface{}, error) {
+
+	id, duplicate, err := s.createEvent(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	if duplicate {
+		metrics.EventDuplicates.WithLabelValues(e.Source, e.Type).Inc()
+
+		message := fmt.Sprintf("Duplicate event: source - %s, key - %s, event - %d", e.Source, e.IdempotencyKey, id)
+		s.zl.Sugar().Info(message)
+	}
+
+	result, err := s.Event(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	result["duplicate"] = duplicate
+
+	return result, nil
+}
+
+func (s *Service) createEvent(ctx context.Context, e Event) (int64, bool, error) {
+
+	eventType, err := s.Type(ctx, e.Type)
+	if err != nil {
+		return 0, false, err
+	}
+
+	payload, data, err := validatePayload(eventType, e.Type, e.Payload)
+	if err != nil {
+		return 0, false, err
+	}
+
+	lots, err := s.repository.Lot(ctx, e.LotId)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(lots) == 0 {
+		return 0, false, fmt.Errorf("%w: %d", ErrLotNotFound, e.LotId)
+	}
+
+	name := e.Name
+	if len(name) == 0 {
+		name = e.Type
+	}
+
+	values := make(map[string]interface{})
+	values["name"] = name
+	values["event_type_id"] = eventType["id"]
+	values["lot_id"] = e.LotId
+	values["payload"] = string(payload)
+	values["source"] = e.Source
+	if len(e.IdempotencyKey) > 0 {
+		values["idempotency_key"] = e.IdempotencyKey
+	}
+
+	rule, err := mergeRule(eventType["merge_rule"])
+	if err != nil {
+		return 0, false, err
+	}
+
+	merge := func(vars map[string]interface{}) (map[string]interface{}, error) {
+		return variables.Merge(vars, data, rule)
+	}
+
+	id, duplicate, err := s.repository.CreateEvent(ctx, values, lots[0]["order_id"], merge)
+	if err != nil || duplicate {
+		return id, duplicate, err
+	}
+
+	notification := make(map[string]interface{})
+	notification["event_id"] = id
+	notification["event_type_id"] = eventType["id"]
+	notification["event_type"] = e.Type
+	notification["lot_id"] = e.LotId
+	notification["node_id"] = nil
+
+	if err := s.webhook.Notify(ctx, webhook.KindEventReceived, notification); err != nil {
+		s.zl.Sugar().Error(err)
+	}
+
+	return id, false, nil
+}
+
+// validatePayload checks the payload against the schema of the event type,
+// an empty payload is an empty object
+func validatePayload(eventType map[string]interface{}, code string, payload json.RawMessage) (json.RawMessage, map[string]interface{}, error) {
+
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+
+	data, err := util.ToMap([]byte(payload))
+	if err != nil {
+		return nil, nil, ErrInvalidPayload
+	}
+
+	if eventType["payload_schema"] != nil {
+		schema, err := util.ToJSON(eventType["payload_schema"])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := jsonschema.Validate(schema, payload); err != nil {
+			return nil, nil, fmt.Errorf("event type %s: %w", code, err)
+		}
+	}
+
+	return payload, data, nil
+}
+
+func mergeRule(value interface{}) (rule variables.MergeRule, err error) {
+
+	data, err := util.ToJSON(value)
+	if err != nil {
+		return rule, err
+	}
+
+	err = json.Unmarshal(data, &rule)
+
+	return rule, err
+}
+
+func typeValues(t Type) (map[string]interface{}, error) {
+
+	values := make(map[string]interface{})
+	if t.Name != nil {
+		values["name"] = *t.Name
+	}
+	if t.Description != nil {
+		values["description"] = *t.Description
+	}
+
+	if len(t.PayloadSchema) > 0 {
+		if string(t.PayloadSchema) == "null" {
+			values["payload_schema"] = nil
+		} else {
+			if err := jsonschema.Check(t.PayloadSchema); err != nil {
+				return nil, err
+			}
+			values["payload_schema"] = string(t.PayloadSchema)
+		}
+	}
+
+	if t.MergeRule != nil {
+		if err := t.MergeRule.Check(); err != nil {
+			return nil, err
+		}
+
+		rule, err := json.Marshal(t.MergeRule)
+		if err != nil {
+			return nil, err
+		}
+		values["merge_rule"] = string(rule)
+	}
+
+	if t.WindowSeconds != nil {
+		if *t.WindowSeconds <= 0 {
+			return nil, ErrInvalidWindow
+		}
+		values["window_seconds"] = *t.WindowSeconds
+	}
+
+	return values, nil
+}
@@ -0,0 +1,31 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTypeUsed(t *testing.T) {
+
+	usage := map[string]interface{}{
+		"events":             int64(0),
+		"semaphores":         int64(0),
+		"nodes":              int64(0),
+		"trigger_conditions": int64(2),
+		"triggers":           int64(0),
+		"scheduled_events":   int64(1),
+		"subscriptions":      int64(3),
+	}
+
+	expected := []string{"scheduled_events", "subscriptions", "trigger_conditions"}
+	if used := typeUsed(usage); !reflect.DeepEqual(used, expected) {
+		t.Fatalf("expected %v, got %v", expected, used)
+	}
+
+	for key := range usage {
+		usage[key] = int64(0)
+	}
+	if used := typeUsed(usage); len(used) != 0 {
+		t.Fatalf("expected an unused type, got %v", used)
+	}
+}
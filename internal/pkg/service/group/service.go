@@ -0,0 +1,188 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/repository/group"
+)
+
+var (
+	ErrInvalidCode   = errors.New("processing group code must match " + codePattern.String())
+	ErrMissingId     = errors.New("processing group id is required")
+	ErrGroupNotFound = errors.New("processing group not found")
+	ErrEmptyPatch    = errors.New("nothing to update")
+	ErrNegative      = errors.New("processing group settings must not be negative")
+	ErrInvalidWeight = errors.New("processing group weight must be positive")
+)
+
+var codePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository *group.Repository
+}
+
+// Group is the settings of the orders of a group_id of _InfoReg_PG for the MultiTiling model.
+// A zero ConcurrencyLimit falls back to OMS2_MAX_ROBOT_GOROUTINES, a zero BatchSize does not limit the lots of a thread.
+// The threads are shared between the groups by Weight, MinThreads of them are guaranteed to a group with due orders.
+type Group struct {
+	Id                  *int64  `json:"id"`
+	Code                string  `json:"code"`
+	Name                *string `json:"name"`
+	ConcurrencyLimit    *int64  `json:"concurrency_limit"`
+	BatchSize           *int64  `json:"batch_size"`
+	PollIntervalSeconds *int64  `json:"poll_interval_seconds"`
+	Enabled             *bool   `json:"enabled"`
+	Weight              *int64  `json:"weight"`
+	MinThreads          *int64  `json:"min_threads"`
+}
+
+func NewService(cfg *oms.Config, r *group.Repository, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+	}
+}
+
+func (s *Service) CreateGroup(ctx context.Context, g Group) (uint, error) {
+
+	if g.Id == nil {
+		return 0, ErrMissingId
+	}
+
+	if !codePattern.MatchString(g.Code) {
+		return 0, ErrInvalidCode
+	}
+
+	values, err := groupValues(g)
+	if err != nil {
+		return 0, err
+	}
+
+	values["id"] = *g.Id
+	if _, ok := values["name"]; !ok {
+		values["name"] = g.Code
+	}
+
+	return s.repository.CreateGroup(ctx, values)
+}
+
+func (s *Service) UpdateGroup(ctx context.Context, g Group) (uint, error) {
+
+	if g.Id == nil {
+		return 0, ErrMissingId
+	}
+
+	values, err := groupValues(g)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(g.Code) > 0 {
+		if !codePattern.MatchString(g.Code) {
+			return 0, ErrInvalidCode
+		}
+		values["code"] = g.Code
+	}
+
+	if len(values) == 0 {
+		return 0, ErrEmptyPatch
+	}
+
+	updated, err := s.repository.UpdateGroup(ctx, *g.Id, values)
+	if err == nil && updated == 0 {
+		return 0, fmt.Errorf("%w: %d", ErrGroupNotFound, *g.Id)
+	}
+
+	return updated, err
+}
+
+// DeleteGroup returns the orders of the group to the default settings
+func (s *Service) DeleteGroup(ctx context.Context, id int64) error {
+
+	if _, err := s.Group(ctx, id); err != nil {
+		return err
+	}
+
+	return s.repository.DeleteGroup(ctx, id)
+}
+
+func (s *Service) GroupList(ctx context.Context) ([]map[string]interface{}, error) {
+	return s.repository.GroupList(ctx)
+}
+
+func (s *Service) Group(ctx context.Context, id int64) (map[string]interface{}, error) {
+
+	groups, err := s.repository.GroupById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrGroupNotFound, id)
+	}
+
+	return groups[0], nil
+}
+
+// groupValues returns the columns of the set fields, a zero limit or batch size is stored as null
+func groupValues(g Group) (map[string]interface{}, error) {
+
+	values := make(map[string]interface{})
+	if g.Name != nil {
+		values["name"] = *g.Name
+	}
+
+	optional := map[string]*int64{
+		"concurrency_limit": g.ConcurrencyLimit,
+		"batch_size":        g.BatchSize,
+	}
+	for column, value := range optional {
+		if value == nil {
+			continue
+		}
+		if *value < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNegative, column)
+		}
+		if *value == 0 {
+			values[column] = nil
+		} else {
+			values[column] = *value
+		}
+	}
+
+	if g.PollIntervalSeconds != nil {
+		if *g.PollIntervalSeconds < 0 {
+			return nil, fmt.Errorf("%w: poll_interval_seconds", ErrNegative)
+		}
+		values["poll_interval_seconds"] = *g.PollIntervalSeconds
+	}
+
+	if g.Enabled != nil {
+		values["enabled"] = *g.Enabled
+	}
+
+	if g.Weight != nil {
+		if *g.Weight <= 0 {
+			return nil, ErrInvalidWeight
+		}
+		values["weight"] = *g.Weight
+	}
+
+	if g.MinThreads != nil {
+		if *g.MinThreads < 0 {
+			return nil, fmt.Errorf("%w: min_threads", ErrNegative)
+		}
+		values["min_threads"] = *g.MinThreads
+	}
+
+	return values, nil
+}
@@ -1,15 +1,48 @@
 package health
 
-import "oms2/internal/oms"
+import (
+	"sync"
+
+	"oms2/internal/oms"
+)
+
+// Check is a part of the health output, the keys of its status are reported as is
+type Check interface {
+	Status() map[string]string
+}
 
 type Service struct {
 	cfg *oms.Config
+
+	mu     sync.RWMutex
+	checks []Check
 }
 
 func NewService(cfg *oms.Config) *Service {
 	return &Service{cfg: cfg}
 }
 
+// Register adds the check to the health output, the checks are registered at startup
+func (s *Service) Register(check Check) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks = append(s.checks, check)
+}
+
+// Health reports the service and the status of every registered check
 func (s *Service) Health() map[string]string {
-	return map[string]string{"status": "ok"}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := map[string]string{"status": "ok"}
+	for _, check := range s.checks {
+		for key, value := range check.Status() {
+			result[key] = value
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,41 @@
+package health
+
+import (
+	"testing"
+
+	"oms2/internal/oms"
+)
+
+type staticCheck map[string]string
+
+func (c staticCheck) Status() map[string]string {
+	return c
+}
+
+func TestHealth(t *testing.T) {
+
+	s := NewService(&oms.Config{})
+	s.Register(staticCheck{"leader": "true", "leader_token": "3"})
+	s.Register(staticCheck{"plugin.sms": "SERVING"})
+
+	health := s.Health()
+
+	expected := map[string]string{"status": "ok", "leader": "true", "leader_token": "3", "plugin.sms": "SERVING"}
+	if len(health) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, health)
+	}
+	for key, value := range expected {
+		if health[key] != value {
+			t.Fatalf("expected %s = %s, got %v", key, value, health)
+		}
+	}
+}
+
+func TestHealthWithoutChecks(t *testing.T) {
+
+	health := NewService(&oms.Config{}).Health()
+
+	if len(health) != 1 || health["status"] != "ok" {
+		t.Fatalf("expected only the status, got %v", health)
+	}
+}
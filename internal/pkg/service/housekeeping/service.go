@@ -0,0 +1,142 @@
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/metrics"
+	"oms2/internal/pkg/repository/housekeeping"
+	"oms2/internal/pkg/service/leader"
+)
+
+var (
+	_ archiver = (*housekeeping.Repository)(nil)
+	_ fencer   = (*leader.Service)(nil)
+)
+
+const (
+	TableSemaphores = "_InfoReg_ES"
+	TableEvents     = "_Ref_E"
+	TableRunLog     = "_InfoReg_RL"
+)
+
+// archiver is the part of the repository moving the rows out of the working tables
+type archiver interface {
+	ArchiveSemaphores(ctx context.Context, window time.Duration, limit int) (int64, error)
+	ArchiveEvents(ctx context.Context, before time.Time, limit int) (int64, error)
+	PurgeRunLog(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// fencer runs a batch only while this instance is the leader
+type fencer interface {
+	IsLeader() bool
+	Fenced(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Service periodically moves consumed and expired semaphores and old events to the archive tables
+// and deletes the old rows of the run log
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository archiver
+	leader     fencer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(cfg *oms.Config, r *housekeeping.Repository, leader *leader.Service, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+		leader:     leader,
+	}
+}
+
+func (s *Service) Start(_ context.Context) error {
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.Housekeeping.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if !s.leader.IsLeader() {
+					continue
+				}
+				if _, err := s.Run(s.ctx); err != nil {
+					s.zl.Sugar().Error(err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *Service) Stop(_ context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	return nil
+}
+
+// Run archives in batches until nothing is left and returns the number of rows moved per table.
+// Semaphores go first, events are archived once no semaphore references them, the run log is purged last.
+// Every batch is fenced by the token of the leader.
+func (s *Service) Run(ctx context.Context) (map[string]int64, error) {
+
+	result := make(map[string]int64)
+
+	archive := map[string]func(ctx context.Context) (int64, error){
+		TableSemaphores: func(ctx context.Context) (int64, error) {
+			return s.repository.ArchiveSemaphores(ctx, s.cfg.EventWindow, s.cfg.Housekeeping.BatchSize)
+		},
+		TableEvents: func(ctx context.Context) (int64, error) {
+			before := time.Now().Add(-s.cfg.Housekeeping.EventRetention)
+			return s.repository.ArchiveEvents(ctx, before, s.cfg.Housekeeping.BatchSize)
+		},
+		TableRunLog: func(ctx context.Context) (int64, error) {
+			before := time.Now().Add(-s.cfg.Housekeeping.RunLogRetention)
+			return s.repository.PurgeRunLog(ctx, before, s.cfg.Housekeeping.BatchSize)
+		},
+	}
+
+	for _, table := range []string{TableSemaphores, TableEvents, TableRunLog} {
+		for {
+			var moved int64
+			err := s.leader.Fenced(ctx, func(ctx context.Context) (err error) {
+				moved, err = archive[table](ctx)
+				return err
+			})
+			if err != nil {
+				return result, err
+			}
+
+			result[table] += moved
+			metrics.ArchivedRows.WithLabelValues(table).Add(float64(moved))
+
+			if moved < int64(s.cfg.Housekeeping.BatchSize) || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	if result[TableSemaphores] > 0 || result[TableEvents] > 0 || result[TableRunLog] > 0 {
+		message := fmt.Sprintf("Archived: semaphores - %d, events - %d, purged run log - %d", result[TableSemaphores], result[TableEvents], result[TableRunLog])
+		s.zl.Sugar().Info(message)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package housekeeping
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/config"
+)
+
+// memoryArchive moves rows of the tables in batches of at most limit
+type memoryArchive struct {
+	rows  map[string]int64
+	calls []string
+	fail  map[string]error
+}
+
+func (m *memoryArchive) move(table string, limit int) (int64, error) {
+
+	m.calls = append(m.calls, table)
+	if err := m.fail[table]; err != nil {
+		return 0, err
+	}
+
+	moved := m.rows[table]
+	if moved > int64(limit) {
+		moved = int64(limit)
+	}
+	m.rows[table] -= moved
+
+	return moved, nil
+}
+
+func (m *memoryArchive) ArchiveSemaphores(_ context.Context, _ time.Duration, limit int) (int64, error) {
+	return m.move(TableSemaphores, limit)
+}
+
+func (m *memoryArchive) ArchiveEvents(_ context.Context, _ time.Time, limit int) (int64, error) {
+	return m.move(TableEvents, limit)
+}
+
+func (m *memoryArchive) PurgeRunLog(_ context.Context, _ time.Time, limit int) (int64, error) {
+	return m.move(TableRunLog, limit)
+}
+
+// memoryLeader runs the batches while it is the leader
+type memoryLeader struct {
+	leader bool
+	fenced int
+}
+
+var errNotLeader = errors.New("not the leader")
+
+func (m *memoryLeader) IsLeader() bool {
+	return m.leader
+}
+
+func (m *memoryLeader) Fenced(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	if !m.leader {
+		return errNotLeader
+	}
+	m.fenced += 1
+
+	return fn(ctx)
+}
+
+func newTestService(archive *memoryArchive, leader *memoryLeader) *Service {
+
+	cfg := &oms.Config{Housekeeping: config.Housekeeping{BatchSize: 10, EventRetention: time.Hour, RunLogRetention: time.Hour}}
+
+	return &Service{zl: zap.NewNop(), cfg: cfg, repository: archive, leader: leader}
+}
+
+func TestRunBatches(t *testing.T) {
+
+	archive := &memoryArchive{rows: map[string]int64{TableSemaphores: 25, TableEvents: 10, TableRunLog: 3}}
+	leader := &memoryLeader{leader: true}
+
+	result, err := newTestService(archive, leader).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result[TableSemaphores] != 25 || result[TableEvents] != 10 || result[TableRunLog] != 3 {
+		t.Fatalf("unexpected result %v", result)
+	}
+
+	// a full batch is followed by another one, a short batch ends the table
+	expected := []string{TableSemaphores, TableSemaphores, TableSemaphores, TableEvents, TableEvents, TableRunLog}
+	if len(archive.calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, archive.calls)
+	}
+	for i := range expected {
+		if archive.calls[i] != expected[i] {
+			t.Fatalf("expected calls %v, got %v", expected, archive.calls)
+		}
+	}
+
+	if leader.fenced != len(expected) {
+		t.Fatalf("expected every batch fenced, got %d of %d", leader.fenced, len(expected))
+	}
+}
+
+func TestRunError(t *testing.T) {
+
+	failure := errors.New("archive failed")
+	archive := &memoryArchive{
+		rows: map[string]int64{TableSemaphores: 5, TableEvents: 5, TableRunLog: 5},
+		fail: map[string]error{TableEvents: failure},
+	}
+
+	result, err := newTestService(archive, &memoryLeader{leader: true}).Run(context.Background())
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+
+	if result[TableSemaphores] != 5 {
+		t.Fatalf("expected semaphores archived before the failure, got %v", result)
+	}
+
+	if archive.rows[TableRunLog] != 5 {
+		t.Fatal("run log purged after a failure")
+	}
+}
+
+func TestRunNotLeader(t *testing.T) {
+
+	archive := &memoryArchive{rows: map[string]int64{TableSemaphores: 5}}
+
+	_, err := newTestService(archive, &memoryLeader{}).Run(context.Background())
+	if !errors.Is(err, errNotLeader) {
+		t.Fatalf("expected %v, got %v", errNotLeader, err)
+	}
+
+	if len(archive.calls) != 0 {
+		t.Fatalf("archived without leadership: %v", archive.calls)
+	}
+}
@@ -0,0 +1,256 @@
+// Package leader elects one instance of the service for singleton duties with a Postgres session
+// advisory lock. The leader holds the lock on a dedicated connection outside of the pool, when the instance dies
+// the session ends and another instance takes the lock on its next attempt.
+// Every new leader gets the next epoch of _InfoReg_LE as its fencing token.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/metrics"
+	"oms2/internal/pkg/repository/leader"
+	"oms2/internal/pkg/util"
+)
+
+// Name of the lock of the robot duties
+const Name = "oms2.robot"
+
+var ErrNotLeader = errors.New("instance is not the leader")
+
+// session is the connection holding the advisory lock of the leader
+type session interface {
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+	TryLock(ctx context.Context, name string) (bool, error)
+	Unlock(ctx context.Context, name string) error
+	NextEpoch(ctx context.Context, name string, instanceId string) (int64, error)
+}
+
+// epochs is the part of the repository checking the epoch in the transactions of the leader
+type epochs interface {
+	Epoch(ctx context.Context, name string) ([]map[string]interface{}, error)
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+var (
+	_ session = (*leader.Session)(nil)
+	_ epochs  = (*leader.Repository)(nil)
+)
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository epochs
+
+	// connect opens the session of the leader, it is Repository.Session
+	connect func(ctx context.Context) (session, error)
+
+	mu      sync.RWMutex
+	session session
+	token   int64
+	since   time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewService(cfg *oms.Config, r *leader.Repository, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+		connect: func(ctx context.Context) (session, error) {
+			session, err := r.Session(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return session, nil
+		},
+	}
+}
+
+// Start tries to become the leader at once and then every retry interval,
+// the leader checks its session on the same interval and steps down when it is lost
+func (s *Service) Start(_ context.Context) error {
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+
+	s.Elect(s.ctx)
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.cfg.Leader.RetryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.Elect(s.ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop gives up the leadership so another instance takes it over without waiting for the session to end
+func (s *Service) Stop(ctx context.Context) error {
+
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+
+	s.stepDown(ctx, nil)
+
+	return nil
+}
+
+// Elect keeps the leadership or tries to acquire it. An attempt takes at most the retry interval,
+// a session that does not answer in time, e.g. on a half-open connection, is lost and the leader steps down.
+func (s *Service) Elect(ctx context.Context) {
+
+	attempt, cancel := context.WithTimeout(ctx, s.cfg.Leader.RetryInterval)
+	defer cancel()
+
+	s.mu.RLock()
+	session := s.session
+	s.mu.RUnlock()
+
+	if session != nil {
+		if err := session.Ping(attempt); err != nil && ctx.Err() == nil {
+			s.stepDown(attempt, err)
+		}
+		return
+	}
+
+	if err := s.acquire(attempt); err != nil && ctx.Err() == nil {
+		s.zl.Sugar().Error(fmt.Errorf("leader election: %w", err))
+	}
+}
+
+func (s *Service) acquire(ctx context.Context) error {
+
+	session, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	locked, err := session.TryLock(ctx, Name)
+	if err != nil || !locked {
+		_ = session.Close(ctx)
+		return err
+	}
+
+	token, err := session.NextEpoch(ctx, Name, s.cfg.InstanceId)
+	if err != nil {
+		_ = session.Close(ctx)
+		return err
+	}
+
+	s.mu.Lock()
+	s.session = session
+	s.token = token
+	s.since = time.Now()
+	s.mu.Unlock()
+
+	metrics.Leader.Set(1)
+	s.zl.Sugar().Info(fmt.Sprintf("Instance %s is the leader, token - %d", s.cfg.InstanceId, token))
+
+	return nil
+}
+
+// stepDown releases the lock and closes the session, a lost session releases it on the server side
+func (s *Service) stepDown(ctx context.Context, reason error) {
+
+	s.mu.Lock()
+	session, token := s.session, s.token
+	s.session = nil
+	s.token = 0
+	s.mu.Unlock()
+
+	if session == nil {
+		return
+	}
+
+	if reason == nil {
+		if err := session.Unlock(ctx, Name); err != nil {
+			s.zl.Sugar().Error(err)
+		}
+	}
+	_ = session.Close(ctx)
+
+	metrics.Leader.Set(0)
+	s.zl.Sugar().Info(fmt.Sprintf("Instance %s is no longer the leader, token - %d, reason - %v", s.cfg.InstanceId, token, reason))
+}
+
+func (s *Service) IsLeader() bool {
+	_, ok := s.Token()
+	return ok
+}
+
+// Token returns the fencing token of the leadership of the instance
+func (s *Service) Token() (int64, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.token, s.session != nil
+}
+
+// Fenced runs fn in a transaction only if the token of the instance is still the current epoch.
+// A new leader waits for the transaction to end before its epoch starts,
+// so the writes of fn are never made by a stale leader.
+func (s *Service) Fenced(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	token, ok := s.Token()
+	if !ok {
+		return ErrNotLeader
+	}
+
+	return s.repository.InTransaction(ctx, func(ctx context.Context) error {
+
+		epochs, err := s.repository.Epoch(ctx, Name)
+		if err != nil {
+			return err
+		}
+
+		if len(epochs) == 0 || util.ToInt64(epochs[0]["epoch"]) != token {
+			return fmt.Errorf("%w: token %d is stale", ErrNotLeader, token)
+		}
+
+		return fn(ctx)
+	})
+}
+
+// Status is the leadership of the instance for the health output
+func (s *Service) Status() map[string]string {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := map[string]string{
+		"instance_id": s.cfg.InstanceId,
+		"leader":      strconv.FormatBool(s.session != nil),
+	}
+
+	if s.session != nil {
+		status["leader_token"] = strconv.FormatInt(s.token, 10)
+		status["leader_since"] = s.since.Format(time.RFC3339)
+	}
+
+	return status
+}
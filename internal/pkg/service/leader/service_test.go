@@ -0,0 +1,302 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/config"
+)
+
+var errSessionLost = errors.New("session lost")
+
+// memoryDb keeps the holder of the advisory lock and the epoch of _InfoReg_LE
+type memoryDb struct {
+	mu       sync.Mutex
+	holder   *memorySession
+	epoch    int64
+	instance string
+}
+
+func (db *memoryDb) open(_ context.Context) (session, error) {
+	return &memorySession{db: db}, nil
+}
+
+func (db *memoryDb) Epoch(_ context.Context, _ string) ([]map[string]interface{}, error) {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.epoch == 0 {
+		return nil, nil
+	}
+
+	return []map[string]interface{}{{"epoch": db.epoch, "instance_id": db.instance}}, nil
+}
+
+func (db *memoryDb) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// memorySession is a session of the memory db, a killed session loses its lock like a lost connection,
+// a hung one does not answer like a half-open connection
+type memorySession struct {
+	db     *memoryDb
+	killed bool
+	hung   bool
+	closed bool
+}
+
+func (s *memorySession) kill() {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.killed = true
+	if s.db.holder == s {
+		s.db.holder = nil
+	}
+}
+
+func (s *memorySession) Ping(ctx context.Context) error {
+
+	s.db.mu.Lock()
+	killed, hung := s.killed, s.hung
+	s.db.mu.Unlock()
+
+	if hung {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if killed {
+		return errSessionLost
+	}
+
+	return nil
+}
+
+func (s *memorySession) Close(_ context.Context) error {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.closed = true
+	if s.db.holder == s {
+		s.db.holder = nil
+	}
+
+	return nil
+}
+
+func (s *memorySession) TryLock(_ context.Context, _ string) (bool, error) {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.holder != nil {
+		return false, nil
+	}
+	s.db.holder = s
+
+	return true, nil
+}
+
+func (s *memorySession) Unlock(_ context.Context, _ string) error {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.holder == s {
+		s.db.holder = nil
+	}
+
+	return nil
+}
+
+func (s *memorySession) NextEpoch(_ context.Context, _ string, instanceId string) (int64, error) {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.epoch += 1
+	s.db.instance = instanceId
+
+	return s.db.epoch, nil
+}
+
+func newTestService(db *memoryDb, instanceId string) *Service {
+	return &Service{
+		zl:         zap.NewNop(),
+		cfg:        &oms.Config{InstanceId: instanceId, Leader: config.Leader{RetryInterval: 50 * time.Millisecond}},
+		repository: db,
+		connect:    db.open,
+	}
+}
+
+func TestElection(t *testing.T) {
+
+	ctx := context.Background()
+	db := &memoryDb{}
+	a := newTestService(db, "a")
+	b := newTestService(db, "b")
+
+	a.Elect(ctx)
+	b.Elect(ctx)
+
+	token, ok := a.Token()
+	if !ok || token != 1 {
+		t.Fatalf("expected a to lead with token 1, got %d, %v", token, ok)
+	}
+	if b.IsLeader() {
+		t.Fatal("expected b not to lead while a holds the lock")
+	}
+
+	// a session failing to take the lock is not kept open
+	if db.holder != a.session {
+		t.Fatal("expected the lock held by the session of a")
+	}
+
+	a.Elect(ctx)
+	if token, _ := a.Token(); token != 1 {
+		t.Fatalf("expected a to keep token 1, got %d", token)
+	}
+}
+
+func TestFailover(t *testing.T) {
+
+	ctx := context.Background()
+	db := &memoryDb{}
+	a := newTestService(db, "a")
+	b := newTestService(db, "b")
+
+	a.Elect(ctx)
+	lost := a.session.(*memorySession)
+	lost.kill()
+
+	b.Elect(ctx)
+	token, ok := b.Token()
+	if !ok || token != 2 {
+		t.Fatalf("expected b to take over with token 2, got %d, %v", token, ok)
+	}
+
+	a.Elect(ctx)
+	if a.IsLeader() {
+		t.Fatal("expected a to step down after its session is lost")
+	}
+	if !lost.closed {
+		t.Fatal("expected the lost session closed")
+	}
+
+	// a does not take the lock back while b holds it
+	a.Elect(ctx)
+	if a.IsLeader() {
+		t.Fatal("expected a not to lead while b holds the lock")
+	}
+}
+
+func TestFencedStale(t *testing.T) {
+
+	ctx := context.Background()
+	db := &memoryDb{}
+	a := newTestService(db, "a")
+	b := newTestService(db, "b")
+
+	a.Elect(ctx)
+	a.session.(*memorySession).kill()
+	b.Elect(ctx)
+
+	// a has not noticed the lost session yet and still holds token 1
+	if !a.IsLeader() {
+		t.Fatal("expected a to think it leads until its next check")
+	}
+
+	called := false
+	err := a.Fenced(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", ErrNotLeader, err)
+	}
+	if called {
+		t.Fatal("expected the writes of a stale leader fenced off")
+	}
+
+	err = b.Fenced(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if err != nil || !called {
+		t.Fatalf("expected the writes of the leader made, got %v", err)
+	}
+}
+
+func TestFencedNotLeader(t *testing.T) {
+
+	db := &memoryDb{}
+	err := newTestService(db, "a").Fenced(context.Background(), func(ctx context.Context) error {
+		t.Fatal("expected fn not called")
+		return nil
+	})
+
+	if !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", ErrNotLeader, err)
+	}
+}
+
+func TestStopHandsOver(t *testing.T) {
+
+	ctx := context.Background()
+	db := &memoryDb{}
+	a := newTestService(db, "a")
+	b := newTestService(db, "b")
+
+	a.Elect(ctx)
+	session := a.session.(*memorySession)
+
+	if err := a.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if a.IsLeader() || !session.closed {
+		t.Fatal("expected a to step down and close its session")
+	}
+
+	b.Elect(ctx)
+	if token, ok := b.Token(); !ok || token != 2 {
+		t.Fatalf("expected b to lead with token 2, got %d, %v", token, ok)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+
+	ctx := context.Background()
+	db := &memoryDb{}
+	a := newTestService(db, "a")
+
+	a.Elect(ctx)
+	session := a.session.(*memorySession)
+	db.mu.Lock()
+	session.hung = true
+	db.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.Elect(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the ping of a hung session to time out")
+	}
+
+	if a.IsLeader() || !session.closed {
+		t.Fatal("expected a to step down and close the hung session")
+	}
+}
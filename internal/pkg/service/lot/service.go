@@ -0,0 +1,98 @@
+package lot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/repository/lot"
+	"oms2/internal/pkg/trigger"
+	"oms2/internal/pkg/util"
+	"oms2/internal/pkg/variables"
+)
+
+var ErrLotNotFound = errors.New("lot not found")
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository *lot.Repository
+}
+
+func NewService(cfg *oms.Config, r *lot.Repository, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+	}
+}
+
+func (s *Service) Variables(ctx context.Context, lotId int64) (map[string]interface{}, error) {
+
+	lots, err := s.repository.Lot(ctx, lotId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lots) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrLotNotFound, lotId)
+	}
+
+	return util.ToMap(lots[0]["variables"])
+}
+
+// UpdateVariables applies patch to the variables of the lot, nil values remove variables.
+// With replace the variables are replaced by patch as a whole.
+func (s *Service) UpdateVariables(ctx context.Context, lotId int64, patch map[string]interface{}, replace bool) (map[string]interface{}, error) {
+
+	result, err := s.repository.UpdateVariables(ctx, lotId, func(current map[string]interface{}) (map[string]interface{}, error) {
+		if replace {
+			return variables.Patch(nil, patch), nil
+		}
+		return variables.Patch(current, patch), nil
+	})
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrLotNotFound, lotId)
+	}
+
+	return result, err
+}
+
+// Triggers returns the trigger nodes of the lot waiting for a combination of events
+// with the event types received so far and the ones still missing
+func (s *Service) Triggers(ctx context.Context, lotId int64) ([]map[string]interface{}, error) {
+
+	lots, err := s.repository.Lot(ctx, lotId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lots) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrLotNotFound, lotId)
+	}
+
+	state, err := s.repository.TriggerState(ctx, lotId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range state {
+		condition, received := trigger.FromState(item)
+		satisfied, missing, err := trigger.Evaluate(condition, received)
+		if err != nil {
+			item["error"] = err.Error()
+			continue
+		}
+
+		item["required"] = condition.Required
+		item["received"] = received
+		item["missing"] = missing
+		item["satisfied"] = satisfied
+	}
+
+	return state, nil
+}
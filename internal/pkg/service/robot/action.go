@@ -10,6 +10,7 @@ import (
 	actionR "oms2/internal/pkg/repository/action"
 )
 
+// Action holds the built-in actions, they are added to the Registry by Register
 type Action struct {
 	zl      *zap.Logger
 	cfg     *oms.Config
@@ -24,9 +25,25 @@ func NewAction(cfg *oms.Config, actionR *actionR.Repository, zl *zap.Logger) *Ac
 	}
 }
 
-func (a *Action) FirstInit(ctx interface{}, data interface{}) error {
+// Register adds the built-in actions to the registry
+func (a *Action) Register(r *Registry) error {
 
-	c := ctx.(context.Context)
+	actions := map[string]ActionHandler{
+		"FirstInit":  ApplyFunc(a.FirstInit),
+		"SecondInit": ActionFunc(a.SecondInit),
+	}
+
+	for name, handler := range actions {
+		if err := r.Register(name, handler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FirstInit works with the database only, it runs in the step transaction
+func (a *Action) FirstInit(ctx context.Context, in ActionInput, out ActionOutput) (ActionOutput, error) {
 
 	_sql, args, err := squirrel.
 		StatementBuilder.
@@ -35,19 +52,19 @@ func (a *Action) FirstInit(ctx interface{}, data interface{}) error {
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
-		return err
+		return ActionOutput{}, err
 	}
-	list, err := a.actionR.RootRepository.Get(c, _sql, args...)
+	list, err := a.actionR.RootRepository.Get(ctx, _sql, args...)
 	if err != nil {
-		return err
+		return ActionOutput{}, err
 	}
 
-	a.zl.Sugar().Info("FirstInit", data, list)
-	return nil
+	a.zl.Sugar().Info("FirstInit", in, list)
+	return out, nil
 }
 
-func (a *Action) SecondInit(ctx interface{}, data interface{}) error {
+func (a *Action) SecondInit(_ context.Context, in ActionInput) (ActionOutput, error) {
 
-	a.zl.Sugar().Info("SecondInit", data)
-	return nil
+	a.zl.Sugar().Info("SecondInit", in)
+	return ActionOutput{}, nil
 }
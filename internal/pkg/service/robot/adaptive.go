@@ -0,0 +1,71 @@
+package robot
+
+import (
+	"math"
+	"time"
+)
+
+// LoadSample is the load of the database over a sampling interval
+type LoadSample struct {
+	// Latency is the mean duration of the queries on an acquired connection
+	Latency time.Duration
+	// Wait is the mean wait for a connection of the pool
+	Wait time.Duration
+	// Saturated is set when the threads in flight reached the limit
+	Saturated bool
+}
+
+// AIMD adapts the concurrent threads to the load of the database: the limit grows by one thread
+// while the latency and the wait stay under their targets and the threads use the whole limit,
+// and is cut by Backoff as soon as one of them is over its target
+type AIMD struct {
+	min           int
+	max           int
+	backoff       float64
+	targetLatency time.Duration
+	targetWait    time.Duration
+
+	limit float64
+}
+
+func NewAIMD(min int, max int, backoff float64, targetLatency time.Duration, targetWait time.Duration) *AIMD {
+
+	if max < 1 {
+		max = 1
+	}
+	if min < 1 {
+		min = 1
+	}
+	if min > max {
+		min = max
+	}
+	if backoff <= 0 || backoff >= 1 {
+		backoff = 0.7
+	}
+
+	return &AIMD{
+		min:           min,
+		max:           max,
+		backoff:       backoff,
+		targetLatency: targetLatency,
+		targetWait:    targetWait,
+		limit:         float64(max),
+	}
+}
+
+// Update applies the sample and returns the new limit
+func (a *AIMD) Update(sample LoadSample) int {
+
+	switch {
+	case sample.Latency > a.targetLatency || sample.Wait > a.targetWait:
+		a.limit = math.Max(float64(a.min), a.limit*a.backoff)
+	case sample.Saturated:
+		a.limit = math.Min(float64(a.max), math.Floor(a.limit)+1)
+	}
+
+	return a.Limit()
+}
+
+func (a *AIMD) Limit() int {
+	return int(a.limit)
+}
@@ -0,0 +1,40 @@
+package robot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMD(t *testing.T) {
+
+	limiter := NewAIMD(2, 10, 0.5, 20*time.Millisecond, 5*time.Millisecond)
+	if limit := limiter.Limit(); limit != 10 {
+		t.Fatalf("initial limit = %d, want the maximum 10", limit)
+	}
+
+	steps := []struct {
+		name   string
+		sample LoadSample
+		want   int
+	}{
+		{"slow queries", LoadSample{Latency: 50 * time.Millisecond, Saturated: true}, 5},
+		{"wait for connections", LoadSample{Wait: 10 * time.Millisecond}, 2},
+		{"not below the minimum", LoadSample{Latency: time.Second}, 2},
+		{"healthy and saturated", LoadSample{Latency: time.Millisecond, Saturated: true}, 3},
+		{"healthy and idle", LoadSample{Latency: time.Millisecond}, 3},
+		{"healthy and saturated again", LoadSample{Saturated: true}, 4},
+	}
+
+	for _, step := range steps {
+		if limit := limiter.Update(step.sample); limit != step.want {
+			t.Errorf("%s: limit = %d, want %d", step.name, limit, step.want)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		limiter.Update(LoadSample{Saturated: true})
+	}
+	if limit := limiter.Limit(); limit != 10 {
+		t.Errorf("limit = %d, want not above the maximum 10", limit)
+	}
+}
@@ -0,0 +1,46 @@
+package robot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLimitBatch(t *testing.T) {
+
+	lots := func(orders ...int) []map[string]interface{} {
+		result := make([]map[string]interface{}, 0, len(orders))
+		for i, orderId := range orders {
+			result = append(result, map[string]interface{}{"lot_id": i + 1, "order_id": orderId})
+		}
+		return result
+	}
+
+	orders := func(lots []map[string]interface{}) []int {
+		result := make([]int, 0, len(lots))
+		for _, lot := range lots {
+			result = append(result, lot["order_id"].(int))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name  string
+		lots  []map[string]interface{}
+		batch int
+		want  []int
+	}{
+		{"no limit", lots(1, 1, 2), 0, []int{1, 1, 2}},
+		{"under limit", lots(1, 2), 5, []int{1, 2}},
+		{"whole orders", lots(1, 2, 1, 3, 3), 3, []int{1, 2, 1}},
+		{"first order over limit", lots(1, 1, 1, 2), 2, []int{1, 1, 1}},
+		{"order not fitting is left", lots(1, 2, 2, 3), 2, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orders(LimitBatch(tt.lots, tt.batch)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LimitBatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
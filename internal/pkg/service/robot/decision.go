@@ -0,0 +1,126 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"oms2/internal/pkg/script"
+	"oms2/internal/pkg/util"
+)
+
+const (
+	// GlobalVariables is the dict of the lot variables, scripts of actions may change it
+	GlobalVariables = "variables"
+	// GlobalParams is the dict of the node params
+	GlobalParams = "params"
+	// GlobalEvent is the last event of the lot: id, type, payload, or None
+	GlobalEvent = "event"
+	// GlobalLot is the lot on the node: lot_id, order_id, node_id, node_name and the token of a pending outcome
+	GlobalLot = "lot"
+)
+
+// ScriptGlobalNames are the globals predeclared for scripts and conditions
+var ScriptGlobalNames = []string{GlobalVariables, GlobalParams, GlobalEvent, GlobalLot}
+
+var ErrNoBranch = errors.New("decision node has no branches")
+
+// ScriptGlobals returns the globals of scripts and conditions for the action input
+func ScriptGlobals(in ActionInput) map[string]interface{} {
+
+	var event interface{}
+	if in.Event != nil {
+		event = map[string]interface{}{
+			"id":      in.Event.Id,
+			"type":    in.Event.Type,
+			"payload": in.Event.Payload,
+		}
+	}
+
+	params := in.Params
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
+	return map[string]interface{}{
+		GlobalVariables: in.Variables,
+		GlobalParams:    params,
+		GlobalEvent:     event,
+		GlobalLot: map[string]interface{}{
+			"lot_id":    in.LotId,
+			"order_id":  in.OrderId,
+			"node_id":   in.NodeId,
+			"node_name": in.NodeName,
+			"token":     in.Token,
+		},
+	}
+}
+
+// ScriptLimits are the limits of a single script run from the config
+func (s *Service) ScriptLimits() script.Limits {
+	return script.Limits{
+		MaxSteps: s.cfg.Script.MaxSteps,
+		Timeout:  s.cfg.Script.Timeout,
+	}
+}
+
+// DoDecision evaluates the branch conditions of the decision node in order
+// and moves the lot to the target of the first true one, without a true branch
+// the lot moves to the next node
+func (s *Service) DoDecision(ctx context.Context, data map[string]interface{}) error {
+
+	in, err := NewActionInput(data)
+	if err != nil {
+		return err
+	}
+
+	branches, err := s.robotRepository.DecisionBranches(ctx, in.NodeId)
+	if err != nil {
+		return err
+	}
+
+	globals := ScriptGlobals(in)
+	for _, branch := range branches {
+		ok, err := script.Eval(ctx, fmt.Sprint(branch["condition"]), globals, s.ScriptLimits())
+		if err != nil {
+			return fmt.Errorf("node %s branch %v: %w", in.NodeName, branch["position"], err)
+		}
+
+		if ok {
+			return s.RecordToNextStep(ctx, data, util.ToInt64(branch["target_node_id"]))
+		}
+	}
+
+	return s.StepToNextNode(ctx, data)
+}
+
+// ValidateDecisions checks that every decision node has branches and their conditions compile
+func (s *Service) ValidateDecisions(ctx context.Context) error {
+
+	nodes, err := s.robotRepository.DecisionNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	branches, err := s.robotRepository.DecisionBranches(ctx)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[int64]int)
+	for _, branch := range branches {
+		counts[util.ToInt64(branch["node_id"])]++
+
+		if err := script.CheckExpr(fmt.Sprint(branch["condition"]), ScriptGlobalNames...); err != nil {
+			return fmt.Errorf("node %v branch %v: %w", branch["node_name"], branch["position"], err)
+		}
+	}
+
+	for _, node := range nodes {
+		if counts[util.ToInt64(node["id"])] == 0 {
+			return fmt.Errorf("%w: %v", ErrNoBranch, node["name"])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package robot
+
+import (
+	"math"
+	"sort"
+)
+
+// GroupShare is the claim of a processing group on the threads of the robot.
+// Demand is the threads the group can use now, Limit caps them if set, Min is guaranteed while there is demand.
+type GroupShare struct {
+	Id     int32
+	Weight float64
+	Min    int
+	Limit  int
+	Demand int
+}
+
+// FairScheduler divides the threads of the robot between the groups by weight, like weighted fair queuing.
+// The fractions of a thread a group is owed are carried to the next allocation,
+// so over cycles the shares follow the weights even when the capacity is small.
+type FairScheduler struct {
+	credit map[int32]float64
+}
+
+func NewFairScheduler() *FairScheduler {
+	return &FairScheduler{credit: make(map[int32]float64)}
+}
+
+// Allocate returns the threads of every group out of capacity. A group with demand first gets its minimum,
+// by weight if the minimums do not fit, the rest is shared by weight, a group never gets more than its demand
+// and limit and what it cannot take goes to the others. A group without demand loses its credit.
+func (f *FairScheduler) Allocate(capacity int, groups []GroupShare) map[int32]int {
+
+	result := make(map[int32]int, len(groups))
+
+	headroom := make(map[int32]int, len(groups))
+	active := make([]GroupShare, 0, len(groups))
+	for _, group := range groups {
+		top := group.Demand
+		if group.Limit > 0 && group.Limit < top {
+			top = group.Limit
+		}
+		if top <= 0 || group.Weight <= 0 {
+			delete(f.credit, group.Id)
+			continue
+		}
+		headroom[group.Id] = top
+		active = append(active, group)
+	}
+
+	sort.SliceStable(active, func(i, j int) bool {
+		if active[i].Weight != active[j].Weight {
+			return active[i].Weight > active[j].Weight
+		}
+		return active[i].Id < active[j].Id
+	})
+
+	remaining := capacity
+	for _, group := range active {
+		guaranteed := minInt(group.Min, headroom[group.Id], remaining)
+		result[group.Id] = guaranteed
+		headroom[group.Id] -= guaranteed
+		remaining -= guaranteed
+	}
+
+	ideal := waterFill(float64(remaining), active, headroom)
+
+	// whole threads by the ideal share and the carried credit, the threads left by rounding down
+	// go to the groups owed the most
+	want := make(map[int32]float64, len(active))
+	granted := 0
+	for _, group := range active {
+		want[group.Id] = ideal[group.Id] + f.credit[group.Id]
+		threads := int(math.Floor(want[group.Id]))
+		threads = minInt(threads, headroom[group.Id], remaining-granted)
+		if threads < 0 {
+			threads = 0
+		}
+		result[group.Id] += threads
+		headroom[group.Id] -= threads
+		want[group.Id] -= float64(threads)
+		granted += threads
+	}
+
+	total := 0.0
+	for _, value := range ideal {
+		total += value
+	}
+	left := int(math.Round(total)) - granted
+
+	for ; left > 0; left-- {
+		var owed *GroupShare
+		for i, group := range active {
+			if headroom[group.Id] > 0 && (owed == nil || want[group.Id] > want[owed.Id]) {
+				owed = &active[i]
+			}
+		}
+		if owed == nil {
+			break
+		}
+		result[owed.Id] += 1
+		headroom[owed.Id] -= 1
+		want[owed.Id] -= 1
+	}
+
+	for _, group := range active {
+		if headroom[group.Id] == 0 {
+			// a group at its demand or limit is not owed anything
+			f.credit[group.Id] = 0
+		} else {
+			f.credit[group.Id] = math.Max(-1, math.Min(1, want[group.Id]))
+		}
+	}
+
+	return result
+}
+
+// waterFill shares capacity by weight, a group gets at most its headroom and the excess goes to the others
+func waterFill(capacity float64, groups []GroupShare, headroom map[int32]int) map[int32]float64 {
+
+	result := make(map[int32]float64, len(groups))
+
+	open := make([]GroupShare, 0, len(groups))
+	for _, group := range groups {
+		if headroom[group.Id] > 0 {
+			open = append(open, group)
+		}
+	}
+
+	for capacity > 1e-9 && len(open) > 0 {
+
+		weights := 0.0
+		for _, group := range open {
+			weights += group.Weight
+		}
+
+		next := open[:0:0]
+		spent := 0.0
+		for _, group := range open {
+			share := capacity * group.Weight / weights
+			rest := float64(headroom[group.Id]) - result[group.Id]
+			if share >= rest {
+				share = rest
+			} else {
+				next = append(next, group)
+			}
+			result[group.Id] += share
+			spent += share
+		}
+
+		capacity -= spent
+		if len(next) == len(open) {
+			break
+		}
+		open = next
+	}
+
+	return result
+}
+
+func minInt(values ...int) int {
+
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,117 @@
+package robot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFairSchedulerAllocate(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		capacity int
+		groups   []GroupShare
+		want     map[int32]int
+	}{
+		{
+			name:     "by weight",
+			capacity: 8,
+			groups: []GroupShare{
+				{Id: 1, Weight: 3, Demand: 100},
+				{Id: 2, Weight: 1, Demand: 100},
+			},
+			want: map[int32]int{1: 6, 2: 2},
+		},
+		{
+			name:     "demand goes to the others",
+			capacity: 10,
+			groups: []GroupShare{
+				{Id: 1, Weight: 1, Demand: 1},
+				{Id: 2, Weight: 1, Demand: 100},
+			},
+			want: map[int32]int{1: 1, 2: 9},
+		},
+		{
+			name:     "limit",
+			capacity: 10,
+			groups: []GroupShare{
+				{Id: 1, Weight: 5, Demand: 100, Limit: 2},
+				{Id: 2, Weight: 1, Demand: 100},
+			},
+			want: map[int32]int{1: 2, 2: 8},
+		},
+		{
+			name:     "minimum share",
+			capacity: 4,
+			groups: []GroupShare{
+				{Id: 1, Weight: 100, Demand: 100},
+				{Id: 2, Weight: 1, Min: 2, Demand: 100},
+			},
+			want: map[int32]int{1: 2, 2: 2},
+		},
+		{
+			name:     "minimums over capacity by weight",
+			capacity: 3,
+			groups: []GroupShare{
+				{Id: 1, Weight: 1, Min: 2, Demand: 100},
+				{Id: 2, Weight: 3, Min: 2, Demand: 100},
+			},
+			want: map[int32]int{1: 1, 2: 2},
+		},
+		{
+			name:     "no demand",
+			capacity: 4,
+			groups: []GroupShare{
+				{Id: 1, Weight: 1, Min: 2, Demand: 0},
+				{Id: 2, Weight: 1, Demand: 3},
+			},
+			want: map[int32]int{2: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewFairScheduler().Allocate(tt.capacity, tt.groups)
+			for id, threads := range got {
+				if threads == 0 {
+					delete(got, id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// with one thread for three groups of weights 2:1:1 the credit gives every group its share over cycles
+func TestFairSchedulerCredit(t *testing.T) {
+
+	scheduler := NewFairScheduler()
+	groups := []GroupShare{
+		{Id: 1, Weight: 2, Demand: 10},
+		{Id: 2, Weight: 1, Demand: 10},
+		{Id: 3, Weight: 1, Demand: 10},
+	}
+
+	total := make(map[int32]int)
+	for i := 0; i < 40; i++ {
+		allocation := scheduler.Allocate(1, groups)
+
+		sum := 0
+		for id, threads := range allocation {
+			total[id] += threads
+			sum += threads
+		}
+		if sum != 1 {
+			t.Fatalf("cycle %d: allocated %d threads of 1", i, sum)
+		}
+	}
+
+	want := map[int32]int{1: 20, 2: 10, 3: 10}
+	for id, threads := range want {
+		if total[id] < threads-1 || total[id] > threads+1 {
+			t.Errorf("group %d: %d threads over 40 cycles, want about %d", id, total[id], threads)
+		}
+	}
+}
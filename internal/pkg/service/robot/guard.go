@@ -0,0 +1,166 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"oms2/internal/pkg/config"
+	"oms2/internal/pkg/metrics"
+	"oms2/internal/pkg/ratelimit"
+)
+
+const (
+	DeferRateLimit   = "rate_limit"
+	DeferBreakerOpen = "breaker_open"
+)
+
+var ErrDeferred = errors.New("action deferred")
+
+// DeferredError puts the lot off until Until without a run of the action,
+// it is not a failure of the action and does not count as an attempt
+type DeferredError struct {
+	Action string
+	Reason string
+	Until  time.Time
+}
+
+func (e *DeferredError) Error() string {
+	return fmt.Sprintf("%s: %s until %s, %s", ErrDeferred, e.Action, e.Until.Format(time.RFC3339), e.Reason)
+}
+
+func (e *DeferredError) Unwrap() error {
+	return ErrDeferred
+}
+
+// guard is the token bucket and the circuit breaker of an action, nil if not configured
+type guard struct {
+	limit   config.ActionLimit
+	bucket  *ratelimit.Bucket
+	breaker *ratelimit.Breaker
+}
+
+// Guards limit the calls of the actions configured in OMS2_ACTIONS_LIMITS on the instance
+type Guards struct {
+	guards map[string]*guard
+}
+
+// GuardState is the limit and the breaker of an action for the admin output
+type GuardState struct {
+	Action           string                  `json:"action"`
+	Rate             float64                 `json:"rate"`
+	Burst            int                     `json:"burst"`
+	Tokens           *float64                `json:"tokens,omitempty"`
+	FailureThreshold int                     `json:"failure_threshold"`
+	OpenTimeout      string                  `json:"open_timeout"`
+	Breaker          *ratelimit.BreakerState `json:"breaker,omitempty"`
+}
+
+func NewGuards(limits config.ActionLimits) *Guards {
+
+	guards := make(map[string]*guard, len(limits))
+	for name, limit := range limits {
+		item := &guard{limit: limit}
+		if limit.Rate > 0 {
+			item.bucket = ratelimit.NewBucket(limit.Rate, limit.Burst)
+		}
+		if limit.FailureThreshold > 0 {
+			item.breaker = ratelimit.NewBreaker(limit.FailureThreshold, limit.OpenTimeout)
+			metrics.ActionBreakerState.WithLabelValues(name).Set(0)
+		}
+		guards[name] = item
+	}
+
+	return &Guards{guards: guards}
+}
+
+// Invoke calls the action if its breaker and rate limit let it, otherwise returns a DeferredError.
+// The result of the call is counted by the breaker, a call cancelled by ctx is not.
+func (g *Guards) Invoke(ctx context.Context, action string, call func() (ActionOutput, error)) (ActionOutput, error) {
+
+	item, ok := g.guards[action]
+	if !ok {
+		return call()
+	}
+
+	now := time.Now()
+
+	if item.breaker != nil {
+		if allowed, until := item.breaker.Allow(now); !allowed {
+			return ActionOutput{}, deferred(action, DeferBreakerOpen, until)
+		}
+	}
+
+	if item.bucket != nil {
+		if allowed, wait := item.bucket.Take(now); !allowed {
+			if item.breaker != nil {
+				item.breaker.Cancel()
+			}
+			return ActionOutput{}, deferred(action, DeferRateLimit, now.Add(wait))
+		}
+	}
+
+	out, err := call()
+
+	if item.breaker != nil {
+		if ctx.Err() != nil {
+			item.breaker.Cancel()
+		} else {
+			item.breaker.Record(time.Now(), err)
+		}
+		metrics.ActionBreakerState.WithLabelValues(action).Set(breakerGauge(item.breaker.State().State))
+	}
+
+	return out, err
+}
+
+// States returns the guards by action name
+func (g *Guards) States() []GuardState {
+
+	now := time.Now()
+
+	states := make([]GuardState, 0, len(g.guards))
+	for name, item := range g.guards {
+		state := GuardState{
+			Action:           name,
+			Rate:             item.limit.Rate,
+			Burst:            item.limit.Burst,
+			FailureThreshold: item.limit.FailureThreshold,
+			OpenTimeout:      item.limit.OpenTimeout.String(),
+		}
+		if item.bucket != nil {
+			tokens := item.bucket.Tokens(now)
+			state.Tokens = &tokens
+		}
+		if item.breaker != nil {
+			breaker := item.breaker.State()
+			state.Breaker = &breaker
+		}
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Action < states[j].Action
+	})
+
+	return states
+}
+
+func deferred(action string, reason string, until time.Time) error {
+	metrics.ActionDeferred.WithLabelValues(action, reason).Inc()
+	return &DeferredError{Action: action, Reason: reason, Until: until}
+}
+
+func breakerGauge(state string) float64 {
+
+	switch state {
+	case ratelimit.StateHalfOpen:
+		return 1
+	case ratelimit.StateOpen:
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,56 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oms2/internal/pkg/config"
+	"oms2/internal/pkg/ratelimit"
+)
+
+func TestGuardsInvoke(t *testing.T) {
+
+	guards := NewGuards(config.ActionLimits{
+		"limited": {Rate: 0.001, Burst: 1},
+		"fragile": {FailureThreshold: 1, OpenTimeout: time.Minute},
+	})
+
+	calls := 0
+	call := func(err error) func() (ActionOutput, error) {
+		return func() (ActionOutput, error) {
+			calls++
+			return ActionOutput{}, err
+		}
+	}
+
+	ctx := context.Background()
+
+	if _, err := guards.Invoke(ctx, "free", call(nil)); err != nil || calls != 1 {
+		t.Fatalf("action without limits: err = %v, calls = %d", err, calls)
+	}
+
+	if _, err := guards.Invoke(ctx, "limited", call(nil)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := guards.Invoke(ctx, "limited", call(nil))
+	var deferred *DeferredError
+	if !errors.As(err, &deferred) || deferred.Reason != DeferRateLimit || calls != 2 {
+		t.Fatalf("over the rate: err = %v, calls = %d", err, calls)
+	}
+
+	failure := errors.New("unavailable")
+	if _, err := guards.Invoke(ctx, "fragile", call(failure)); err != failure {
+		t.Fatalf("failing action: err = %v", err)
+	}
+	_, err = guards.Invoke(ctx, "fragile", call(nil))
+	if !errors.As(err, &deferred) || deferred.Reason != DeferBreakerOpen || !errors.Is(err, ErrDeferred) || calls != 3 {
+		t.Fatalf("open breaker: err = %v, calls = %d", err, calls)
+	}
+
+	states := guards.States()
+	if len(states) != 2 || states[0].Action != "fragile" || states[0].Breaker.State != ratelimit.StateOpen {
+		t.Errorf("states = %+v", states)
+	}
+}
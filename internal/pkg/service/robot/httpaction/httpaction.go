@@ -0,0 +1,342 @@
+// Package httpaction is the built-in http action: it calls a service with the lot data
+// and stores the response in the lot variables, configured per node in the node params.
+package httpaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/repository/action"
+	"oms2/internal/pkg/service/robot"
+	"oms2/internal/pkg/util"
+	"oms2/internal/pkg/variables"
+)
+
+// Name of the action in _Ref_M.action
+const Name = "http"
+
+// IdempotencyHeader carries the idempotency key of the action run, the headers of the node may override it
+const IdempotencyHeader = "Idempotency-Key"
+
+const (
+	defaultTimeout = 10 * time.Second
+	maxBodySize    = 1 << 20
+)
+
+// ParamsSchema of the node params, the fields of Config
+var ParamsSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["url"],
+	"properties": {
+		"url": {"type": "string", "minLength": 1},
+		"method": {"type": "string"},
+		"headers": {"type": "object", "additionalProperties": {"type": "string"}},
+		"body": {"type": "string"},
+		"timeout_ms": {"type": "integer", "minimum": 1},
+		"expected_statuses": {"type": "array", "items": {"type": "integer"}},
+		"response_mapping": {"type": "object", "additionalProperties": {"type": "string"}}
+	}
+}`)
+
+var (
+	ErrNodeNotFound     = errors.New("node not found")
+	ErrUnexpectedStatus = errors.New("unexpected http status")
+	ErrInvalidResponse  = errors.New("http response is not json")
+)
+
+// Config of the http action of a node, read from the node params.
+// Url, Headers and Body are text/template templates executed with robot.ActionInput,
+// "json" marshals a value, e.g. {"order": {{.OrderId}}, "items": {{json .Variables.items}}}.
+// Without an event of the lot .Event is empty, so {{.Event.Type}} is an empty string.
+// Mapping stores values of the json response by dot separated path into variables,
+// the path "." stores the whole response, variables of missing paths are left as they are.
+// Without ExpectedStatuses any 2xx status is accepted.
+type Config struct {
+	Url              string
+	Method           string
+	Headers          map[string]string
+	Body             string
+	Timeout          time.Duration
+	ExpectedStatuses []int
+	Mapping          map[string]string
+}
+
+type Handler struct {
+	zl         *zap.Logger
+	repository *action.Repository
+	client     *http.Client
+}
+
+func NewHandler(r *action.Repository, zl *zap.Logger) *Handler {
+	return &Handler{
+		zl:         zl,
+		repository: r,
+		client:     &http.Client{},
+	}
+}
+
+func (h *Handler) ParamsSchema() json.RawMessage {
+	return ParamsSchema
+}
+
+func (h *Handler) Handle(ctx context.Context, in robot.ActionInput) (robot.ActionOutput, error) {
+
+	cfg, err := NewConfig(in.Params)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+
+	return h.Call(ctx, cfg, in)
+}
+
+// ValidateNode checks that the templates of the node parse
+func (h *Handler) ValidateNode(ctx context.Context, nodeId int64) error {
+
+	rows, err := h.repository.NodeParams(ctx, nodeId)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		return fmt.Errorf("%w: %d", ErrNodeNotFound, nodeId)
+	}
+
+	params, err := util.ToMap(rows[0]["params"])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := NewConfig(params)
+	if err != nil {
+		return err
+	}
+
+	_, err = parse(cfg)
+
+	return err
+}
+
+// params are the node params of the http action
+type params struct {
+	Url              string            `json:"url"`
+	Method           string            `json:"method"`
+	Headers          map[string]string `json:"headers"`
+	Body             string            `json:"body"`
+	TimeoutMs        int64             `json:"timeout_ms"`
+	ExpectedStatuses []int             `json:"expected_statuses"`
+	Mapping          map[string]string `json:"response_mapping"`
+}
+
+// NewConfig reads the settings from the node params
+func NewConfig(values map[string]interface{}) (Config, error) {
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var p params
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Config{}, fmt.Errorf("http action params: %w", err)
+	}
+
+	return Config{
+		Url:              p.Url,
+		Method:           p.Method,
+		Headers:          p.Headers,
+		Body:             p.Body,
+		Timeout:          time.Duration(p.TimeoutMs) * time.Millisecond,
+		ExpectedStatuses: p.ExpectedStatuses,
+		Mapping:          p.Mapping,
+	}, nil
+}
+
+// Call sends the request built from the templates and maps the response to variable updates
+func (h *Handler) Call(ctx context.Context, cfg Config, in robot.ActionInput) (robot.ActionOutput, error) {
+
+	templates, err := parse(cfg)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+
+	if in.Event == nil {
+		in.Event = &robot.ActionEvent{}
+	}
+
+	url, err := execute(templates.url, in)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+
+	var body io.Reader
+	if templates.body != nil {
+		data, err := execute(templates.body, in)
+		if err != nil {
+			return robot.ActionOutput{}, err
+		}
+		body = strings.NewReader(data)
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	method := strings.ToUpper(cfg.Method)
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if len(in.IdempotencyKey) > 0 {
+		request.Header.Set(IdempotencyHeader, in.IdempotencyKey)
+	}
+
+	for name, header := range templates.headers {
+		value, err := execute(header, in)
+		if err != nil {
+			return robot.ActionOutput{}, err
+		}
+		request.Header.Set(name, value)
+	}
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+
+	if !expected(cfg.ExpectedStatuses, response.StatusCode) {
+		return robot.ActionOutput{}, fmt.Errorf("%w: %s %s returned %d", ErrUnexpectedStatus, method, url, response.StatusCode)
+	}
+
+	updates, err := mapResponse(cfg.Mapping, data)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+
+	return robot.ActionOutput{Outcome: robot.OutcomeNext, Variables: updates}, nil
+}
+
+type templates struct {
+	url     *template.Template
+	body    *template.Template
+	headers map[string]*template.Template
+}
+
+var funcs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+func parse(cfg Config) (result templates, err error) {
+
+	if len(cfg.Url) == 0 {
+		return result, errors.New("http action url is empty")
+	}
+
+	result.url, err = template.New("url").Funcs(funcs).Parse(cfg.Url)
+	if err != nil {
+		return result, err
+	}
+
+	if len(cfg.Body) > 0 {
+		result.body, err = template.New("body").Funcs(funcs).Parse(cfg.Body)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result.headers = make(map[string]*template.Template, len(cfg.Headers))
+	for name, value := range cfg.Headers {
+		result.headers[name], err = template.New(name).Funcs(funcs).Parse(value)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func execute(t *template.Template, in robot.ActionInput) (string, error) {
+
+	var buffer bytes.Buffer
+	if err := t.Option("missingkey=zero").Execute(&buffer, in); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+func expected(statuses []int, status int) bool {
+
+	if len(statuses) == 0 {
+		return status >= 200 && status < 300
+	}
+
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mapResponse(mapping map[string]string, data []byte) (map[string]interface{}, error) {
+
+	if len(mapping) == 0 {
+		return nil, nil
+	}
+
+	var response interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidResponse, err)
+		}
+	}
+
+	updates := make(map[string]interface{})
+	for path, name := range mapping {
+		if path == "." {
+			if response != nil {
+				updates[name] = response
+			}
+			continue
+		}
+
+		object, _ := response.(map[string]interface{})
+		if value, ok := variables.Lookup(object, path); ok {
+			updates[name] = value
+		}
+	}
+
+	return updates, nil
+}
@@ -0,0 +1,172 @@
+package httpaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"oms2/internal/pkg/jsonschema"
+	"oms2/internal/pkg/service/robot"
+)
+
+// request is what the test server received, checked by the test goroutine
+type request struct {
+	method      string
+	path        string
+	lot         string
+	idempotency string
+	body        []byte
+}
+
+func recordServer(status int, response string) (*httptest.Server, <-chan request) {
+
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{
+			method:      r.Method,
+			path:        r.URL.Path,
+			lot:         r.Header.Get("X-Lot"),
+			idempotency: r.Header.Get(IdempotencyHeader),
+			body:        body,
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+
+	return server, requests
+}
+
+func TestCall(t *testing.T) {
+
+	server, requests := recordServer(http.StatusCreated, `{"reservation": {"id": "r-1", "until": "2026-10-20"}, "ok": true}`)
+	defer server.Close()
+
+	cfg := Config{
+		Url:              server.URL + "/orders/{{.OrderId}}/reserve",
+		Method:           "put",
+		Headers:          map[string]string{"X-Lot": "lot-{{.LotId}}"},
+		Body:             `{"lot": {{.LotId}}, "items": {{json .Variables.items}}, "event": "{{.Event.Type}}"}`,
+		ExpectedStatuses: []int{http.StatusCreated},
+		Mapping:          map[string]string{"reservation.id": "reservation_id", "missing.path": "missing", ".": "response"},
+	}
+
+	in := robot.ActionInput{
+		LotId:          7,
+		OrderId:        3,
+		Variables:      map[string]interface{}{"items": []interface{}{"a", "b"}},
+		Event:          &robot.ActionEvent{Id: 1, Type: "paid"},
+		IdempotencyKey: robot.IdempotencyKey(7, 2, 0),
+	}
+
+	out, err := NewHandler(nil, zap.NewNop()).Call(context.Background(), cfg, in)
+	require.NoError(t, err)
+
+	r := <-requests
+	require.Equal(t, http.MethodPut, r.method)
+	require.Equal(t, "/orders/3/reserve", r.path)
+	require.Equal(t, "lot-7", r.lot)
+	require.Equal(t, "7:2:0", r.idempotency)
+
+	var received map[string]interface{}
+	require.NoError(t, json.Unmarshal(r.body, &received))
+	require.Equal(t, 7.0, received["lot"])
+	require.Equal(t, []interface{}{"a", "b"}, received["items"])
+	require.Equal(t, "paid", received["event"])
+
+	require.Equal(t, robot.OutcomeNext, out.Outcome)
+	require.Equal(t, "r-1", out.Variables["reservation_id"])
+	require.NotContains(t, out.Variables, "missing")
+	require.Equal(t, true, out.Variables["response"].(map[string]interface{})["ok"])
+}
+
+func TestCallWithoutEvent(t *testing.T) {
+
+	server, requests := recordServer(http.StatusOK, `{}`)
+	defer server.Close()
+
+	cfg := Config{
+		Url:  server.URL,
+		Body: `{"event": "{{.Event.Type}}", "event_id": {{.Event.Id}}}`,
+	}
+
+	_, err := NewHandler(nil, zap.NewNop()).Call(context.Background(), cfg, robot.ActionInput{LotId: 7})
+	require.NoError(t, err)
+
+	r := <-requests
+	var received map[string]interface{}
+	require.NoError(t, json.Unmarshal(r.body, &received))
+	require.Equal(t, "", received["event"])
+	require.Equal(t, 0.0, received["event_id"])
+}
+
+func TestCallUnexpectedStatus(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	handler := NewHandler(nil, zap.NewNop())
+
+	_, err := handler.Call(context.Background(), Config{Url: server.URL}, robot.ActionInput{})
+	require.True(t, errors.Is(err, ErrUnexpectedStatus))
+
+	_, err = handler.Call(context.Background(), Config{Url: server.URL, ExpectedStatuses: []int{http.StatusConflict}}, robot.ActionInput{})
+	require.NoError(t, err)
+}
+
+func TestCallTimeout(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	_, err := NewHandler(nil, zap.NewNop()).Call(context.Background(), Config{Url: server.URL, Timeout: 20 * time.Millisecond}, robot.ActionInput{})
+	require.Error(t, err)
+}
+
+func TestNewConfig(t *testing.T) {
+
+	params := map[string]interface{}{
+		"url":               "http://stock/{{.LotId}}",
+		"method":            "GET",
+		"headers":           map[string]interface{}{"Authorization": "Bearer token"},
+		"timeout_ms":        1500.0,
+		"expected_statuses": []interface{}{200.0, 404.0},
+		"response_mapping":  map[string]interface{}{"qty": "stock_qty"},
+	}
+
+	document, err := json.Marshal(params)
+	require.NoError(t, err)
+	require.NoError(t, jsonschema.Validate(ParamsSchema, document))
+
+	cfg, err := NewConfig(params)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Url:              "http://stock/{{.LotId}}",
+		Method:           "GET",
+		Headers:          map[string]string{"Authorization": "Bearer token"},
+		Timeout:          1500 * time.Millisecond,
+		ExpectedStatuses: []int{200, 404},
+		Mapping:          map[string]string{"qty": "stock_qty"},
+	}, cfg)
+
+	require.Error(t, jsonschema.Validate(ParamsSchema, []byte(`{"method": "GET"}`)))
+
+	_, err = NewConfig(map[string]interface{}{"url": "http://stock", "headers": []interface{}{"x"}})
+	require.Error(t, err)
+
+	_, err = parse(Config{Url: "http://stock/{{.LotId"})
+	require.Error(t, err)
+}
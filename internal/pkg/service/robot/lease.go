@@ -0,0 +1,68 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLeaseLost stops a thread whose order leases were reclaimed by the reaper, the orders belong to another thread now
+var ErrLeaseLost = errors.New("order lease of the thread is lost")
+
+type leaseKey struct{}
+
+// withLease marks the steps of the context as run by the thread holding the leases
+func withLease(ctx context.Context, threadKey string) context.Context {
+	return context.WithValue(ctx, leaseKey{}, threadKey)
+}
+
+// leaseFrom returns the thread key of the context, steps outside of a thread hold no lease
+func leaseFrom(ctx context.Context) (string, bool) {
+	threadKey, ok := ctx.Value(leaseKey{}).(string)
+	return threadKey, ok
+}
+
+// keepLease renews the leases with renew every interval until stop is called. A renewal finding
+// no leases means they were reclaimed: the returned context is cancelled and stop returns ErrLeaseLost.
+// A failed renewal is passed to failed and retried on the next tick.
+func keepLease(ctx context.Context, interval time.Duration, renew func(ctx context.Context) (int, error), failed func(err error)) (context.Context, func() error) {
+
+	work, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	var lost error
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-work.Done():
+				return
+			case <-ticker.C:
+				renewed, err := renew(work)
+				if err != nil {
+					if work.Err() == nil {
+						failed(err)
+					}
+					continue
+				}
+				if renewed == 0 {
+					lost = ErrLeaseLost
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return work, func() error {
+		cancel()
+		<-done
+
+		return lost
+	}
+}
@@ -0,0 +1,84 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepLeaseLost(t *testing.T) {
+
+	var calls int64
+	renew := func(ctx context.Context) (int, error) {
+		if atomic.AddInt64(&calls, 1) < 3 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	work, stop := keepLease(context.Background(), time.Millisecond, renew, func(err error) {
+		t.Errorf("unexpected failure %v", err)
+	})
+
+	select {
+	case <-work.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after the lease was lost")
+	}
+
+	if err := stop(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected %v, got %v", ErrLeaseLost, err)
+	}
+}
+
+func TestKeepLeaseFailed(t *testing.T) {
+
+	failure := errors.New("connection refused")
+	failures := make(chan error, 1)
+
+	renew := func(ctx context.Context) (int, error) {
+		return 0, failure
+	}
+
+	work, stop := keepLease(context.Background(), time.Millisecond, renew, func(err error) {
+		select {
+		case failures <- err:
+		default:
+		}
+	})
+
+	select {
+	case err := <-failures:
+		if !errors.Is(err, failure) {
+			t.Fatalf("expected %v, got %v", failure, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed renewal not reported")
+	}
+
+	// a failed renewal keeps the thread running, the lease may still be live
+	if work.Err() != nil {
+		t.Fatal("context cancelled on a failed renewal")
+	}
+
+	if err := stop(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if work.Err() == nil {
+		t.Fatal("context not cancelled by stop")
+	}
+}
+
+func TestLeaseFrom(t *testing.T) {
+
+	if _, ok := leaseFrom(context.Background()); ok {
+		t.Fatal("lease outside of a thread")
+	}
+
+	threadKey, ok := leaseFrom(withLease(context.Background(), "thread-1"))
+	if !ok || threadKey != "thread-1" {
+		t.Fatalf("expected thread-1, got %q", threadKey)
+	}
+}
@@ -0,0 +1,128 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v7 "oms2/internal/pkg/storage/elastic/v7"
+	"oms2/internal/pkg/util"
+)
+
+// SettingModel is the robot setting of the scheduling model switched at runtime
+const SettingModel = "model"
+
+const (
+	ModelSourceConfig = "config"
+	ModelSourceAdmin  = "admin"
+)
+
+var ErrUnknownModel = errors.New("unknown robot model")
+
+// Models are the scheduling models of the robot
+var Models = []string{IterationModel, TilingModel, MultiTilingModel}
+
+// ModelState is the scheduling model of the robot and where it comes from
+type ModelState struct {
+	Model       string      `json:"model"`
+	Source      string      `json:"source"`
+	Configured  string      `json:"configured"`
+	UpdatedTime interface{} `json:"updated_time,omitempty"`
+	Models      []string    `json:"models"`
+}
+
+func ValidModel(model string) error {
+
+	for _, item := range Models {
+		if item == model {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q, expected one of %v", ErrUnknownModel, model, Models)
+}
+
+// Model returns the scheduling model of the next cycle
+func (s *Service) Model() string {
+
+	s.modelMu.RLock()
+	defer s.modelMu.RUnlock()
+
+	return s.model
+}
+
+func (s *Service) setModel(ctx context.Context, model string) {
+
+	s.modelMu.Lock()
+	previous := s.model
+	s.model = model
+	s.modelMu.Unlock()
+
+	if previous != model {
+		message := fmt.Sprintf("Robot model: %s -> %s", previous, model)
+		s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
+	}
+}
+
+// ModelState returns the model set at runtime for all instances or the configured one
+func (s *Service) ModelState(ctx context.Context) (ModelState, error) {
+
+	state := ModelState{
+		Model:      s.cfg.RobotModel,
+		Source:     ModelSourceConfig,
+		Configured: s.cfg.RobotModel,
+		Models:     Models,
+	}
+
+	settings, err := s.robotRepository.Setting(ctx, SettingModel)
+	if err != nil {
+		return state, err
+	}
+
+	if len(settings) > 0 {
+		model, _ := settings[0]["value"].(string)
+		if err := ValidModel(model); err != nil {
+			return state, err
+		}
+
+		state.Model = model
+		state.Source = ModelSourceAdmin
+		state.UpdatedTime = settings[0]["updated_time"]
+	}
+
+	return state, nil
+}
+
+// RefreshModel applies the model set at runtime before the cycle, so every instance follows a switch
+func (s *Service) RefreshModel(ctx context.Context) error {
+
+	state, err := s.ModelState(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.setModel(ctx, state.Model)
+
+	return nil
+}
+
+// SetModel switches all instances to the model from their next cycle,
+// an empty model returns them to OMS2_ROBOT_MODEL
+func (s *Service) SetModel(ctx context.Context, model string) (ModelState, error) {
+
+	var err error
+	if len(model) == 0 {
+		err = s.robotRepository.DeleteSetting(ctx, SettingModel)
+	} else if err = ValidModel(model); err == nil {
+		err = s.robotRepository.SaveSetting(ctx, SettingModel, model)
+	}
+	if err != nil {
+		return ModelState{}, err
+	}
+
+	if err := s.RefreshModel(ctx); err != nil {
+		return ModelState{}, err
+	}
+
+	return s.ModelState(ctx)
+}
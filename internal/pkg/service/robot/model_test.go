@@ -0,0 +1,21 @@
+package robot
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidModel(t *testing.T) {
+
+	for _, model := range Models {
+		if err := ValidModel(model); err != nil {
+			t.Errorf("ValidModel(%q) = %v", model, err)
+		}
+	}
+
+	for _, model := range []string{"", "tiling", "Unknown"} {
+		if err := ValidModel(model); !errors.Is(err, ErrUnknownModel) {
+			t.Errorf("ValidModel(%q) = %v, want %v", model, err, ErrUnknownModel)
+		}
+	}
+}
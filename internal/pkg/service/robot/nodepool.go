@@ -0,0 +1,193 @@
+package robot
+
+import (
+	"context"
+	"sort"
+
+	"oms2/internal/pkg/config"
+	"oms2/internal/pkg/util"
+)
+
+// DefaultPool is the pool of the nodes of the groups without a pool of their own
+const DefaultPool = "default"
+
+// PoolState is the load of a worker pool
+type PoolState struct {
+	Name   string  `json:"name"`
+	Groups []int32 `json:"groups"`
+	Size   int     `json:"size"`
+	Queue  int     `json:"queue"`
+	Limit  int     `json:"limit"`
+	Busy   int     `json:"busy"`
+	Queued int     `json:"queued"`
+}
+
+// pools are the default pool and the pools of the node groups (_Ref_M.group_id) set in NodePools,
+// so the slow nodes of a group do not hold the workers of the others
+type pools struct {
+	main    *pool
+	list    []*pool
+	byGroup map[int32]*pool
+	groups  map[*pool][]int32
+}
+
+func newPools(size int, nodePools config.NodePools) *pools {
+
+	main := newPool(DefaultPool, size, size)
+	p := &pools{
+		main:    main,
+		list:    []*pool{main},
+		byGroup: make(map[int32]*pool, len(nodePools)),
+		groups:  make(map[*pool][]int32, len(nodePools)),
+	}
+
+	groupIds := make([]int32, 0, len(nodePools))
+	for groupId := range nodePools {
+		groupIds = append(groupIds, groupId)
+	}
+	sort.Slice(groupIds, func(i, j int) bool { return groupIds[i] < groupIds[j] })
+
+	for _, groupId := range groupIds {
+		conf := nodePools[groupId]
+		item := newPool("group-"+groupLabel(groupId), conf.Size, conf.Queue)
+		p.list = append(p.list, item)
+		p.byGroup[groupId] = item
+		p.groups[item] = []int32{groupId}
+	}
+
+	return p
+}
+
+// dedicated tells whether the nodes of any group have a pool of their own
+func (p *pools) dedicated() bool {
+	return len(p.byGroup) > 0
+}
+
+func (p *pools) start(process func(item shard)) {
+	for _, item := range p.list {
+		item.start(process)
+	}
+}
+
+// drain drains the pools at once until ctx is done and returns the first error
+func (p *pools) drain(ctx context.Context) error {
+
+	errs := make(chan error, len(p.list))
+	for _, item := range p.list {
+		go func(item *pool) {
+			errs <- item.drain(ctx)
+		}(item)
+	}
+
+	var result error
+	for range p.list {
+		if err := <-errs; err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// free is the number of shards the pools take at once
+func (p *pools) free() int {
+
+	free := 0
+	for _, item := range p.list {
+		free += item.free()
+	}
+
+	return free
+}
+
+// capacity is the number of shards in flight the pools keep to
+func (p *pools) capacity() int {
+
+	capacity := 0
+	for _, item := range p.list {
+		capacity += item.capacity()
+	}
+
+	return capacity
+}
+
+// quota is the number of threads a processing group may still start in a dispatch. It is shared
+// by all the pools, so the group keeps to its allocation however its nodes are spread over the pools.
+type quota struct {
+	left int
+}
+
+// take returns the number of threads the group may start in the pool, no more than its free workers
+func (q *quota) take(p *pool) int {
+
+	take := p.free()
+	if take > q.left {
+		take = q.left
+	}
+	if take < 0 {
+		take = 0
+	}
+
+	return take
+}
+
+// used counts the threads started by the group
+func (q *quota) used(threads int) {
+	q.left -= threads
+}
+
+// route assigns the lots to the pools by the node groups of the lots, nodeGroups is the group by lot_id.
+// All lots of an order go to one pool as the order is leased to one thread: to the pool of the group
+// of the first of its lots at a node of a group with a pool, to the default pool otherwise.
+func (p *pools) route(lots []map[string]interface{}, nodeGroups map[int64]int32) map[*pool][]map[string]interface{} {
+
+	target := make(map[interface{}]*pool)
+	for _, lot := range lots {
+		if _, ok := target[lot["order_id"]]; !ok {
+			target[lot["order_id"]] = p.main
+		}
+		if target[lot["order_id"]] != p.main {
+			continue
+		}
+
+		groupId, ok := nodeGroups[util.ToInt64(lot["lot_id"])]
+		if !ok {
+			continue
+		}
+		if item, ok := p.byGroup[groupId]; ok {
+			target[lot["order_id"]] = item
+		}
+	}
+
+	routed := make(map[*pool][]map[string]interface{}, len(p.list))
+	for _, lot := range lots {
+		item := target[lot["order_id"]]
+		routed[item] = append(routed[item], lot)
+	}
+
+	return routed
+}
+
+func (p *pools) states() []PoolState {
+
+	states := make([]PoolState, 0, len(p.list))
+	for _, item := range p.list {
+		groups := p.groups[item]
+		if groups == nil {
+			groups = make([]int32, 0)
+		}
+
+		queued := len(item.queue)
+		states = append(states, PoolState{
+			Name:   item.name,
+			Groups: groups,
+			Size:   item.size,
+			Queue:  cap(item.queue),
+			Limit:  item.capacity(),
+			Busy:   item.inFlight() - queued,
+			Queued: queued,
+		})
+	}
+
+	return states
+}
@@ -0,0 +1,85 @@
+package robot
+
+import (
+	"testing"
+
+	"oms2/internal/pkg/config"
+)
+
+func TestPoolsRoute(t *testing.T) {
+
+	p := newPools(4, config.NodePools{500: {Size: 2, Queue: 2}})
+	slow := p.byGroup[500]
+
+	lots := []map[string]interface{}{
+		{"lot_id": int32(1), "order_id": int32(10)},
+		{"lot_id": int32(2), "order_id": int32(10)},
+		{"lot_id": int32(3), "order_id": int32(20)},
+		{"lot_id": int32(4), "order_id": int32(30)},
+		{"lot_id": int32(5), "order_id": int32(30)},
+	}
+	nodeGroups := map[int64]int32{1: 0, 2: 500, 3: 0, 4: 0, 5: 0}
+
+	routed := p.route(lots, nodeGroups)
+
+	orders := func(items []map[string]interface{}) map[int32]int {
+		result := make(map[int32]int)
+		for _, lot := range items {
+			result[lot["order_id"].(int32)] += 1
+		}
+		return result
+	}
+
+	if got := orders(routed[slow]); len(got) != 1 || got[10] != 2 {
+		t.Errorf("group pool orders = %v, want the two lots of order 10", got)
+	}
+	if got := orders(routed[p.main]); len(got) != 2 || got[20] != 1 || got[30] != 2 {
+		t.Errorf("default pool orders = %v, want orders 20 and 30", got)
+	}
+}
+
+func TestPoolsCapacity(t *testing.T) {
+
+	p := newPools(4, config.NodePools{500: {Size: 2, Queue: 6}, 100: {Size: 1}})
+
+	if got := p.capacity(); got != 7 {
+		t.Errorf("capacity = %d, want 7", got)
+	}
+	if got := p.free(); got != 7 {
+		t.Errorf("free = %d, want 7", got)
+	}
+
+	states := p.states()
+	if len(states) != 3 || states[0].Name != DefaultPool || states[1].Name != "group-100" || states[2].Name != "group-500" {
+		t.Fatalf("pools = %+v, want default, group-100 and group-500", states)
+	}
+	if states[2].Size != 2 || states[2].Queue != 6 {
+		t.Errorf("group-500 = %+v, want size 2 and queue 6", states[2])
+	}
+	if !p.dedicated() {
+		t.Error("pools of node groups are not dedicated")
+	}
+}
+
+func TestQuotaAcrossPools(t *testing.T) {
+
+	p := newPools(2, config.NodePools{500: {Size: 2}})
+	slow := p.byGroup[500]
+
+	// the group is allocated 3 threads, its lots are at nodes of both pools with 2 free workers each
+	share := &quota{left: 3}
+
+	if got := share.take(p.main); got != 2 {
+		t.Fatalf("default pool take = %d, want 2", got)
+	}
+	share.used(2)
+
+	if got := share.take(slow); got != 1 {
+		t.Fatalf("group pool take = %d, want the 1 thread left of the allocation", got)
+	}
+	share.used(1)
+
+	if got := share.take(slow); got != 0 {
+		t.Fatalf("take = %d after the allocation is used up, want 0", got)
+	}
+}
@@ -0,0 +1,151 @@
+package robot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"oms2/internal/pkg/util"
+)
+
+// Statuses of the wait of an asynchronous action in _InfoReg_AW
+const (
+	WaitPending   = "pending"
+	WaitCompleted = "completed"
+	WaitFailed    = "failed"
+	WaitTimedOut  = "timed_out"
+)
+
+// Payload fields of an event completing the wait of an asynchronous action,
+// the status is completed if not set, the variables come with the event by its merge rule
+const (
+	EventActionToken  = "action_token"
+	EventActionStatus = "action_status"
+	EventActionError  = "action_error"
+)
+
+var ErrPendingTimeout = errors.New("asynchronous action timed out")
+
+// ActionToken returns the token for the wait of an asynchronous action, an HMAC of the idempotency key
+// with the secret of the service: the same for every run with the key and unknown without the secret
+func ActionToken(secret string, idempotencyKey string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(idempotencyKey))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IdempotencyKey of an action run: the lot, the node and the number of committed action runs of the lot
+func IdempotencyKey(lotId int64, nodeId int64, attempt int64) string {
+	return fmt.Sprintf("%d:%d:%d", lotId, nodeId, attempt)
+}
+
+// StartPending registers the wait of the action, the lot stays on the node
+func (s *Service) StartPending(ctx context.Context, data map[string]interface{}, in ActionInput, out ActionOutput) error {
+
+	token := out.Token
+	if len(token) == 0 {
+		token = in.Token
+	}
+
+	timeout := out.Timeout
+	if timeout <= 0 && data["pending_timeout_seconds"] != nil {
+		timeout = time.Duration(util.ToInt64(data["pending_timeout_seconds"])) * time.Second
+	}
+	if timeout <= 0 {
+		timeout = s.cfg.PendingTimeout
+	}
+
+	now := time.Now()
+
+	values := make(map[string]interface{})
+	values["lot_id"] = in.LotId
+	values["node_id"] = in.NodeId
+	values["token"] = token
+	values["status"] = WaitPending
+	values["created_time"] = now
+	values["deadline"] = now.Add(timeout)
+
+	if _, err := s.robotRepository.CreateActionWait(ctx, values); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Pending action: lot - %d, node - %s, token - %s", in.LotId, in.NodeName, token)
+	s.zl.Sugar().Info(message)
+
+	return nil
+}
+
+// DoPending checks the wait of the asynchronous action of the lot.
+// A wait completed by the callback or by an event carrying its token moves the lot to the next node,
+// a failed or timed out one moves it to the failure node, without it the action starts again.
+// A pending wait within its deadline keeps the lot on the node.
+func (s *Service) DoPending(ctx context.Context, data map[string]interface{}, wait map[string]interface{}) error {
+
+	status, errorText, resolved, err := WaitStatus(wait, time.Now())
+	if err != nil || !resolved {
+		return err
+	}
+
+	result, err := util.ToMap(wait["result"])
+	if err != nil {
+		return err
+	}
+
+	if err := s.SaveVariables(ctx, util.ToInt64(data["lot_id"]), result); err != nil {
+		return err
+	}
+
+	if _, err := s.robotRepository.ResolveActionWait(ctx, wait["id"], status, errorText, wait["token_event_id"]); err != nil {
+		return err
+	}
+
+	if status == WaitCompleted {
+		return s.StepToNextNode(ctx, data)
+	}
+
+	message := fmt.Sprintf("Asynchronous action %s: lot - %d, node - %s, token - %s, error - %v",
+		status, data["lot_id"], data["name"], wait["token"], errorText)
+	s.zl.Sugar().Error(message)
+
+	if data["fail_node_id"] == nil {
+		return nil
+	}
+
+	return s.RecordToNextStep(ctx, data, util.ToInt64(data["fail_node_id"]))
+}
+
+// WaitStatus returns the final status of the wait and its error at the given time,
+// resolved is false while the wait is pending within its deadline
+func WaitStatus(wait map[string]interface{}, now time.Time) (status string, errorText interface{}, resolved bool, err error) {
+
+	status = fmt.Sprint(wait["status"])
+	errorText = wait["error"]
+
+	if status != WaitPending {
+		return status, errorText, true, nil
+	}
+
+	if wait["token_event_id"] != nil {
+		payload, err := util.ToMap(wait["token_event_payload"])
+		if err != nil {
+			return "", nil, false, err
+		}
+
+		if payload[EventActionStatus] == WaitFailed {
+			return WaitFailed, payload[EventActionError], true, nil
+		}
+
+		return WaitCompleted, nil, true, nil
+	}
+
+	deadline, _ := wait["deadline"].(time.Time)
+	if now.After(deadline) {
+		return WaitTimedOut, ErrPendingTimeout.Error(), true, nil
+	}
+
+	return WaitPending, nil, false, nil
+}
@@ -0,0 +1,95 @@
+package robot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitStatus(t *testing.T) {
+
+	now := time.Now()
+	deadline := now.Add(time.Minute)
+
+	cases := []struct {
+		name      string
+		wait      map[string]interface{}
+		at        time.Time
+		status    string
+		errorText interface{}
+		resolved  bool
+	}{
+		{
+			name:   "pending within deadline",
+			wait:   map[string]interface{}{"status": WaitPending, "deadline": deadline},
+			at:     now,
+			status: WaitPending,
+		},
+		{
+			name:      "timed out",
+			wait:      map[string]interface{}{"status": WaitPending, "deadline": deadline},
+			at:        deadline.Add(time.Second),
+			status:    WaitTimedOut,
+			errorText: ErrPendingTimeout.Error(),
+			resolved:  true,
+		},
+		{
+			name:     "completed by callback",
+			wait:     map[string]interface{}{"status": WaitCompleted, "deadline": deadline},
+			at:       now,
+			status:   WaitCompleted,
+			resolved: true,
+		},
+		{
+			name:      "failed by callback after deadline",
+			wait:      map[string]interface{}{"status": WaitFailed, "error": "no label", "deadline": deadline},
+			at:        deadline.Add(time.Hour),
+			status:    WaitFailed,
+			errorText: "no label",
+			resolved:  true,
+		},
+		{
+			name: "completed by event",
+			wait: map[string]interface{}{"status": WaitPending, "deadline": deadline,
+				"token_event_id": int32(5), "token_event_payload": map[string]interface{}{EventActionToken: "t"}},
+			at:       deadline.Add(time.Second),
+			status:   WaitCompleted,
+			resolved: true,
+		},
+		{
+			name: "failed by event",
+			wait: map[string]interface{}{"status": WaitPending, "deadline": deadline,
+				"token_event_id": int32(5), "token_event_payload": map[string]interface{}{EventActionToken: "t",
+					EventActionStatus: WaitFailed, EventActionError: "carrier is down"}},
+			at:        now,
+			status:    WaitFailed,
+			errorText: "carrier is down",
+			resolved:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status, errorText, resolved, err := WaitStatus(c.wait, c.at)
+			require.NoError(t, err)
+			require.Equal(t, c.status, status)
+			require.Equal(t, c.errorText, errorText)
+			require.Equal(t, c.resolved, resolved)
+		})
+	}
+}
+
+func TestActionToken(t *testing.T) {
+
+	key := IdempotencyKey(1, 2, 0)
+
+	// a repeated run of the action gets the token of the first run
+	first := ActionToken("secret", key)
+	require.Equal(t, first, ActionToken("secret", key))
+	require.Len(t, first, 64)
+	require.NotEqual(t, key, first)
+
+	require.NotEqual(t, first, ActionToken("secret", IdempotencyKey(1, 2, 1)))
+	require.NotEqual(t, first, ActionToken("other", key))
+}
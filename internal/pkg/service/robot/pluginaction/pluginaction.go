@@ -0,0 +1,252 @@
+// Package pluginaction calls out-of-process action plugins over gRPC,
+// the plugins are registered as actions by name from OMS2_PLUGINS_ADDRESSES.
+package pluginaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/plugin"
+	"oms2/internal/pkg/service/robot"
+)
+
+var ErrUnavailable = errors.New("action plugin is not serving")
+
+// Plugin is the action handler of a plugin with the result of its last health check
+type Plugin struct {
+	name    string
+	address string
+	timeout time.Duration
+	client  *plugin.Client
+
+	mu     sync.RWMutex
+	status grpc_health_v1.HealthCheckResponse_ServingStatus
+}
+
+func NewPlugin(name string, address string, timeout time.Duration) (*Plugin, error) {
+
+	client, err := plugin.Dial(address)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+
+	return &Plugin{
+		name:    name,
+		address: address,
+		timeout: timeout,
+		client:  client,
+		status:  grpc_health_v1.HealthCheckResponse_UNKNOWN,
+	}, nil
+}
+
+// Handle sends the lot to the plugin, a plugin that failed its last health check is not called
+func (p *Plugin) Handle(ctx context.Context, in robot.ActionInput) (robot.ActionOutput, error) {
+
+	if status := p.Status(); status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return robot.ActionOutput{}, fmt.Errorf("%w: %s is %s", ErrUnavailable, p.name, status)
+	}
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	response, err := p.client.Execute(ctx, NewRequest(in))
+	if err != nil {
+		return robot.ActionOutput{}, fmt.Errorf("plugin %s: %w", p.name, err)
+	}
+
+	return robot.ActionOutput{
+		Outcome:   response.Outcome,
+		Variables: response.Variables,
+		Token:     response.Token,
+		Timeout:   time.Duration(response.TimeoutSeconds) * time.Second,
+	}, nil
+}
+
+// Check runs the health check and remembers the status
+func (p *Plugin) Check(ctx context.Context) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	status, err := p.client.Check(ctx)
+
+	p.mu.Lock()
+	p.status = status
+	p.mu.Unlock()
+
+	return status, err
+}
+
+func (p *Plugin) Status() grpc_health_v1.HealthCheckResponse_ServingStatus {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.status
+}
+
+func (p *Plugin) Close() error {
+	return p.client.Close()
+}
+
+// NewRequest builds the plugin request from the action input
+func NewRequest(in robot.ActionInput) plugin.Request {
+
+	request := plugin.Request{
+		Lot: plugin.Lot{LotId: in.LotId, OrderId: in.OrderId},
+		Node: plugin.Node{
+			Id:     in.NodeId,
+			Name:   in.NodeName,
+			Action: in.Action,
+			Params: in.Params,
+		},
+		Variables:      in.Variables,
+		IdempotencyKey: in.IdempotencyKey,
+		Token:          in.Token,
+	}
+
+	if in.Event != nil {
+		request.Event = &plugin.Event{Id: in.Event.Id, Type: in.Event.Type, Payload: in.Event.Payload}
+	}
+
+	return request
+}
+
+// Service holds the configured plugins and checks their health in the background
+type Service struct {
+	zl      *zap.Logger
+	cfg     *oms.Config
+	plugins map[string]*Plugin
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(cfg *oms.Config, zl *zap.Logger) (*Service, error) {
+
+	s := &Service{
+		zl:      zl,
+		cfg:     cfg,
+		plugins: make(map[string]*Plugin),
+	}
+
+	for name, address := range cfg.Plugins.Addresses {
+		p, err := NewPlugin(name, address, cfg.Plugins.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		s.plugins[name] = p
+	}
+
+	return s, nil
+}
+
+// Register registers the plugins as actions under their names
+func (s *Service) Register(registry *robot.Registry) error {
+
+	for _, name := range s.Names() {
+		if err := registry.Register(name, s.plugins[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Start checks the plugins before the robot starts and then every health interval,
+// a plugin that is down does not stop the service, its actions fail until it is serving
+func (s *Service) Start(_ context.Context) error {
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.CheckAll(s.ctx)
+
+	if len(s.plugins) == 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.Plugins.HealthInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.CheckAll(s.ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *Service) Stop(_ context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	for _, p := range s.plugins {
+		if err := p.Close(); err != nil {
+			s.zl.Sugar().Error(err)
+		}
+	}
+
+	return nil
+}
+
+// CheckAll runs the health checks of all plugins, changes are logged
+func (s *Service) CheckAll(ctx context.Context) {
+
+	for _, name := range s.Names() {
+		p := s.plugins[name]
+		previous := p.Status()
+
+		status, err := p.Check(ctx)
+		if err != nil {
+			s.zl.Sugar().Error(fmt.Errorf("plugin %s at %s: %w", name, p.address, err))
+		}
+
+		if status != previous {
+			s.zl.Sugar().Info(fmt.Sprintf("Plugin %s is %s", name, status))
+		}
+	}
+}
+
+// Status returns the last health status of every plugin as plugin.<name> for the health output
+func (s *Service) Status() map[string]string {
+
+	statuses := make(map[string]string, len(s.plugins))
+	for name, p := range s.plugins {
+		statuses["plugin."+name] = p.Status().String()
+	}
+
+	return statuses
+}
+
+// Names returns the plugin names in order
+func (s *Service) Names() []string {
+
+	names := make([]string, 0, len(s.plugins))
+	for name := range s.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
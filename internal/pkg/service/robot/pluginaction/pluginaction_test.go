@@ -0,0 +1,92 @@
+package pluginaction
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"oms2/internal/pkg/service/robot"
+)
+
+// startExample builds cmd/plugin-example and runs it as a subprocess on a free port
+func startExample(t *testing.T) (*exec.Cmd, string) {
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool is not available")
+	}
+
+	binary := filepath.Join(t.TempDir(), "plugin-example")
+	build := exec.Command(goTool, "build", "-o", binary, "oms2/cmd/plugin-example")
+	output, err := build.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	cmd := exec.Command(binary, "-addr", "127.0.0.1:0")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+
+	return cmd, strings.TrimSpace(strings.TrimPrefix(line, "listening on "))
+}
+
+func TestPlugin(t *testing.T) {
+
+	cmd, address := startExample(t)
+
+	p, err := NewPlugin("discount", address, 5*time.Second)
+	require.NoError(t, err)
+	defer p.Close()
+
+	in := robot.ActionInput{
+		LotId:     7,
+		NodeName:  "discount",
+		Action:    "discount",
+		Params:    map[string]interface{}{"percent": 15.0},
+		Variables: map[string]interface{}{"total": 200.0},
+	}
+
+	_, err = p.Handle(context.Background(), in)
+	require.True(t, errors.Is(err, ErrUnavailable))
+
+	status, err := p.Check(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, status)
+
+	out, err := p.Handle(context.Background(), in)
+	require.NoError(t, err)
+	require.Equal(t, robot.OutcomeNext, out.Outcome)
+	require.Equal(t, map[string]interface{}{"discount": 30.0, "total_with_discount": 170.0}, out.Variables)
+
+	in.Params["percent"] = 200.0
+	_, err = p.Handle(context.Background(), in)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "percent must be")
+
+	in.Variables = map[string]interface{}{}
+	out, err = p.Handle(context.Background(), in)
+	require.NoError(t, err)
+	require.Equal(t, robot.OutcomeStay, out.Outcome)
+
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+
+	_, err = p.Check(context.Background())
+	require.Error(t, err)
+	_, err = p.Handle(context.Background(), in)
+	require.True(t, errors.Is(err, ErrUnavailable))
+}
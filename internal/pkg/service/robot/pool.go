@@ -0,0 +1,127 @@
+package robot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// shard is the lots of the orders leased to one thread by the model, processed by a worker of the pool.
+// cycleId, groupId and pool are recorded with the thread to the run log.
+type shard struct {
+	threadKey string
+	lots      []map[string]interface{}
+	model     string
+	cycleId   string
+	groupId   *int64
+	pool      string
+}
+
+// pool is a fixed number of workers fed by a bounded work queue,
+// limit is the number of shards in flight the producers keep to, at most size
+type pool struct {
+	name  string
+	queue chan shard
+	size  int
+	limit int64
+	busy  int64
+	wg    sync.WaitGroup
+}
+
+func newPool(name string, size int, queue int) *pool {
+
+	if size < 1 {
+		size = 1
+	}
+	if queue < 1 {
+		queue = size
+	}
+
+	return &pool{
+		name:  name,
+		queue: make(chan shard, queue),
+		size:  size,
+		limit: int64(size),
+	}
+}
+
+// start runs the workers, each processes the shards of the queue one by one until drain
+func (p *pool) start(process func(item shard)) {
+
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+
+			for item := range p.queue {
+				atomic.AddInt64(&p.busy, 1)
+				process(item)
+				atomic.AddInt64(&p.busy, -1)
+			}
+		}()
+	}
+}
+
+// submit queues the shard and waits while the queue is full, false if ctx is done first
+func (p *pool) submit(ctx context.Context, item shard) bool {
+
+	select {
+	case p.queue <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// free is the number of shards the pool takes at once under its limit
+func (p *pool) free() int {
+
+	free := p.capacity() - p.inFlight()
+	if free < 0 {
+		return 0
+	}
+
+	return free
+}
+
+// capacity is the current limit of the shards in flight
+func (p *pool) capacity() int {
+	return int(atomic.LoadInt64(&p.limit))
+}
+
+func (p *pool) setCapacity(limit int) {
+
+	if limit > p.size {
+		limit = p.size
+	}
+	if limit < 1 {
+		limit = 1
+	}
+
+	atomic.StoreInt64(&p.limit, int64(limit))
+}
+
+// inFlight is the number of shards processed and queued
+func (p *pool) inFlight() int {
+	return int(atomic.LoadInt64(&p.busy)) + len(p.queue)
+}
+
+// drain closes the queue and waits for the workers to process what is left until ctx is done.
+// Nothing may be submitted after drain.
+func (p *pool) drain(ctx context.Context) error {
+
+	close(p.queue)
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
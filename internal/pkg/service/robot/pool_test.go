@@ -0,0 +1,99 @@
+package robot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolBounded(t *testing.T) {
+
+	p := newPool(DefaultPool, 3, 3)
+
+	var running, peak int64
+	var processed sync.Map
+	p.start(func(item shard) {
+		n := atomic.AddInt64(&running, 1)
+		for {
+			max := atomic.LoadInt64(&peak)
+			if n <= max || atomic.CompareAndSwapInt64(&peak, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		processed.Store(item.threadKey, true)
+		atomic.AddInt64(&running, -1)
+	})
+
+	for i := 0; i < 20; i++ {
+		if !p.submit(context.Background(), shard{threadKey: string(rune('a' + i))}) {
+			t.Fatalf("submit %d failed", i)
+		}
+	}
+
+	if err := p.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if peak > 3 {
+		t.Errorf("peak workers = %d, want at most 3", peak)
+	}
+
+	count := 0
+	processed.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	if count != 20 {
+		t.Errorf("processed = %d, want 20", count)
+	}
+}
+
+func TestPoolSubmitCancelled(t *testing.T) {
+
+	p := newPool(DefaultPool, 1, 1)
+
+	release := make(chan struct{})
+	p.start(func(item shard) {
+		<-release
+	})
+
+	// one shard in the worker, one in the queue, the third waits
+	p.submit(context.Background(), shard{})
+	p.submit(context.Background(), shard{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if p.submit(ctx, shard{}) {
+		t.Error("submit to a full queue succeeded")
+	}
+
+	if free := p.free(); free != 0 {
+		t.Errorf("free = %d, want 0", free)
+	}
+
+	close(release)
+	if err := p.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolDrainTimeout(t *testing.T) {
+
+	p := newPool(DefaultPool, 1, 1)
+
+	release := make(chan struct{})
+	defer close(release)
+	p.start(func(item shard) {
+		<-release
+	})
+	p.submit(context.Background(), shard{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("drain = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,293 @@
+package robot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"oms2/internal/pkg/jsonschema"
+	"oms2/internal/pkg/util"
+	"oms2/internal/pkg/variables"
+)
+
+const (
+	// OutcomeNext moves the lot to the next node, an empty outcome means the same
+	OutcomeNext = "next"
+	// OutcomeStay keeps the lot on the node, the action runs again on the next iteration
+	OutcomeStay = "stay"
+	// OutcomePending keeps the lot on the node until the job started by the action
+	// is completed or failed by the token of the output, see DoPending
+	OutcomePending = "pending"
+)
+
+var (
+	ErrUnknownAction   = errors.New("unknown action")
+	ErrDuplicateAction = errors.New("action is already registered")
+	ErrUnknownOutcome  = errors.New("unknown action outcome")
+)
+
+// ActionHandler is an action of a map node, registered in the Registry under the name in _Ref_M.action.
+// Handle makes the external call of the action, it runs outside of the step transaction
+// and must not write to the database.
+type ActionHandler interface {
+	Handle(ctx context.Context, in ActionInput) (ActionOutput, error)
+}
+
+// ActionApplier is implemented by handlers that write to the database. Apply gets the output of Handle
+// and runs in the step transaction, so its writes commit together with the move of the lot or not at all.
+type ActionApplier interface {
+	Apply(ctx context.Context, in ActionInput, out ActionOutput) (ActionOutput, error)
+}
+
+// NodeValidator is implemented by handlers that check the configuration of their nodes at startup
+type NodeValidator interface {
+	ValidateNode(ctx context.Context, nodeId int64) error
+}
+
+// ParamsDeclarer is implemented by handlers that declare a JSON Schema of the node params
+type ParamsDeclarer interface {
+	ParamsSchema() json.RawMessage
+}
+
+// WithParamsSchema declares the params schema for a handler
+func WithParamsSchema(handler ActionHandler, schema json.RawMessage) ActionHandler {
+	return paramsHandler{ActionHandler: handler, schema: schema}
+}
+
+type paramsHandler struct {
+	ActionHandler
+	schema json.RawMessage
+}
+
+func (h paramsHandler) ParamsSchema() json.RawMessage {
+	return h.schema
+}
+
+func (h paramsHandler) ValidateNode(ctx context.Context, nodeId int64) error {
+	if validator, ok := h.ActionHandler.(NodeValidator); ok {
+		return validator.ValidateNode(ctx, nodeId)
+	}
+
+	return nil
+}
+
+func (h paramsHandler) Apply(ctx context.Context, in ActionInput, out ActionOutput) (ActionOutput, error) {
+	return Apply(ctx, h.ActionHandler, in, out)
+}
+
+// Apply runs the database side of the handler if it has one
+func Apply(ctx context.Context, handler ActionHandler, in ActionInput, out ActionOutput) (ActionOutput, error) {
+	if applier, ok := handler.(ActionApplier); ok {
+		return applier.Apply(ctx, in, out)
+	}
+
+	return out, nil
+}
+
+// ValidateParams validates the node params against the schema the handler declares
+func ValidateParams(handler ActionHandler, params map[string]interface{}) error {
+
+	declarer, ok := handler.(ParamsDeclarer)
+	if !ok || len(declarer.ParamsSchema()) == 0 {
+		return nil
+	}
+
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
+	document, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	if err := jsonschema.Validate(declarer.ParamsSchema(), document); err != nil {
+		return fmt.Errorf("params: %w", err)
+	}
+
+	return nil
+}
+
+// ActionFunc adapts a function to ActionHandler
+type ActionFunc func(ctx context.Context, in ActionInput) (ActionOutput, error)
+
+func (f ActionFunc) Handle(ctx context.Context, in ActionInput) (ActionOutput, error) {
+	return f(ctx, in)
+}
+
+// ApplyFunc adapts a function to an action without an external call,
+// the function runs in the step transaction as its ActionApplier
+type ApplyFunc func(ctx context.Context, in ActionInput, out ActionOutput) (ActionOutput, error)
+
+func (f ApplyFunc) Handle(_ context.Context, _ ActionInput) (ActionOutput, error) {
+	return ActionOutput{}, nil
+}
+
+func (f ApplyFunc) Apply(ctx context.Context, in ActionInput, out ActionOutput) (ActionOutput, error) {
+	return f(ctx, in, out)
+}
+
+// ActionInput is the lot on the action node.
+// Params are the params of the node, Variables is a copy of the lot variables,
+// Event is the last event registered for the lot if any.
+// Attempt is the number of committed action runs of the lot, IdempotencyKey is lot:node:attempt,
+// it stays the same until the step commits, so external calls made with it are safe to repeat.
+// Token correlates the completion of the action if it returns OutcomePending, it is an HMAC
+// of the idempotency key, so a repeated run gets the same token and an outsider cannot guess it.
+type ActionInput struct {
+	LotId          int64                  `json:"lot_id"`
+	OrderId        int64                  `json:"order_id"`
+	NodeId         int64                  `json:"node_id"`
+	NodeName       string                 `json:"node_name"`
+	Action         string                 `json:"action"`
+	Params         map[string]interface{} `json:"params"`
+	Variables      map[string]interface{} `json:"variables"`
+	Event          *ActionEvent           `json:"event"`
+	Attempt        int64                  `json:"attempt"`
+	IdempotencyKey string                 `json:"idempotency_key"`
+	Token          string                 `json:"token"`
+}
+
+type ActionEvent struct {
+	Id      int64                  `json:"id"`
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// ActionOutput is the result of an action.
+// Variables is a patch of the lot variables, a nil value removes the variable.
+// For OutcomePending Token replaces the token of the input if set,
+// Timeout replaces the pending timeout of the node.
+type ActionOutput struct {
+	Outcome   string                 `json:"outcome"`
+	Variables map[string]interface{} `json:"variables"`
+	Token     string                 `json:"token"`
+	Timeout   time.Duration          `json:"timeout"`
+}
+
+// Registry holds the action handlers by name
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]ActionHandler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string]ActionHandler),
+	}
+}
+
+func (r *Registry) Register(name string, handler ActionHandler) error {
+
+	if len(name) == 0 || handler == nil {
+		return fmt.Errorf("%w: empty name or handler", ErrUnknownAction)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.handlers[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateAction, name)
+	}
+
+	if declarer, ok := handler.(ParamsDeclarer); ok && len(declarer.ParamsSchema()) > 0 {
+		if err := jsonschema.Check(declarer.ParamsSchema()); err != nil {
+			return fmt.Errorf("action %s: %w", name, err)
+		}
+	}
+
+	r.handlers[name] = handler
+
+	return nil
+}
+
+func (r *Registry) Handler(name string) (ActionHandler, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handler, ok := r.handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAction, name)
+	}
+
+	return handler, nil
+}
+
+// Names returns the registered action names in order
+func (r *Registry) Names() []string {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Missing returns the names that are not registered, in order and without repeats
+func (r *Registry) Missing(names []string) []string {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	missing := make([]string, 0)
+	for _, name := range names {
+		if _, ok := r.handlers[name]; ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+// NewActionInput builds the action input from a processing row
+func NewActionInput(data map[string]interface{}) (ActionInput, error) {
+
+	vars, err := util.ToMap(data["variables"])
+	if err != nil {
+		return ActionInput{}, err
+	}
+
+	params, err := util.ToMap(data["params"])
+	if err != nil {
+		return ActionInput{}, err
+	}
+
+	in := ActionInput{
+		LotId:     util.ToInt64(data["lot_id"]),
+		OrderId:   util.ToInt64(data["order_id"]),
+		NodeId:    util.ToInt64(data["node_id"]),
+		NodeName:  fmt.Sprint(data["name"]),
+		Action:    fmt.Sprint(data["action"]),
+		Params:    params,
+		Variables: variables.Copy(vars),
+	}
+
+	if data["event_id"] != nil {
+		payload, err := util.ToMap(data["event_payload"])
+		if err != nil {
+			return ActionInput{}, err
+		}
+
+		in.Event = &ActionEvent{
+			Id:      util.ToInt64(data["event_id"]),
+			Type:    fmt.Sprint(data["event_type"]),
+			Payload: payload,
+		}
+	}
+
+	return in, nil
+}
@@ -0,0 +1,143 @@
+package robot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"oms2/internal/pkg/jsonschema"
+)
+
+func TestRegistry(t *testing.T) {
+
+	registry := NewRegistry()
+
+	handler := ActionFunc(func(_ context.Context, in ActionInput) (ActionOutput, error) {
+		return ActionOutput{Outcome: OutcomeNext, Variables: map[string]interface{}{"node": in.NodeName}}, nil
+	})
+
+	require.NoError(t, registry.Register("Reserve", handler))
+	require.NoError(t, registry.Register("Notify", handler))
+	require.True(t, errors.Is(registry.Register("Reserve", handler), ErrDuplicateAction))
+	require.Error(t, registry.Register("", handler))
+
+	require.Equal(t, []string{"Notify", "Reserve"}, registry.Names())
+	require.Equal(t, []string{"Cancel", "Reserv"}, registry.Missing([]string{"Reserve", "Reserv", "Cancel", "Reserv"}))
+	require.Empty(t, registry.Missing([]string{"Notify"}))
+
+	_, err := registry.Handler("Reserv")
+	require.True(t, errors.Is(err, ErrUnknownAction))
+
+	h, err := registry.Handler("Reserve")
+	require.NoError(t, err)
+
+	out, err := h.Handle(context.Background(), ActionInput{NodeName: "reserve"})
+	require.NoError(t, err)
+	require.Equal(t, "reserve", out.Variables["node"])
+}
+
+func TestNewActionInput(t *testing.T) {
+
+	data := map[string]interface{}{
+		"lot_id":    int64(7),
+		"order_id":  int32(3),
+		"node_id":   int64(2),
+		"name":      "node2",
+		"action":    "SecondInit",
+		"variables": map[string]interface{}{"status": "new"},
+		"params":    map[string]interface{}{"template": "order_paid"},
+		"event_id":  nil,
+	}
+
+	in, err := NewActionInput(data)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), in.LotId)
+	require.Equal(t, int64(3), in.OrderId)
+	require.Equal(t, "SecondInit", in.Action)
+	require.Equal(t, "order_paid", in.Params["template"])
+	require.Nil(t, in.Event)
+
+	in.Variables["status"] = "changed"
+	require.Equal(t, "new", data["variables"].(map[string]interface{})["status"])
+
+	data["event_id"] = int64(11)
+	data["event_type"] = "paid"
+	data["event_payload"] = map[string]interface{}{"amount": 10.0}
+
+	in, err = NewActionInput(data)
+	require.NoError(t, err)
+	require.Equal(t, &ActionEvent{Id: 11, Type: "paid", Payload: map[string]interface{}{"amount": 10.0}}, in.Event)
+}
+
+type configuredHandler struct {
+	ActionFunc
+	validated int64
+}
+
+func (h *configuredHandler) ValidateNode(_ context.Context, nodeId int64) error {
+	h.validated = nodeId
+	return nil
+}
+
+func TestParamsSchema(t *testing.T) {
+
+	noop := ActionFunc(func(_ context.Context, _ ActionInput) (ActionOutput, error) {
+		return ActionOutput{}, nil
+	})
+
+	schema := json.RawMessage(`{
+		"type": "object",
+		"required": ["template"],
+		"properties": {"template": {"type": "string"}, "channels": {"type": "array", "items": {"type": "string"}}}
+	}`)
+
+	notify := WithParamsSchema(noop, schema)
+
+	require.NoError(t, ValidateParams(notify, map[string]interface{}{"template": "order_paid"}))
+	require.NoError(t, ValidateParams(noop, nil))
+
+	var validationError *jsonschema.ValidationError
+	require.True(t, errors.As(ValidateParams(notify, nil), &validationError))
+	require.True(t, errors.As(ValidateParams(notify, map[string]interface{}{"template": 1}), &validationError))
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register("Notify", notify))
+	require.Error(t, registry.Register("Broken", WithParamsSchema(noop, json.RawMessage(`{"type": 5}`))))
+
+	configured := &configuredHandler{ActionFunc: noop}
+	validator, ok := WithParamsSchema(configured, schema).(NodeValidator)
+	require.True(t, ok)
+	require.NoError(t, validator.ValidateNode(context.Background(), 4))
+	require.Equal(t, int64(4), configured.validated)
+}
+
+func TestApply(t *testing.T) {
+
+	ctx := context.Background()
+	in := ActionInput{LotId: 1}
+
+	handled := ActionFunc(func(_ context.Context, _ ActionInput) (ActionOutput, error) {
+		return ActionOutput{Outcome: OutcomeStay}, nil
+	})
+	out, err := Apply(ctx, handled, in, ActionOutput{Outcome: OutcomeStay})
+	require.NoError(t, err)
+	require.Equal(t, ActionOutput{Outcome: OutcomeStay}, out)
+
+	applied := ApplyFunc(func(_ context.Context, in ActionInput, out ActionOutput) (ActionOutput, error) {
+		out.Variables = map[string]interface{}{"lot": in.LotId}
+		return out, nil
+	})
+
+	// an action without an external call does nothing outside of the step transaction
+	out, err = applied.Handle(ctx, in)
+	require.NoError(t, err)
+	require.Equal(t, ActionOutput{}, out)
+
+	// the params schema wrapper keeps the database side of the handler
+	out, err = Apply(ctx, WithParamsSchema(applied, json.RawMessage(`{"type": "object"}`)), in, ActionOutput{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"lot": int64(1)}, out.Variables)
+}
@@ -0,0 +1,129 @@
+package robot
+
+import (
+	"context"
+	"sync/atomic"
+
+	"oms2/internal/pkg/service/runlog"
+	"oms2/internal/pkg/util"
+)
+
+// cycle counts what a cycle of a model queues to the pools, it is used by the goroutine of the cycle only
+type cycle struct {
+	id      string
+	threads int
+	orders  int
+	lots    int
+}
+
+// threadStats counts the transitions and the action errors of the steps of a thread
+type threadStats struct {
+	transitions  int64
+	actionErrors int64
+}
+
+type cycleKey struct{}
+
+type threadStatsKey struct{}
+
+type stepKey struct{}
+
+// step holds back the transitions of a step transaction until it commits, it is used by the goroutine of the thread only
+type step struct {
+	transitions int64
+}
+
+func withCycle(ctx context.Context, c *cycle) context.Context {
+	return context.WithValue(ctx, cycleKey{}, c)
+}
+
+// cycleFrom returns the cycle of the context, nil outside of a cycle
+func cycleFrom(ctx context.Context) *cycle {
+	c, _ := ctx.Value(cycleKey{}).(*cycle)
+	return c
+}
+
+// queued counts a shard queued by the cycle
+func (c *cycle) queued(item shard) {
+	if c == nil {
+		return
+	}
+
+	c.threads += 1
+	c.orders += countOrders(item.lots)
+	c.lots += len(item.lots)
+}
+
+func withThreadStats(ctx context.Context, stats *threadStats) context.Context {
+	return context.WithValue(ctx, threadStatsKey{}, stats)
+}
+
+// withStep collects the transitions of a step transaction, done counts them for the thread
+// if the step committed. A step nested in another one commits with it and leaves the counting to it.
+func withStep(ctx context.Context) (context.Context, func(err error)) {
+
+	if _, ok := ctx.Value(stepKey{}).(*step); ok {
+		return ctx, func(error) {}
+	}
+
+	st := &step{}
+	return context.WithValue(ctx, stepKey{}, st), func(err error) {
+		if err != nil {
+			return
+		}
+		if stats, ok := ctx.Value(threadStatsKey{}).(*threadStats); ok {
+			atomic.AddInt64(&stats.transitions, st.transitions)
+		}
+	}
+}
+
+// countTransition counts a move of a lot to the next node by the thread of the context,
+// within a step it is counted once the step commits
+func countTransition(ctx context.Context) {
+	if st, ok := ctx.Value(stepKey{}).(*step); ok {
+		st.transitions += 1
+		return
+	}
+	if stats, ok := ctx.Value(threadStatsKey{}).(*threadStats); ok {
+		atomic.AddInt64(&stats.transitions, 1)
+	}
+}
+
+// countActionError counts a failed action call by the thread of the context
+func countActionError(ctx context.Context) {
+	if stats, ok := ctx.Value(threadStatsKey{}).(*threadStats); ok {
+		atomic.AddInt64(&stats.actionErrors, 1)
+	}
+}
+
+func countOrders(lots []map[string]interface{}) int {
+
+	orders := make(map[int64]bool)
+	for _, lot := range lots {
+		orders[util.ToInt64(lot["order_id"])] = true
+	}
+
+	return len(orders)
+}
+
+// runGroup is the processing group of the params of a manager, nil for no group
+func runGroup(groupId interface{}) *int64 {
+
+	if groupId == nil {
+		return nil
+	}
+
+	id := util.ToInt64(groupId)
+	if id < 0 {
+		return nil
+	}
+
+	return &id
+}
+
+// recordRun saves the run to the run log, a failure is logged and does not stop the robot
+func (s *Service) recordRun(run runlog.Run) {
+	if err := s.runLog.Record(s.workCtx, run); err != nil {
+		s.zl.Sugar().Warn(err)
+	}
+}
@@ -0,0 +1,86 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunStats(t *testing.T) {
+
+	c := &cycle{id: "c1"}
+	ctx := withCycle(context.Background(), c)
+
+	cycleFrom(ctx).queued(shard{lots: []map[string]interface{}{
+		{"lot_id": int32(1), "order_id": int32(10)},
+		{"lot_id": int32(2), "order_id": int32(10)},
+		{"lot_id": int32(3), "order_id": int32(20)},
+	}})
+	cycleFrom(ctx).queued(shard{lots: []map[string]interface{}{
+		{"lot_id": int32(4), "order_id": int32(30)},
+	}})
+
+	if c.threads != 2 || c.orders != 3 || c.lots != 4 {
+		t.Errorf("cycle = %+v, want 2 threads, 3 orders and 4 lots", *c)
+	}
+
+	// outside of a cycle and a thread nothing is counted
+	cycleFrom(context.Background()).queued(shard{})
+	countTransition(context.Background())
+
+	stats := &threadStats{}
+	ctx = withThreadStats(context.Background(), stats)
+	countTransition(ctx)
+	countTransition(ctx)
+	countActionError(ctx)
+
+	if stats.transitions != 2 || stats.actionErrors != 1 {
+		t.Errorf("thread = %+v, want 2 transitions and 1 action error", *stats)
+	}
+}
+
+func TestRunGroup(t *testing.T) {
+
+	if id := runGroup(-1); id != nil {
+		t.Errorf("group -1 = %d, want nil", *id)
+	}
+	if id := runGroup(nil); id != nil {
+		t.Errorf("group nil = %d, want nil", *id)
+	}
+	if id := runGroup(int32(500)); id == nil || *id != 500 {
+		t.Errorf("group 500 = %v, want 500", id)
+	}
+}
+
+func TestStepTransitions(t *testing.T) {
+
+	stats := &threadStats{}
+	ctx := withThreadStats(context.Background(), stats)
+
+	// a rolled back step counts nothing
+	stepCtx, done := withStep(ctx)
+	countTransition(stepCtx)
+	done(errors.New("rollback"))
+
+	if stats.transitions != 0 {
+		t.Fatalf("rolled back step counted %d transitions", stats.transitions)
+	}
+
+	// a nested step is counted by the outer one once it commits
+	stepCtx, done = withStep(ctx)
+	countTransition(stepCtx)
+
+	nestedCtx, nestedDone := withStep(stepCtx)
+	countTransition(nestedCtx)
+	nestedDone(nil)
+
+	if stats.transitions != 0 {
+		t.Fatalf("transitions counted before the commit: %d", stats.transitions)
+	}
+
+	done(nil)
+
+	if stats.transitions != 2 {
+		t.Fatalf("committed step counted %d transitions, want 2", stats.transitions)
+	}
+}
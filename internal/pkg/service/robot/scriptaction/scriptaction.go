@@ -0,0 +1,123 @@
+// Package scriptaction is the built-in script action: it runs the Starlark source from the node params
+// in the sandbox of the script package with read and write access to the lot variables.
+package scriptaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/repository/action"
+	"oms2/internal/pkg/script"
+	"oms2/internal/pkg/service/robot"
+	"oms2/internal/pkg/util"
+	"oms2/internal/pkg/variables"
+)
+
+// Name of the action in _Ref_M.action
+const Name = "script"
+
+// GlobalOutcome is the global a script assigns the outcome of the action to, next by default
+const GlobalOutcome = "outcome"
+
+var ErrNodeNotFound = errors.New("node not found")
+
+// ParamsSchema of the node params: the source of the script and any params it reads from the params global
+var ParamsSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["source"],
+	"properties": {
+		"source": {"type": "string", "minLength": 1}
+	}
+}`)
+
+type Handler struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository *action.Repository
+}
+
+func NewHandler(cfg *oms.Config, r *action.Repository, zl *zap.Logger) *Handler {
+	return &Handler{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+	}
+}
+
+func (h *Handler) ParamsSchema() json.RawMessage {
+	return ParamsSchema
+}
+
+// Handle runs the script and returns the changes it made to the variables global
+func (h *Handler) Handle(ctx context.Context, in robot.ActionInput) (robot.ActionOutput, error) {
+
+	limits := script.Limits{MaxSteps: h.cfg.Script.MaxSteps, Timeout: h.cfg.Script.Timeout}
+
+	return Run(ctx, fmt.Sprint(in.Params["source"]), in, limits, func(message string) {
+		h.zl.Info(message, zap.String("node", in.NodeName), zap.Int64("lot_id", in.LotId))
+	})
+}
+
+// ValidateNode checks that the script of the node compiles
+func (h *Handler) ValidateNode(ctx context.Context, nodeId int64) error {
+
+	rows, err := h.repository.NodeParams(ctx, nodeId)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		return fmt.Errorf("%w: %d", ErrNodeNotFound, nodeId)
+	}
+
+	params, err := util.ToMap(rows[0]["params"])
+	if err != nil {
+		return err
+	}
+
+	return Check(fmt.Sprint(params["source"]))
+}
+
+// Check compiles the source with the globals of the action predeclared
+func Check(source string) error {
+	return script.Check(source, append(robot.ScriptGlobalNames, GlobalOutcome)...)
+}
+
+// Run executes the source with the globals of the action input
+func Run(ctx context.Context, source string, in robot.ActionInput, limits script.Limits, print script.Printer) (robot.ActionOutput, error) {
+
+	before, err := script.Normalize(in.Variables)
+	if err != nil {
+		return robot.ActionOutput{}, err
+	}
+
+	globals := robot.ScriptGlobals(in)
+	globals[GlobalOutcome] = robot.OutcomeNext
+
+	result, err := script.Run(ctx, source, globals, limits, print)
+	if err != nil {
+		return robot.ActionOutput{}, fmt.Errorf("node %s: %w", in.NodeName, err)
+	}
+
+	after, ok := result[robot.GlobalVariables].(map[string]interface{})
+	if !ok {
+		return robot.ActionOutput{}, fmt.Errorf("node %s: %s must stay a dict", in.NodeName, robot.GlobalVariables)
+	}
+
+	outcome, ok := result[GlobalOutcome].(string)
+	if !ok {
+		return robot.ActionOutput{}, fmt.Errorf("%w: %v", robot.ErrUnknownOutcome, result[GlobalOutcome])
+	}
+
+	previous, _ := before.(map[string]interface{})
+
+	return robot.ActionOutput{
+		Outcome:   outcome,
+		Variables: variables.Diff(previous, after),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package scriptaction
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"oms2/internal/pkg/script"
+	"oms2/internal/pkg/service/robot"
+)
+
+var limits = script.Limits{MaxSteps: 100000, Timeout: time.Second}
+
+func TestRun(t *testing.T) {
+
+	source := `
+variables["attempts"] = variables.get("attempts", 0) + 1
+variables.pop("draft")
+if event and event["type"] == "paid":
+    variables["paid_amount"] = event["payload"]["amount"]
+elif variables["attempts"] < params["max_attempts"]:
+    outcome = "stay"
+`
+	in := robot.ActionInput{
+		LotId:     7,
+		NodeName:  "check payment",
+		Params:    map[string]interface{}{"source": source, "max_attempts": 3.0},
+		Variables: map[string]interface{}{"attempts": 1.0, "draft": true, "total": 10.0},
+	}
+
+	out, err := Run(context.Background(), source, in, limits, nil)
+	require.NoError(t, err)
+	require.Equal(t, robot.OutcomeStay, out.Outcome)
+	require.Equal(t, map[string]interface{}{"attempts": int64(2), "draft": nil}, out.Variables)
+
+	in.Event = &robot.ActionEvent{Id: 1, Type: "paid", Payload: map[string]interface{}{"amount": 10.5}}
+	out, err = Run(context.Background(), source, in, limits, nil)
+	require.NoError(t, err)
+	require.Equal(t, robot.OutcomeNext, out.Outcome)
+	require.Equal(t, 10.5, out.Variables["paid_amount"])
+}
+
+func TestRunErrors(t *testing.T) {
+
+	in := robot.ActionInput{Variables: map[string]interface{}{}}
+
+	_, err := Run(context.Background(), `variables = None`, in, limits, nil)
+	require.Error(t, err)
+
+	_, err = Run(context.Background(), `outcome = 1`, in, limits, nil)
+	require.ErrorIs(t, err, robot.ErrUnknownOutcome)
+
+	_, err = Run(context.Background(), `fail("no stock")`, in, limits, nil)
+	require.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+
+	require.NoError(t, Check(`outcome = "stay" if lot["lot_id"] > 0 else "next"`))
+	require.Error(t, Check(`items = unknown`))
+	require.NoError(t, robot.ValidateParams(NewHandler(nil, nil, nil), map[string]interface{}{"source": "pass"}))
+	require.Error(t, robot.ValidateParams(NewHandler(nil, nil, nil), map[string]interface{}{}))
+}
@@ -2,23 +2,30 @@ package robot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	uuid "github.com/satori/go.uuid"
+	"oms2/internal/pkg/service/event"
+	"oms2/internal/pkg/service/leader"
 	"oms2/internal/pkg/service/log"
+	"oms2/internal/pkg/service/runlog"
+	"oms2/internal/pkg/service/webhook"
 	v7 "oms2/internal/pkg/storage/elastic/v7"
+	"oms2/internal/pkg/trigger"
 	"oms2/internal/pkg/util"
-	"os"
-	"os/signal"
-	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/squirrel"
 	"go.uber.org/zap"
 
 	"oms2/internal/oms"
+	"oms2/internal/pkg/metrics"
+	"oms2/internal/pkg/repository/lot"
 	"oms2/internal/pkg/repository/robot"
+	"oms2/internal/pkg/variables"
 )
 
 const (
@@ -29,151 +36,340 @@ const (
 
 const (
 	action    = "action"
+	decision  = "decision"
 	wait      = "wait"
 	terminate = "terminate"
 )
 
-const (
-	PrefixKeyThreadManager = "ManagerThreadRun"
-)
-
 type Service struct {
 	zl  *zap.Logger
 	cfg *oms.Config
 
-	ticker         *time.Ticker
 	restartTimeOut time.Duration
 
-	done  chan bool
-	model string
-	wg    sync.WaitGroup
+	modelMu sync.RWMutex
+	model   string
 
-	action          *Action
+	registry        *Registry
+	guards          *Guards
 	robotRepository *robot.Repository
+	lotRepository   *lot.Repository
 	logger          *log.Service
-
-	managers map[string]chan int
-	robotCh  chan bool
-	running  bool
+	webhook         *webhook.Service
+	events          *event.Service
+	leader          *leader.Service
+	runLog          *runlog.Service
+
+	pools *pools
+
+	// polled is the time the MultiTiling manager last polled a group
+	polled map[int32]time.Time
+	fair   *FairScheduler
+
+	// ctx stops taking new work, workCtx cancels the steps in flight when the drain times out
+	ctx        context.Context
+	cancel     context.CancelFunc
+	workCtx    context.Context
+	workCancel context.CancelFunc
+	stopped    chan struct{}
 }
 
-func NewService(cfg *oms.Config, action *Action, r *robot.Repository, logger *log.Service, zl *zap.Logger) *Service {
+func NewService(cfg *oms.Config, registry *Registry, r *robot.Repository, lots *lot.Repository, logger *log.Service, webhook *webhook.Service, events *event.Service, leader *leader.Service, runLog *runlog.Service, zl *zap.Logger) *Service {
 	return &Service{
 		zl:              zl,
 		cfg:             cfg,
-		ticker:          time.NewTicker(1 * time.Second),
 		restartTimeOut:  10 * time.Second,
-		done:            make(chan bool),
-		model:           TilingModel,
-		action:          action,
+		model:           cfg.RobotModel,
+		registry:        registry,
+		guards:          NewGuards(cfg.Actions.Limits),
 		robotRepository: r,
+		lotRepository:   lots,
 		logger:          logger,
-		managers:        make(map[string]chan int),
-		robotCh:         make(chan bool),
+		webhook:         webhook,
+		events:          events,
+		leader:          leader,
+		runLog:          runLog,
+		polled:          make(map[int32]time.Time),
+		fair:            NewFairScheduler(),
 	}
 }
 
-func (s *Service) Start(_ context.Context) error {
+// Start runs MaxRobotGoroutines workers of the default pool and the workers of the pools of the node groups,
+// each fed by its work queue, and the loop calling Do every second.
+// The shutdown comes from the fx lifecycle, fx stops the application on SIGINT and SIGTERM.
+func (s *Service) Start(ctx context.Context) error {
+
+	if err := s.ValidateActions(ctx); err != nil {
+		return err
+	}
+
+	if err := s.ValidateDecisions(ctx); err != nil {
+		return err
+	}
+
+	if err := ValidModel(s.cfg.RobotModel); err != nil {
+		return err
+	}
+
+	if err := s.RefreshModel(ctx); err != nil {
+		return err
+	}
 
-	c, cancel := context.WithTimeout(context.Background(), s.cfg.MaxCollectTime)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.workCtx, s.workCancel = context.WithCancel(context.Background())
+	s.stopped = make(chan struct{})
 
-	signals := make(chan os.Signal)
-	signal.Notify(signals, os.Interrupt)
+	s.pools = newPools(s.cfg.MaxRobotGoroutines, s.cfg.NodePools)
+	s.pools.start(s.process)
+	for _, p := range s.pools.list {
+		metrics.RobotConcurrencyLimit.WithLabelValues(p.name).Set(float64(p.capacity()))
+	}
 
-	go func() {
+	go s.run()
+	if s.cfg.Concurrency.Adaptive {
+		go s.adapt()
+	}
 
-		defer func() {
-			s.logger.LogMessage(c, v7.SystemMessage, "Остановка сервиса...", v7.SystemIndex, util.EmptyDataStruct())
-		}()
+	s.logger.LogMessage(ctx, v7.SystemMessage, "Robot has started", v7.SystemIndex, util.EmptyDataStruct())
 
-		s.running = true
+	return nil
+}
 
-		for {
-			select {
-			case res := <-s.done:
-				if res {
-					s.ticker.Stop()
+// Stop stops taking new work and drains the steps in flight until ctx, bounded by StopTimeout, is done.
+// Queued shards not started yet release their leases, the steps still running after ctx are cancelled
+// and rolled back with their transactions.
+func (s *Service) Stop(ctx context.Context) error {
 
-					s.logger.LogMessage(c, v7.SystemMessage, "Остановка тикера", v7.SystemIndex, util.EmptyDataStruct())
+	if s.cancel == nil {
+		return nil
+	}
 
-					cancel()
-					return
-				}
-			case t := <-s.ticker.C:
+	s.logger.LogMessage(ctx, v7.SystemMessage, "Остановка сервиса...", v7.SystemIndex, util.EmptyDataStruct())
 
-				err := s.Do(c, t)
-				if err != nil {
-					s.zl.Sugar().Error(err)
-					s.ticker.Stop()
+	s.cancel()
+	defer s.workCancel()
 
-					go func() {
-						s.done <- true
-					}()
-				}
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("robot stop: %w", ctx.Err())
+	}
 
-			default:
+	if err := s.pools.drain(ctx); err != nil {
+		return fmt.Errorf("robot drain: %w", err)
+	}
 
+	return nil
+}
+
+// run calls Do on every tick until Stop with the model set at the moment,
+// an error of the tick is logged and the tick is retried on the next one
+func (s *Service) run() {
+
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case t := <-ticker.C:
+			if err := s.RefreshModel(s.ctx); err != nil && s.ctx.Err() == nil {
+				s.zl.Sugar().Error(err)
 			}
 
+			if err := s.Do(s.ctx, t); err != nil && s.ctx.Err() == nil {
+				s.zl.Sugar().Error(err)
+			}
 		}
-	}()
+	}
+}
 
-	go func() {
-		for {
-			select {
-			case sig := <-signals:
-				message := fmt.Sprintf("Got %s signals. Aborting...", sig)
-				s.logger.LogMessage(c, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
+// adapt samples the load of the database every Concurrency.Interval and sets the limit of the threads
+// in flight of every pool by AIMD of its own: the pools share the database, so a sample over the targets
+// cuts them all, and a pool grows only while it uses its whole limit. Latency is the mean duration
+// of the queries run over the interval, wait the mean wait for a connection.
+func (s *Service) adapt() {
 
-				go func() {
-					s.robotCh <- true
-					s.done <- true
-				}()
+	conf := s.cfg.Concurrency
+	limiters := make(map[*pool]*AIMD, len(s.pools.list))
+	for _, p := range s.pools.list {
+		limiters[p] = NewAIMD(conf.Min, p.size, conf.Backoff, conf.TargetLatency, conf.TargetWait)
+	}
 
-				return
-			}
+	last := s.robotRepository.Load()
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-	}()
+		load := s.robotRepository.Load()
 
-	message := fmt.Sprintf("Robot has started")
-	s.logger.LogMessage(c, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
+		var wait, latency time.Duration
+		if acquires := load.AcquireCount - last.AcquireCount; acquires > 0 {
+			wait = (load.AcquireDuration - last.AcquireDuration) / time.Duration(acquires)
+		}
+		if queries := load.QueryCount - last.QueryCount; queries > 0 {
+			latency = (load.QueryDuration - last.QueryDuration) / time.Duration(queries)
+		}
+		last = load
 
-	return nil
+		for _, p := range s.pools.list {
+			limit := limiters[p].Update(LoadSample{
+				Latency:   latency,
+				Wait:      wait,
+				Saturated: p.inFlight() >= p.capacity(),
+			})
+			p.setCapacity(limit)
 
+			metrics.RobotConcurrencyLimit.WithLabelValues(p.name).Set(float64(limit))
+		}
+
+		metrics.RobotDBLatency.Set(latency.Seconds())
+		metrics.RobotDBPoolWait.Set(wait.Seconds())
+	}
 }
 
-func (s *Service) Stop(_ context.Context) error {
-	go func() {
-		s.done <- true
-	}()
+// process runs the steps of a shard taken from the queue by a worker,
+// a shard taken after Stop is not started and its leases are released
+func (s *Service) process(item shard) {
 
-	return nil
+	if s.ctx.Err() != nil {
+		s.release(item.threadKey)
+		return
+	}
+
+	start := time.Now()
+	stats := &threadStats{}
+	err := s.Shard_DoStepAndEvents(withThreadStats(s.workCtx, stats), item.lots, item.threadKey)
+	if err != nil {
+		s.zl.Sugar().Info(err)
+	}
+	end := time.Now()
+	metrics.RobotThreadDuration.WithLabelValues(item.model).Observe(end.Sub(start).Seconds())
+
+	transitions := atomic.LoadInt64(&stats.transitions)
+	actionErrors := atomic.LoadInt64(&stats.actionErrors)
+	s.recordRun(runlog.Run{
+		Kind:         runlog.KindThread,
+		CycleId:      item.cycleId,
+		ThreadKey:    item.threadKey,
+		Model:        item.model,
+		GroupId:      item.groupId,
+		Pool:         item.pool,
+		Threads:      1,
+		Orders:       countOrders(item.lots),
+		Lots:         len(item.lots),
+		Transitions:  &transitions,
+		ActionErrors: &actionErrors,
+		Err:          err,
+		Start:        start,
+		End:          end,
+	})
+}
+
+// submit queues the shard to the pool and waits while the queue is full,
+// a shard not queued before ctx is done releases its leases
+func (s *Service) submit(ctx context.Context, p *pool, item shard) bool {
+
+	if p.submit(ctx, item) {
+		cycleFrom(ctx).queued(item)
+		metrics.RobotThreads.WithLabelValues(item.model).Inc()
+		metrics.RobotBatchSize.WithLabelValues(item.model).Observe(float64(len(item.lots)))
+		return true
+	}
+
+	s.release(item.threadKey)
+	return false
+}
+
+func (s *Service) release(threadKey string) {
+	if err := s.robotRepository.DeleteFromRegisterActivityByThreadId(s.workCtx, threadKey); err != nil {
+		s.zl.Sugar().Error(err)
+	}
 }
 
+// Do runs a cycle of the model on the tick. Scheduled events and the Tiling managers run on the leader only,
+// the Iteration model runs on every instance, the leases of the orders keep the replicas apart.
+// The cycle and its threads are recorded to the run log, a cycle queuing nothing without an error is not.
 func (s *Service) Do(ctx context.Context, t time.Time) (err error) {
 
-	switch s.model {
+	if s.leader.IsLeader() {
+		s.DoScheduledEvents(ctx)
+	}
+
+	model := s.Model()
+	start := time.Now()
+
+	c := &cycle{id: uuid.NewV4().String()}
+	ctx = withCycle(ctx, c)
+
+	switch model {
 	case IterationModel:
 		err = s.Iteration(ctx, t)
 	case TilingModel:
-		if !s.running {
-			break
+		if !s.leader.IsLeader() {
+			return err
 		}
 		err = s.Tiling(ctx, t)
 	case MultiTilingModel:
-		if !s.running {
-			break
+		if !s.leader.IsLeader() {
+			return err
 		}
 		err = s.MultiTiling(ctx, t)
 
 	default:
+		return err
+	}
+
+	end := time.Now()
+	metrics.RobotCycleDuration.WithLabelValues(model).Observe(end.Sub(start).Seconds())
 
+	if c.threads == 0 && err == nil {
+		return err
 	}
 
+	s.recordRun(runlog.Run{
+		Kind:    runlog.KindCycle,
+		CycleId: c.id,
+		Model:   model,
+		Threads: c.threads,
+		Orders:  c.orders,
+		Lots:    c.lots,
+		Err:     err,
+		Start:   start,
+		End:     end,
+	})
+
 	return err
 }
 
+// DoScheduledEvents registers due scheduled events before the step,
+// a failure is logged and retried on the next tick. The events are registered under
+// the fencing token of the leader, a stale leader registers nothing.
+func (s *Service) DoScheduledEvents(ctx context.Context) {
+
+	fired := 0
+	err := s.leader.Fenced(ctx, func(ctx context.Context) (err error) {
+		fired, err = s.events.MaterializeDue(ctx)
+		return err
+	})
+	if err != nil {
+		s.zl.Sugar().Error(err)
+	}
+
+	if fired > 0 {
+		s.zl.Sugar().Info(fmt.Sprintf("Scheduled events fired: %d", fired))
+	}
+}
+
 func (s *Service) Iteration(ctx context.Context, t time.Time) (ok error) {
 
 	ok = s.DoStep(ctx)
@@ -204,47 +400,25 @@ func (s *Service) DoStep(ctx context.Context) (ok error) {
 	return ok
 }
 
+// DoAsync queues the due lots to the pools in shards of the orders leased by the instance,
+// the queues are bounded, so a busy pool holds the next shards back
 func (s *Service) DoAsync(ctx context.Context) (int, error) {
 
-	lotsOrdersNoGroup, ok := s.robotRepository.GetOrderByLotsFromProcessingRegister(ctx)
+	free := s.pools.free()
+	if free == 0 {
+		return 0, nil
+	}
+
+	lotsOrdersNoGroup, ok := s.robotRepository.GetOrderByLotsFromProcessingRegister(ctx, s.cfg.EventWindow)
 	if ok != nil {
 		return 0, ok
 	}
 
 	paramsManager := make(map[string]interface{}, 0)
-	paramsManager["cursor"] = s.cfg.MaxRobotGoroutines
-
-	lotsByStream, count := s.DivideLotsByOrders(lotsOrdersNoGroup, paramsManager)
-
-	var wg sync.WaitGroup
-	for _, items := range lotsByStream {
+	paramsManager["cursor"] = free
+	paramsManager["group"] = -1
 
-		go func() {
-			select {
-			case res := <-s.robotCh:
-				if res {
-					s.running = !res
-				}
-			}
-		}()
-
-		if !s.running {
-			break
-		}
-
-		wg.Add(1)
-		go func(data []map[string]interface{}) {
-			defer wg.Done()
-			err := s.DoStepAndEvents(ctx, data)
-			if err != nil {
-				s.zl.Sugar().Info(err)
-			}
-		}(items)
-	}
-
-	wg.Wait()
-
-	return count, ok
+	return s.dispatch(ctx, IterationModel, lotsOrdersNoGroup, paramsManager)
 }
 
 // Tiling tiling model
@@ -254,61 +428,26 @@ func (s *Service) Tiling(ctx context.Context, t time.Time) (ok error) {
 	s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
 
 	startTime := time.Now()
-	for {
+	for s.isManaging(ctx, TilingModel, startTime) {
 
-		go func() {
-			select {
-			case res := <-s.robotCh:
-				if res {
-					s.running = !res
-				}
-			default:
-			}
-		}()
-
-		isContinue := s.running && (time.Now().Sub(startTime) < s.restartTimeOut)
-		if !isContinue {
-			break
-		}
-
-		paramsManager := make(map[string]interface{}, 0)
-		paramsManager["cursor"] = s.cfg.MaxRobotGoroutines
-		paramsManager["group"] = -1
-
-		registerActivityList, ok := s.robotRepository.GetRegisterActivityList(ctx)
-		if ok != nil {
-			return ok
-		}
+		if free := s.pools.free(); free > 0 {
 
-		if len(registerActivityList) < s.cfg.MaxRobotGoroutines && len(s.managers) == 0 {
+			paramsManager := make(map[string]interface{}, 0)
+			paramsManager["cursor"] = free
+			paramsManager["group"] = -1
 
-			if len(registerActivityList) > 0 {
-				paramsManager["cursor"] = s.cfg.MaxRobotGoroutines - len(registerActivityList) - 1
+			count, ok := s.TilingThreadManager(ctx, TilingModel, paramsManager)
+			if ok != nil {
+				return ok
 			}
 
-			//uid := uuid.NewV4().String()
-			uid := PrefixKeyThreadManager
-			manager := make(chan int) // канал для менеджера потоков
-
-			s.managers[uid] = manager
-			go s.TilingThreadManager(ctx, uid, manager, paramsManager)
-
-			message := fmt.Sprintf("running managers: %d", len(s.managers))
-			s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
-		}
-
-		for _, data := range s.managers {
-			go func(ch chan int) {
-				select {
-				case item := <-ch:
-					message := fmt.Sprintf("manager data: %d", item)
-					s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
-				default:
-				}
-			}(data)
+			if count > 0 {
+				message := fmt.Sprintf("manager data: %d", count)
+				s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
+			}
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		s.pause(ctx)
 	}
 
 	message = fmt.Sprintf("End Tiling manager: %d", time.Now().Sub(startTime))
@@ -317,88 +456,108 @@ func (s *Service) Tiling(ctx context.Context, t time.Time) (ok error) {
 	return ok
 }
 
+// MultiTiling runs the managers of the processing groups. The threads of the pool are shared between
+// the groups with due orders by the fair scheduler, by weight and with the minimum of every group,
+// a group gets the threads it is allocated less the threads it already runs.
 func (s *Service) MultiTiling(ctx context.Context, t time.Time) (ok error) {
 
 	message := fmt.Sprintf("Start MultiTilingModel manager: %s", t.String())
 	s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
 
 	startTime := time.Now()
-	for {
-
-		go func() {
-			select {
-			case res := <-s.robotCh:
-				if res {
-					s.running = !res
-				}
-			default:
-			}
-		}()
+	for s.isManaging(ctx, MultiTilingModel, startTime) {
 
-		isContinue := s.running && (time.Now().Sub(startTime) < s.restartTimeOut)
-		if !isContinue {
-			break
+		registerActivityList, ok := s.robotRepository.GetRegisterActivityList(ctx, s.cfg.InstanceId)
+		if ok != nil {
+			return ok
 		}
 
-		paramsManager := make(map[string]interface{}, 0)
-		paramsManager["cursor"] = s.cfg.MaxRobotGoroutines
-
-		registerActivityList, ok := s.robotRepository.GetRegisterActivityList(ctx)
+		groupList, ok := s.robotRepository.ProcessingGroupList(ctx)
 		if ok != nil {
 			return ok
 		}
 
-		groupList, ok := s.robotRepository.ProcessingGroupList(ctx)
+		demandList, ok := s.robotRepository.ProcessingGroupDemand(ctx, s.cfg.EventWindow)
 		if ok != nil {
 			return ok
 		}
 
+		running := make(map[int32]int)
+		for _, v := range registerActivityList {
+			running[v["group_id"].(int32)] += 1
+		}
+
+		demand := make(map[int32]map[string]interface{}, len(demandList))
+		for _, v := range demandList {
+			demand[v["group_id"].(int32)] = v
+		}
+
+		now := time.Now()
+		shares := make([]GroupShare, 0, len(groupList))
+		batches := make(map[int32]int, len(groupList))
 		for _, val := range groupList {
 
 			gpId := val["group_id"].(int32)
-			threadKey := PrefixKeyThreadManager + strconv.Itoa(int(gpId))
+			depth := int(util.ToInt64(demand[gpId]["depth"]))
+			s.reportGroup(gpId, depth, demand[gpId]["oldest_due_time"], now)
+
+			if enabled, _ := val["enabled"].(bool); !enabled {
+				continue
+			}
 
-			activityCount := 0
-			if s.managers[threadKey] == nil {
-				for _, v := range registerActivityList {
-					groupId := v["group_id"].(int32)
-					if gpId == groupId {
-						activityCount += 1
-					}
-				}
+			interval := time.Duration(util.ToInt64(val["poll_interval_seconds"])) * time.Second
+			if now.Sub(s.polled[gpId]) < interval {
+				continue
+			}
+
+			limit := s.cfg.MaxRobotGoroutines
+			if value := util.ToInt64(val["concurrency_limit"]); value > 0 {
+				limit = int(value)
+			}
 
-				if s.cfg.MaxRobotGoroutines >= activityCount {
+			shares = append(shares, GroupShare{
+				Id:     gpId,
+				Weight: float64(util.ToInt64(val["weight"])),
+				Min:    int(util.ToInt64(val["min_threads"])),
+				Limit:  limit,
+				Demand: running[gpId] + depth,
+			})
+			batches[gpId] = int(util.ToInt64(val["batch_size"]))
+		}
 
-					paramsManager["cursor"] = s.cfg.MaxRobotGoroutines - activityCount
-					paramsManager["group"] = gpId
+		allocation := s.fair.Allocate(s.pools.capacity(), shares)
 
-					manager := make(chan int) // канал для менеджера потоков
-					s.managers[threadKey] = manager
+		for _, share := range shares {
 
-					go s.TilingThreadManager(ctx, threadKey, manager, paramsManager)
+			gpId := share.Id
+			metrics.GroupThreads.WithLabelValues(groupLabel(gpId)).Set(float64(allocation[gpId]))
 
-					message := fmt.Sprintf("running managers: %d", len(s.managers))
-					s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
-				}
+			cursor := allocation[gpId] - running[gpId]
+			if free := s.pools.free(); cursor > free {
+				cursor = free
+			}
+			if cursor <= 0 {
+				continue
 			}
-		}
 
-		if len(registerActivityList) < s.cfg.MaxRobotGoroutines && len(s.managers) == 0 {
+			paramsManager := make(map[string]interface{}, 0)
+			paramsManager["cursor"] = cursor
+			paramsManager["group"] = gpId
+			paramsManager["batch"] = batches[gpId]
 
-		}
+			count, ok := s.TilingThreadManager(ctx, MultiTilingModel, paramsManager)
+			if ok != nil {
+				return ok
+			}
+			s.polled[gpId] = now
 
-		for _, data := range s.managers {
-			go func(ch chan int) {
-				select {
-				case item := <-ch:
-					message := fmt.Sprintf("manager data: %d", item)
-					s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
-				default:
-				}
-			}(data)
+			if count > 0 {
+				message := fmt.Sprintf("manager data: group %d, %d", gpId, count)
+				s.logger.LogMessage(ctx, v7.SystemMessage, message, v7.SystemIndex, util.EmptyDataStruct())
+			}
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		s.pause(ctx)
 	}
 
 	message = fmt.Sprintf("End Tiling manager: %d", time.Now().Sub(startTime))
@@ -407,62 +566,187 @@ func (s *Service) MultiTiling(ctx context.Context, t time.Time) (ok error) {
 	return ok
 }
 
-func (s *Service) TilingThreadManager(ctx context.Context, uid string, manager chan int, params map[string]interface{}) {
+// reportGroup exports the queue depth of the group and the wait of its oldest due order
+func (s *Service) reportGroup(gpId int32, depth int, oldest interface{}, now time.Time) {
 
-	defer func() {
-		delete(s.managers, uid)
-		defer close(manager)
-	}()
+	label := groupLabel(gpId)
+	metrics.GroupQueueDepth.WithLabelValues(label).Set(float64(depth))
+
+	wait := 0.0
+	if dueTime, ok := oldest.(time.Time); ok && dueTime.Before(now) {
+		wait = now.Sub(dueTime).Seconds()
+	}
+	metrics.GroupWaitSeconds.WithLabelValues(label).Set(wait)
+}
 
-	lotsOrdersNoGroup, ok := s.robotRepository.GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx, params)
+func groupLabel(gpId int32) string {
+	return strconv.Itoa(int(gpId))
+}
+
+// isManaging tells the Tiling managers to go on: until the restart timeout, Stop,
+// the loss of the leadership or a switch of the model
+func (s *Service) isManaging(ctx context.Context, model string, startTime time.Time) bool {
+	return ctx.Err() == nil && s.leader.IsLeader() && s.Model() == model && time.Now().Sub(startTime) < s.restartTimeOut
+}
+
+func (s *Service) pause(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TilingThreadManager queues the due lots of the group of params to the pools
+// and returns the number of lots queued
+func (s *Service) TilingThreadManager(ctx context.Context, model string, params map[string]interface{}) (int, error) {
+
+	lotsOrdersNoGroup, ok := s.robotRepository.GetOrderByLotsFromProcessingRegisterAndRegisterActivity(ctx, params, s.cfg.EventWindow)
 	if ok != nil {
-		s.zl.Sugar().Info(ok)
-		return
+		return 0, ok
 	}
-	lotsByStream, count := s.DivideLotsByOrders(lotsOrdersNoGroup, params)
-	for _, items := range lotsByStream {
-
-		uid := uuid.NewV4().String()
-
-		activity := make(map[int32]map[string]interface{}, 0)
-		for _, item := range items {
-			order := item["order_id"].(int32)
-			if activity[order] == nil {
-				itemMap := make(map[string]interface{})
-				itemMap["order_id"] = item["order_id"]
-				itemMap["thread_key"] = uid
-				itemMap["start_time"] = time.Now()
-				itemMap["thread_id"] = uid
-				itemMap["group_id"] = params["group"]
-				activity[order] = itemMap
-			}
-		}
 
-		activityData := make([]map[string]interface{}, 0)
-		for _, v := range activity {
-			activityData = append(activityData, v)
-		}
-		_, ok := s.robotRepository.UpdateProcessingActivity(ctx, activityData, "")
-		if ok != nil {
-			s.zl.Sugar().Info(ok)
-			return
+	return s.dispatch(ctx, model, lotsOrdersNoGroup, params)
+}
+
+// dispatch queues the lots to the pools of their node groups in up to cursor shards over all the pools,
+// to every pool no more than its free workers, of up to batch lots, if set, and returns the number of lots queued
+func (s *Service) dispatch(ctx context.Context, model string, lots []map[string]interface{}, params map[string]interface{}) (int, error) {
+
+	routed, err := s.routeLots(ctx, lots)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, _ := params["cursor"].(int)
+	batch, _ := params["batch"].(int)
+
+	share := &quota{left: cursor}
+	queued := 0
+	for _, p := range s.pools.list {
+
+		free := share.take(p)
+		if free <= 0 || len(routed[p]) == 0 {
+			continue
 		}
 
-		go func(data []map[string]interface{}, uid string) {
-			err := s.Shard_DoStepAndEvents(ctx, data, uid)
+		paramsPool := map[string]interface{}{"cursor": free}
+		lotsByStream, _ := s.DivideLotsByOrders(routed[p], paramsPool)
+		for _, items := range lotsByStream {
+
+			items = LimitBatch(items, batch)
+			uid := uuid.NewV4().String()
+
+			items, err := s.ClaimLots(ctx, items, uid, params["group"], p.name)
 			if err != nil {
-				s.zl.Sugar().Info(err)
+				return queued, err
+			}
+			if len(items) == 0 {
+				continue
 			}
-		}(items, uid)
+
+			item := shard{
+				threadKey: uid,
+				lots:      items,
+				model:     model,
+				pool:      p.name,
+				groupId:   runGroup(params["group"]),
+			}
+			if c := cycleFrom(ctx); c != nil {
+				item.cycleId = c.id
+			}
+
+			if !s.submit(ctx, p, item) {
+				return queued, ctx.Err()
+			}
+			share.used(1)
+			queued += len(items)
+		}
 	}
 
-	manager <- count
+	return queued, nil
+}
+
+// routeLots assigns the lots to the pools by the group_id of their current nodes
+func (s *Service) routeLots(ctx context.Context, lots []map[string]interface{}) (map[*pool][]map[string]interface{}, error) {
+
+	if !s.pools.dedicated() || len(lots) == 0 {
+		return map[*pool][]map[string]interface{}{s.pools.main: lots}, nil
+	}
+
+	lotIds := make([]int64, 0, len(lots))
+	for _, lot := range lots {
+		lotIds = append(lotIds, util.ToInt64(lot["lot_id"]))
+	}
 
+	rows, err := s.robotRepository.LotNodeGroups(ctx, lotIds)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeGroups := make(map[int64]int32, len(rows))
+	for _, row := range rows {
+		nodeGroups[util.ToInt64(row["lot_id"])] = int32(util.ToInt64(row["group_id"]))
+	}
+
+	return s.pools.route(lots, nodeGroups), nil
+}
+
+// PoolStates returns the load of the default pool and the pools of the node groups
+func (s *Service) PoolStates() []PoolState {
+
+	if s.pools == nil {
+		return make([]PoolState, 0)
+	}
+
+	return s.pools.states()
+}
+
+// ClaimLots leases the orders of the lots to the thread of the pool and returns the lots of the claimed orders,
+// orders leased by other replicas are left to them. The lease is released when the thread is done
+// or expires after LeaseDuration, then another replica takes the orders over.
+func (s *Service) ClaimLots(ctx context.Context, lots []map[string]interface{}, threadKey string, groupId interface{}, pool string) ([]map[string]interface{}, error) {
+
+	var orderIds, lotIds []int64
+	seen := make(map[int64]bool)
+	for _, lot := range lots {
+		orderIds = append(orderIds, util.ToInt64(lot["order_id"]))
+		lotIds = append(lotIds, util.ToInt64(lot["lot_id"]))
+		seen[util.ToInt64(lot["order_id"])] = true
+	}
+
+	claimed, err := s.robotRepository.ClaimOrders(ctx, orderIds, lotIds, threadKey, groupId, pool, s.cfg.InstanceId, s.cfg.LeaseDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	own := make(map[int64]bool, len(claimed))
+	for _, orderId := range claimed {
+		own[orderId] = true
+	}
+
+	result := make([]map[string]interface{}, 0, len(lots))
+	for _, lot := range lots {
+		if own[util.ToInt64(lot["order_id"])] {
+			result = append(result, lot)
+		}
+	}
+
+	if len(claimed) < len(seen) {
+		s.zl.Sugar().Debug(fmt.Sprintf("Orders leased by other threads: %d", len(seen)-len(claimed)))
+	}
+
+	return result, nil
 }
 
 func (s *Service) Shard_DoStepAndEvents(ctx context.Context, data []map[string]interface{}, uid string) (result error) {
 
-	result = s.DoStepAndEvents(ctx, data)
+	work, stop := s.Heartbeat(ctx, uid)
+	result = s.DoStepAndEvents(withLease(work, uid), data)
+
+	if lost := stop(); lost != nil {
+		metrics.ActivityLeaseLost.Inc()
+		return fmt.Errorf("thread %s: %w", uid, lost)
+	}
 	if result != nil {
 		return result
 	}
@@ -472,6 +756,54 @@ func (s *Service) Shard_DoStepAndEvents(ctx context.Context, data []map[string]i
 	return result
 }
 
+// Heartbeat extends the leases of the thread every HeartbeatInterval until the returned stop is called,
+// the leases of a dead thread expire after LeaseDuration and are released by the reaper.
+// The steps run with the returned context, it is cancelled once the leases are found reclaimed
+// and stop returns ErrLeaseLost.
+func (s *Service) Heartbeat(ctx context.Context, threadKey string) (context.Context, func() error) {
+
+	renew := func(ctx context.Context) (int, error) {
+		return s.robotRepository.Heartbeat(ctx, threadKey, s.cfg.InstanceId, s.cfg.LeaseDuration)
+	}
+
+	failed := func(err error) {
+		metrics.ActivityHeartbeatErrors.Inc()
+		s.zl.Sugar().Error(fmt.Errorf("heartbeat of thread %s: %w", threadKey, err))
+	}
+
+	return keepLease(ctx, s.cfg.HeartbeatInterval, renew, failed)
+}
+
+// inStep runs a step of the lot in a transaction that commits only while the thread still holds the lease
+// of the lot, a step of a thread whose lease was reclaimed is rolled back with ErrLeaseLost.
+// The transitions of the step count for the run log once it commits.
+func (s *Service) inStep(ctx context.Context, lotId interface{}, fn func(ctx context.Context) error) (err error) {
+
+	ctx, done := withStep(ctx)
+	defer func() { done(err) }()
+
+	return s.robotRepository.RootRepository.InTransaction(ctx, func(ctx context.Context) error {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+
+		threadKey, ok := leaseFrom(ctx)
+		if !ok {
+			return nil
+		}
+
+		held, err := s.robotRepository.HoldsLease(ctx, threadKey, s.cfg.InstanceId, util.ToInt64(lotId))
+		if err != nil {
+			return err
+		}
+		if !held {
+			return fmt.Errorf("%w: thread %s, lot %d", ErrLeaseLost, threadKey, util.ToInt64(lotId))
+		}
+
+		return nil
+	})
+}
+
 func (s *Service) DoStepAndEvents(ctx context.Context, lots []map[string]interface{}) (result error) {
 
 	result = s.DoIncomingEvents(ctx, lots)
@@ -484,7 +816,7 @@ func (s *Service) DoStepAndEvents(ctx context.Context, lots []map[string]interfa
 
 func (s *Service) DoIncomingEvents(ctx context.Context, lots []map[string]interface{}) error {
 
-	events, ok := s.robotRepository.FindEventsPerStep(ctx, lots)
+	events, ok := s.robotRepository.FindEventsPerStep(ctx, lots, s.cfg.EventWindow)
 	if ok != nil {
 		s.zl.Sugar().Error(ok)
 		return ok
@@ -492,21 +824,130 @@ func (s *Service) DoIncomingEvents(ctx context.Context, lots []map[string]interf
 
 	for _, event := range events {
 		nodeId := event["node_id"].(int64)
-		ok := s.RecordToNextStep(ctx, event, nodeId)
+		ok := s.inStep(ctx, event["lot_id"], func(ctx context.Context) error {
+			if err := s.RecordToNextStep(ctx, event, nodeId); err != nil {
+				return err
+			}
+
+			_, err := s.robotRepository.ConsumeSemaphore(ctx, event["semaphore_id"])
+			return err
+		})
 		if ok != nil {
+			s.zl.Sugar().Error(ok)
 			return ok
 		}
 	}
 
-	return ok
+	return s.DoTriggers(ctx, lots)
 }
 
+// DoTriggers records incoming events in the state of trigger nodes waiting for a combination
+// of events and moves the lots whose trigger conditions are satisfied.
+// The state persists across ticks until the lot enters the trigger node.
+func (s *Service) DoTriggers(ctx context.Context, lots []map[string]interface{}) error {
+
+	events, err := s.robotRepository.FindTriggerEvents(ctx, lots, s.cfg.EventWindow)
+	if err != nil {
+		s.zl.Sugar().Error(err)
+		return err
+	}
+
+	type lotNode struct {
+		lotId  int64
+		nodeId int64
+	}
+
+	var order []lotNode
+	touched := make(map[lotNode]map[string]interface{})
+	for _, event := range events {
+		if err := s.robotRepository.RecordTriggerEvent(ctx, event); err != nil {
+			s.zl.Sugar().Error(err)
+			return err
+		}
+
+		key := lotNode{util.ToInt64(event["lot_id"]), util.ToInt64(event["node_id"])}
+		if _, ok := touched[key]; !ok {
+			order = append(order, key)
+		}
+		touched[key] = event
+	}
+
+	moved := make(map[int64]bool)
+	for _, key := range order {
+		if moved[key.lotId] {
+			continue
+		}
+
+		state, err := s.lotRepository.TriggerState(ctx, key.lotId, key.nodeId)
+		if err != nil {
+			return err
+		}
+		if len(state) == 0 {
+			continue
+		}
+
+		condition, received := trigger.FromState(state[0])
+		satisfied, _, err := trigger.Evaluate(condition, received)
+		if err != nil {
+			s.zl.Sugar().Error(fmt.Errorf("trigger node %d: %w", key.nodeId, err))
+			continue
+		}
+		if !satisfied {
+			continue
+		}
+
+		err = s.inStep(ctx, key.lotId, func(ctx context.Context) error {
+			if err := s.RecordToNextStep(ctx, touched[key], key.nodeId); err != nil {
+				return err
+			}
+
+			return s.robotRepository.ClearTriggerState(ctx, key.lotId, key.nodeId)
+		})
+		if err != nil {
+			return err
+		}
+		moved[key.lotId] = true
+	}
+
+	return nil
+}
+
+// RecordToNextStep moves the lot to the node and writes the step history and the webhook deliveries,
+// called within a step transaction they are committed together with the move
 func (s *Service) RecordToNextStep(ctx context.Context, data map[string]interface{}, nodeId int64) (ok error) {
 
 	_, ok = s.robotRepository.UpdateProcessing(ctx, data, nodeId)
 	//s.zl.Sugar().Info(data, updated)
 	if ok != nil {
 		s.zl.Sugar().Error(ok)
+		return ok
+	}
+	countTransition(ctx)
+
+	notification := make(map[string]interface{})
+	notification["lot_id"] = data["lot_id"]
+	notification["node_id"] = nodeId
+	notification["prev_node_id"] = data["node_id"]
+	if prevId, ok := data["prev_id"]; ok {
+		notification["prev_node_id"] = prevId
+	}
+	notification["event_type_id"] = data["event_type_id"]
+
+	step := make(map[string]interface{})
+	step["lot_id"] = data["lot_id"]
+	step["node_id"] = nodeId
+	step["prev_node_id"] = notification["prev_node_id"]
+	step["attempt"] = util.ToInt64(data["attempt"])
+	step["idempotency_key"] = data["idempotency_key"]
+	step["event_type_id"] = data["event_type_id"]
+
+	if _, ok = s.robotRepository.RecordStep(ctx, step); ok != nil {
+		s.zl.Sugar().Error(ok)
+		return ok
+	}
+
+	if err := s.webhook.Notify(ctx, webhook.KindNodeEntered, notification); err != nil {
+		s.zl.Sugar().Error(err)
 	}
 
 	return ok
@@ -520,7 +961,17 @@ func (s *Service) DoNextStep(ctx context.Context, lots []map[string]interface{})
 	}
 
 	for _, lot := range results {
-		ok = s.DoNextStepQuery(ctx, lot)
+		if lot["type"] == action {
+			// the action runs its handler outside of a transaction and applies the outcome in its own
+			ok = s.DoAction(ctx, lot)
+		} else {
+			ok = s.inStep(ctx, lot["lot_id"], func(ctx context.Context) error {
+				return s.DoNextStepQuery(ctx, lot)
+			})
+		}
+		if errors.Is(ok, ErrLeaseLost) {
+			return ok
+		}
 	}
 
 	return ok
@@ -533,11 +984,9 @@ func (s *Service) DoNextStepQuery(ctx context.Context, data map[string]interface
 
 	switch t {
 	case action:
-		_action := data["action"]
-		err = s.DoAction(ctx, _action, data)
-		if err == nil {
-			err = s.StepToNextNode(ctx, data)
-		}
+		return s.DoAction(ctx, data)
+	case decision:
+		return s.DoDecision(ctx, data)
 	case wait:
 		w := data["waiting_time"].(int32)
 		e := data["entry_time"].(time.Time)
@@ -560,9 +1009,124 @@ func (s *Service) DoNextStepQuery(ctx context.Context, data map[string]interface
 	return err
 }
 
-func (s *Service) DoAction(ctx context.Context, a interface{}, data map[string]interface{}) error {
-	action := a.(string)
-	return s.InvokeAction(ctx, action, data)
+// DoAction runs the handler of the node action, stores the variable updates
+// and moves the lot according to the outcome, a lot waiting for an asynchronous action
+// is handled by DoPending instead.
+// Handle of the handler makes the external call outside of the step transaction, so it holds neither
+// a connection nor the locks of the lot. The database side of the action, its Apply, and the outcome
+// are applied by ApplyAction in the step transaction, the attempt is counted only if it commits,
+// so an action run again after a failure gets the same idempotency key and the same pending token.
+func (s *Service) DoAction(ctx context.Context, data map[string]interface{}) error {
+
+	in, err := NewActionInput(data)
+	if err != nil {
+		return err
+	}
+
+	waits, err := s.robotRepository.ActionWait(ctx, in.LotId, in.NodeId, data["entry_time"])
+	if err != nil {
+		return err
+	}
+
+	if len(waits) > 0 {
+		return s.inStep(ctx, data["lot_id"], func(ctx context.Context) error {
+			return s.DoPending(ctx, data, waits[0])
+		})
+	}
+
+	in.Attempt = util.ToInt64(data["attempt"])
+	in.IdempotencyKey = IdempotencyKey(in.LotId, in.NodeId, in.Attempt)
+	in.Token = ActionToken(s.cfg.Actions.TokenSecret, in.IdempotencyKey)
+	data["idempotency_key"] = in.IdempotencyKey
+
+	out, err := s.InvokeAction(ctx, in)
+
+	var deferred *DeferredError
+	if errors.As(err, &deferred) {
+		return s.Defer(ctx, data, deferred)
+	}
+	if err != nil {
+		countActionError(ctx)
+		return err
+	}
+
+	return s.inStep(ctx, data["lot_id"], func(ctx context.Context) error {
+		return s.ApplyAction(ctx, data, in, out)
+	})
+}
+
+// ApplyAction runs the database side of the handler, stores the variable updates of the action
+// and moves the lot according to the outcome, all of it in the step transaction of the context.
+// The outcome applies only while the lot is still on the node with the attempt of the run,
+// an outcome of a lot moved or run again meanwhile is dropped before the handler writes anything.
+func (s *Service) ApplyAction(ctx context.Context, data map[string]interface{}, in ActionInput, out ActionOutput) error {
+
+	counted, err := s.robotRepository.IncrementAttempt(ctx, data["proc_id"], in.NodeId, in.Attempt)
+	if err != nil {
+		return err
+	}
+	if counted == 0 {
+		message := fmt.Sprintf("Outcome of action %s dropped: lot %d left node %s or attempt %d", in.Action, in.LotId, in.NodeName, in.Attempt)
+		s.zl.Sugar().Warn(message)
+		return nil
+	}
+
+	handler, err := s.registry.Handler(in.Action)
+	if err != nil {
+		return err
+	}
+
+	out, err = Apply(ctx, handler, in, out)
+	if err != nil {
+		countActionError(ctx)
+		return err
+	}
+
+	if err := s.SaveVariables(ctx, in.LotId, out.Variables); err != nil {
+		return err
+	}
+
+	switch out.Outcome {
+	case "", OutcomeNext:
+		return s.StepToNextNode(ctx, data)
+	case OutcomeStay:
+		return nil
+	case OutcomePending:
+		return s.StartPending(ctx, data, in, out)
+	}
+
+	return fmt.Errorf("%w: %s returned %s", ErrUnknownOutcome, in.Action, out.Outcome)
+}
+
+// Defer puts the step of the lot off until the limit or the breaker of its action lets it run
+func (s *Service) Defer(ctx context.Context, data map[string]interface{}, deferred *DeferredError) error {
+
+	if _, err := s.robotRepository.DeferProcessing(ctx, data["proc_id"], deferred.Until); err != nil {
+		return err
+	}
+
+	s.zl.Sugar().Debug(fmt.Sprintf("Lot %v: %s", data["lot_id"], deferred))
+
+	return nil
+}
+
+// GuardStates returns the rate limits and the circuit breakers of the actions of the instance
+func (s *Service) GuardStates() []GuardState {
+	return s.guards.States()
+}
+
+// SaveVariables applies the patch returned by an action to the lot variables
+func (s *Service) SaveVariables(ctx context.Context, lotId int64, patch map[string]interface{}) error {
+
+	if len(patch) == 0 {
+		return nil
+	}
+
+	_, err := s.lotRepository.UpdateVariables(ctx, lotId, func(current map[string]interface{}) (map[string]interface{}, error) {
+		return variables.Patch(current, patch), nil
+	})
+
+	return err
 }
 
 func (s *Service) StepToNextNode(ctx context.Context, data map[string]interface{}) error {
@@ -617,30 +1181,127 @@ func (s *Service) Terminate(ctx context.Context, data map[string]interface{}) er
 		return err
 	}
 
-	return s.robotRepository.RootRepository.Delete(ctx, _sql, args...)
+	err = s.robotRepository.RootRepository.Delete(ctx, _sql, args...)
+	if err != nil {
+		return err
+	}
+
+	err = s.robotRepository.ClearTriggerState(ctx, data["lot_id"], nil)
+	if err != nil {
+		return err
+	}
+
+	notification := make(map[string]interface{})
+	notification["lot_id"] = data["lot_id"]
+	notification["node_id"] = data["node_id"]
+
+	if err := s.webhook.Notify(ctx, webhook.KindLotTerminated, notification); err != nil {
+		s.zl.Sugar().Error(err)
+	}
+
+	return nil
 }
 
-func (s *Service) InvokeAction(ctx context.Context, name string, data map[string]interface{}) error {
+// InvokeAction runs the handler of the action through its rate limit and circuit breaker,
+// a DeferredError means the action did not run
+func (s *Service) InvokeAction(ctx context.Context, in ActionInput) (ActionOutput, error) {
 
-	var args []interface{}
-	args = append(args, ctx)
-	args = append(args, data)
+	handler, err := s.registry.Handler(in.Action)
+	if err != nil {
+		return ActionOutput{}, err
+	}
 
-	inputs := make([]reflect.Value, len(args))
-	for i := range args {
-		inputs[i] = reflect.ValueOf(args[i])
+	if err := ValidateParams(handler, in.Params); err != nil {
+		return ActionOutput{}, fmt.Errorf("node %s: %w", in.NodeName, err)
 	}
 
-	t := s.action
-	values := reflect.ValueOf(t).MethodByName(name).Call(inputs)
-	err := values[0].Interface()
+	return s.guards.Invoke(ctx, in.Action, func() (ActionOutput, error) {
+		return handler.Handle(ctx, in)
+	})
+}
+
+// ValidateActions checks that every action of the map nodes is registered
+// with node params matching its schema and that the nodes are configured
+// for handlers implementing NodeValidator
+func (s *Service) ValidateActions(ctx context.Context) error {
+
+	nodes, err := s.robotRepository.ActionNodes(ctx)
 	if err != nil {
-		return err.(error)
+		return err
+	}
+
+	var names []string
+	for _, node := range nodes {
+		names = append(names, fmt.Sprint(node["action"]))
+	}
+
+	missing := s.registry.Missing(names)
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %v", ErrUnknownAction, missing)
+	}
+
+	for _, node := range nodes {
+		handler, err := s.registry.Handler(fmt.Sprint(node["action"]))
+		if err != nil {
+			return err
+		}
+
+		params, err := util.ToMap(node["params"])
+		if err != nil {
+			return err
+		}
+
+		if err := ValidateParams(handler, params); err != nil {
+			return fmt.Errorf("node %v: %w", node["name"], err)
+		}
+
+		if validator, ok := handler.(NodeValidator); ok {
+			if err := validator.ValidateNode(ctx, util.ToInt64(node["id"])); err != nil {
+				return fmt.Errorf("node %v: %w", node["name"], err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// LimitBatch keeps the lots of whole orders, in the order of the lots, up to batch lots
+// and at least the first order. The lots left out are due on the next poll.
+func LimitBatch(lots []map[string]interface{}, batch int) []map[string]interface{} {
+
+	if batch <= 0 || len(lots) <= batch {
+		return lots
+	}
+
+	count := make(map[interface{}]int)
+	var orders []interface{}
+	for _, lot := range lots {
+		if count[lot["order_id"]] == 0 {
+			orders = append(orders, lot["order_id"])
+		}
+		count[lot["order_id"]] += 1
+	}
+
+	keep := make(map[interface{}]bool)
+	total := 0
+	for i, orderId := range orders {
+		if i > 0 && total+count[orderId] > batch {
+			break
+		}
+		keep[orderId] = true
+		total += count[orderId]
+	}
+
+	result := make([]map[string]interface{}, 0, total)
+	for _, lot := range lots {
+		if keep[lot["order_id"]] {
+			result = append(result, lot)
+		}
+	}
+
+	return result
+}
+
 func (s *Service) DivideLotsByOrders(lotsOrdersNoGroup []map[string]interface{}, params map[string]interface{}) ([][]map[string]interface{}, int) {
 
 	lotsByStream := make([][]map[string]interface{}, 0)
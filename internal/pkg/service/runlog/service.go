@@ -0,0 +1,162 @@
+package runlog
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/repository/runlog"
+)
+
+const (
+	KindCycle  = "cycle"
+	KindThread = "thread"
+
+	DefaultListLimit = 100
+	DefaultBucket    = "hour"
+)
+
+var (
+	ErrInvalidKind   = errors.New("run log kind must be cycle or thread")
+	ErrInvalidBucket = errors.New("run log bucket must be minute, hour or day")
+)
+
+var buckets = map[string]bool{"minute": true, "hour": true, "day": true}
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository *runlog.Repository
+}
+
+// Run is a row of the run log: a cycle of a model on the instance or a thread of the cycle.
+// Transitions and ActionErrors are counted by the threads, they are nil for a cycle.
+type Run struct {
+	Kind         string
+	CycleId      string
+	ThreadKey    string
+	Model        string
+	GroupId      *int64
+	Pool         string
+	Threads      int
+	Orders       int
+	Lots         int
+	Transitions  *int64
+	ActionErrors *int64
+	Err          error
+	Start        time.Time
+	End          time.Time
+}
+
+// Query filters the run log, zero fields do not filter, From and To bound start_time
+type Query struct {
+	Kind       string    `json:"kind"`
+	CycleId    string    `json:"cycle_id"`
+	InstanceId string    `json:"instance_id"`
+	Model      string    `json:"model"`
+	GroupId    *int64    `json:"group_id"`
+	Pool       string    `json:"pool"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Limit      uint64    `json:"limit"`
+	Bucket     string    `json:"bucket"`
+}
+
+func NewService(cfg *oms.Config, r *runlog.Repository, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+	}
+}
+
+// Record saves the run if the run log is on
+func (s *Service) Record(ctx context.Context, run Run) error {
+
+	if !s.cfg.RunLog {
+		return nil
+	}
+
+	values := map[string]interface{}{
+		"kind":          run.Kind,
+		"cycle_id":      run.CycleId,
+		"instance_id":   s.cfg.InstanceId,
+		"model":         run.Model,
+		"group_id":      run.GroupId,
+		"threads":       run.Threads,
+		"orders":        run.Orders,
+		"lots":          run.Lots,
+		"transitions":   run.Transitions,
+		"action_errors": run.ActionErrors,
+		"start_time":    run.Start,
+		"end_time":      run.End,
+		"duration_ms":   run.End.Sub(run.Start).Milliseconds(),
+	}
+	if len(run.ThreadKey) > 0 {
+		values["thread_key"] = run.ThreadKey
+	}
+	if len(run.Pool) > 0 {
+		values["pool"] = run.Pool
+	}
+	if run.Err != nil {
+		values["error"] = run.Err.Error()
+	}
+
+	_, err := s.repository.SaveRun(ctx, values)
+
+	return err
+}
+
+// List returns the latest cycles and threads of the query
+func (s *Service) List(ctx context.Context, q Query) ([]map[string]interface{}, error) {
+
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Limit == 0 {
+		q.Limit = DefaultListLimit
+	}
+
+	return s.repository.RunList(ctx, filter, q.Limit)
+}
+
+// Stats returns the throughput of the threads of the query by model and bucket of time
+func (s *Service) Stats(ctx context.Context, q Query) ([]map[string]interface{}, error) {
+
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(q.Bucket) == 0 {
+		q.Bucket = DefaultBucket
+	}
+	if !buckets[q.Bucket] {
+		return nil, ErrInvalidBucket
+	}
+
+	return s.repository.RunStats(ctx, filter, q.Bucket)
+}
+
+func (q Query) filter() (runlog.Filter, error) {
+
+	if len(q.Kind) > 0 && q.Kind != KindCycle && q.Kind != KindThread {
+		return runlog.Filter{}, ErrInvalidKind
+	}
+
+	return runlog.Filter{
+		Kind:       q.Kind,
+		CycleId:    q.CycleId,
+		InstanceId: q.InstanceId,
+		Model:      q.Model,
+		GroupId:    q.GroupId,
+		Pool:       q.Pool,
+		From:       q.From,
+		To:         q.To,
+	}, nil
+}
@@ -0,0 +1,387 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/repository/lot"
+	"oms2/internal/pkg/repository/webhook"
+	"oms2/internal/pkg/util"
+)
+
+const (
+	KindNodeEntered   = "node_entered"
+	KindLotTerminated = "lot_terminated"
+	KindEventReceived = "event_received"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+const (
+	HeaderSignature = "X-OMS2-Signature"
+	HeaderDelivery  = "X-OMS2-Delivery"
+	HeaderKind      = "X-OMS2-Kind"
+
+	signaturePrefix = "sha256="
+)
+
+var Kinds = []string{KindNodeEntered, KindLotTerminated, KindEventReceived}
+
+var (
+	ErrInvalidUrl    = errors.New("webhook url must be an absolute http(s) url")
+	ErrEmptySecret   = errors.New("webhook secret is required")
+	ErrUnknownKind   = errors.New("unknown webhook kind")
+	ErrEmptyPatch    = errors.New("nothing to update")
+	ErrNotFound      = errors.New("webhook not found")
+	defaultListLimit = uint64(100)
+)
+
+// store is the part of the repository keeping the subscriptions and the deliveries
+type store interface {
+	CreateSubscription(ctx context.Context, data map[string]interface{}) (uint, error)
+	UpdateSubscription(ctx context.Context, id int64, data map[string]interface{}) (uint, error)
+	DeleteSubscription(ctx context.Context, id int64) error
+	SubscriptionList(ctx context.Context) ([]map[string]interface{}, error)
+	MatchSubscriptions(ctx context.Context, kind string, nodeId interface{}, eventTypeId interface{}) ([]map[string]interface{}, error)
+	CreateDelivery(ctx context.Context, data map[string]interface{}) (uint, error)
+	ClaimDueDeliveries(ctx context.Context, status string, limit uint64, lease time.Duration) ([]map[string]interface{}, error)
+	UpdateDelivery(ctx context.Context, id int64, data map[string]interface{}) (uint, error)
+	DeliveryList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error)
+}
+
+var _ store = (*webhook.Repository)(nil)
+
+type Service struct {
+	zl         *zap.Logger
+	cfg        *oms.Config
+	repository store
+	lots       *lot.Repository
+	client     *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(cfg *oms.Config, r *webhook.Repository, lots *lot.Repository, zl *zap.Logger) *Service {
+	return &Service{
+		zl:         zl,
+		cfg:        cfg,
+		repository: r,
+		lots:       lots,
+		client:     &http.Client{Timeout: cfg.Webhook.Timeout},
+	}
+}
+
+func (s *Service) Start(_ context.Context) error {
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.Webhook.DispatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Dispatch(s.ctx); err != nil {
+					s.zl.Sugar().Error(err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *Service) Stop(_ context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	return nil
+}
+
+func (s *Service) CreateSubscription(ctx context.Context, data map[string]interface{}) (uint, error) {
+
+	if err := ValidateSubscription(data, true); err != nil {
+		return 0, err
+	}
+
+	return s.repository.CreateSubscription(ctx, data)
+}
+
+func (s *Service) UpdateSubscription(ctx context.Context, id int64, data map[string]interface{}) (uint, error) {
+
+	if len(data) == 0 {
+		return 0, ErrEmptyPatch
+	}
+
+	if err := ValidateSubscription(data, false); err != nil {
+		return 0, err
+	}
+
+	updated, err := s.repository.UpdateSubscription(ctx, id, data)
+	if err == nil && updated == 0 {
+		return 0, ErrNotFound
+	}
+
+	return updated, err
+}
+
+func (s *Service) DeleteSubscription(ctx context.Context, id int64) error {
+	return s.repository.DeleteSubscription(ctx, id)
+}
+
+func (s *Service) SubscriptionList(ctx context.Context) ([]map[string]interface{}, error) {
+	return s.repository.SubscriptionList(ctx)
+}
+
+func (s *Service) DeliveryList(ctx context.Context, params map[string]interface{}, limit uint64) ([]map[string]interface{}, error) {
+
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+
+	return s.repository.DeliveryList(ctx, params, limit)
+}
+
+// ValidateSubscription checks subscription fields, on create url and secret are required
+func ValidateSubscription(data map[string]interface{}, create bool) error {
+
+	if value, ok := data["url"]; ok || create {
+		str, _ := value.(string)
+		u, err := url.Parse(str)
+		if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+			return ErrInvalidUrl
+		}
+	}
+
+	if value, ok := data["secret"]; ok || create {
+		if str, _ := value.(string); len(str) == 0 {
+			return ErrEmptySecret
+		}
+	}
+
+	if value, ok := data["kind"]; ok && value != nil {
+		known := false
+		for _, kind := range Kinds {
+			known = known || kind == value
+		}
+		if !known {
+			return fmt.Errorf("%w: %v", ErrUnknownKind, value)
+		}
+	}
+
+	return nil
+}
+
+// Notify enqueues a delivery for every subscription matching the notification.
+// data must contain lot_id and node_id, event_type_id is optional.
+// Current variables of the lot are added to the payload.
+func (s *Service) Notify(ctx context.Context, kind string, data map[string]interface{}) error {
+
+	subscriptions, err := s.repository.MatchSubscriptions(ctx, kind, data["node_id"], data["event_type_id"])
+	if err != nil {
+		return err
+	}
+
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload := make(map[string]interface{})
+	for key, value := range data {
+		payload[key] = value
+	}
+	payload["kind"] = kind
+	payload["occurred_at"] = time.Now().Format(time.RFC3339Nano)
+
+	lots, err := s.lots.Lot(ctx, data["lot_id"])
+	if err != nil {
+		return err
+	}
+
+	for _, l := range lots {
+		payload["variables"] = l["variables"]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		delivery := make(map[string]interface{})
+		delivery["subscription_id"] = subscription["id"]
+		delivery["kind"] = kind
+		delivery["lot_id"] = data["lot_id"]
+		delivery["node_id"] = data["node_id"]
+		delivery["payload"] = string(body)
+		delivery["status"] = StatusPending
+
+		if _, err := s.repository.CreateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Dispatch sends deliveries whose next attempt is due
+func (s *Service) Dispatch(ctx context.Context) error {
+
+	deliveries, err := s.repository.ClaimDueDeliveries(ctx, StatusPending, uint64(s.cfg.Webhook.BatchSize), 2*s.cfg.Webhook.Timeout)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if err := s.deliver(ctx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) deliver(ctx context.Context, delivery map[string]interface{}) error {
+
+	id := delivery["id"].(int64)
+	attempts := int(delivery["attempts"].(int32)) + 1
+
+	body, err := util.ToJSON(delivery["payload"])
+	if err != nil {
+		return err
+	}
+
+	code, sendErr := s.Send(ctx,
+		delivery["url"].(string),
+		delivery["secret"].(string),
+		strconv.FormatInt(id, 10),
+		delivery["kind"].(string),
+		body)
+
+	values := make(map[string]interface{})
+	values["attempts"] = attempts
+	if code != 0 {
+		values["response_code"] = code
+	}
+
+	switch {
+	case sendErr == nil:
+		values["status"] = StatusDelivered
+		values["delivered_at"] = time.Now()
+		values["last_error"] = nil
+	case attempts >= s.cfg.Webhook.MaxAttempts:
+		values["status"] = StatusFailed
+		values["last_error"] = sendErr.Error()
+	default:
+		values["next_attempt_time"] = time.Now().Add(Backoff(s.cfg.Webhook.BackoffBase, s.cfg.Webhook.BackoffMax, attempts))
+		values["last_error"] = sendErr.Error()
+	}
+
+	if sendErr != nil {
+		s.zl.Sugar().Info(fmt.Sprintf("webhook delivery %d attempt %d: %s", id, attempts, sendErr))
+	}
+
+	_, err = s.repository.UpdateDelivery(ctx, id, values)
+
+	return err
+}
+
+// Redeliver puts a delivery back to the queue regardless of its current status
+func (s *Service) Redeliver(ctx context.Context, id int64) error {
+
+	values := make(map[string]interface{})
+	values["status"] = StatusPending
+	values["attempts"] = 0
+	values["next_attempt_time"] = time.Now()
+	values["last_error"] = nil
+
+	updated, err := s.repository.UpdateDelivery(ctx, id, values)
+	if err == nil && updated == 0 {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// Send posts the signed body to url. Any status outside 2xx is an error.
+func (s *Service) Send(ctx context.Context, url string, secret string, deliveryId string, kind string, body []byte) (int, error) {
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderSignature, Sign(secret, body))
+	request.Header.Set(HeaderDelivery, deliveryId)
+	request.Header.Set(HeaderKind, kind)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("unexpected response status: %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Sign returns the value of the signature header: hex encoded HMAC-SHA256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header on the receiver side
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Backoff returns the delay before the next attempt: base doubled on every attempt, capped by max
+func Backoff(base time.Duration, max time.Duration, attempt int) time.Duration {
+
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	if delay > max {
+		return max
+	}
+
+	return delay
+}
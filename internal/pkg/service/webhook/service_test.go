@@ -0,0 +1,335 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"oms2/internal/oms"
+	"oms2/internal/pkg/config"
+)
+
+func newTestService() *Service {
+	cfg := &oms.Config{Webhook: config.Webhook{Timeout: 5 * time.Second}}
+	return NewService(cfg, nil, nil, zap.NewNop())
+}
+
+func TestService_Send(t *testing.T) {
+
+	secret := "secret"
+	body := []byte(`{"kind":"node_entered","lot_id":1,"node_id":3}`)
+
+	var received *http.Request
+	var receivedBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	code, err := newTestService().Send(context.Background(), receiver.URL, secret, "42", KindNodeEntered, body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, code)
+
+	require.Equal(t, body, receivedBody)
+	require.Equal(t, "42", received.Header.Get(HeaderDelivery))
+	require.Equal(t, KindNodeEntered, received.Header.Get(HeaderKind))
+	require.True(t, Verify(secret, receivedBody, received.Header.Get(HeaderSignature)))
+	require.False(t, Verify("other", receivedBody, received.Header.Get(HeaderSignature)))
+}
+
+func TestService_SendUnexpectedStatus(t *testing.T) {
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	code, err := newTestService().Send(context.Background(), receiver.URL, "secret", "1", KindNodeEntered, []byte(`{}`))
+	require.Error(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestBackoff(t *testing.T) {
+
+	base := 5 * time.Second
+	max := time.Minute
+
+	require.Equal(t, 5*time.Second, Backoff(base, max, 1))
+	require.Equal(t, 10*time.Second, Backoff(base, max, 2))
+	require.Equal(t, 40*time.Second, Backoff(base, max, 4))
+	require.Equal(t, time.Minute, Backoff(base, max, 5))
+	require.Equal(t, time.Minute, Backoff(base, max, 100))
+}
+
+// memoryDeliveries keeps the deliveries like _InfoReg_WD, a claimed delivery is leased
+// by its next_attempt_time like in ClaimDueDeliveries
+type memoryDeliveries struct {
+	store
+
+	mu         sync.Mutex
+	deliveries map[int64]map[string]interface{}
+	url        string
+}
+
+func newMemoryDeliveries(url string, count int) *memoryDeliveries {
+
+	m := &memoryDeliveries{deliveries: make(map[int64]map[string]interface{}), url: url}
+	for id := int64(1); id <= int64(count); id++ {
+		m.deliveries[id] = map[string]interface{}{
+			"id":                id,
+			"subscription_id":   int64(1),
+			"kind":              KindNodeEntered,
+			"payload":           map[string]interface{}{"lot_id": id},
+			"status":            StatusPending,
+			"attempts":          int32(0),
+			"next_attempt_time": time.Now(),
+		}
+	}
+
+	return m
+}
+
+func (m *memoryDeliveries) ClaimDueDeliveries(_ context.Context, status string, limit uint64, lease time.Duration) ([]map[string]interface{}, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int64
+	for id := range m.deliveries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	now := time.Now()
+	var claimed []map[string]interface{}
+	for _, id := range ids {
+		d := m.deliveries[id]
+		if d["status"] != status || d["next_attempt_time"].(time.Time).After(now) || uint64(len(claimed)) >= limit {
+			continue
+		}
+		d["next_attempt_time"] = now.Add(lease)
+
+		claimed = append(claimed, map[string]interface{}{
+			"id":              id,
+			"subscription_id": d["subscription_id"],
+			"kind":            d["kind"],
+			"payload":         d["payload"],
+			"attempts":        d["attempts"],
+			"url":             m.url,
+			"secret":          "secret",
+		})
+	}
+
+	return claimed, nil
+}
+
+func (m *memoryDeliveries) UpdateDelivery(_ context.Context, id int64, data map[string]interface{}) (uint, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok {
+		return 0, nil
+	}
+
+	for key, value := range data {
+		if key == "attempts" {
+			value = int32(value.(int))
+		}
+		d[key] = value
+	}
+
+	return uint(id), nil
+}
+
+func (m *memoryDeliveries) get(id int64) map[string]interface{} {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deliveries[id]
+}
+
+// due makes the next attempt of the delivery due as if its backoff passed
+func (m *memoryDeliveries) due(id int64) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries[id]["next_attempt_time"] = time.Now()
+}
+
+// flakyReceiver answers 503 to the first failures requests and 204 to the rest
+type flakyReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+}
+
+func (f *flakyReceiver) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests += 1
+	if f.requests <= f.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *flakyReceiver) count() int {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests
+}
+
+func newDispatchService(repository *memoryDeliveries, maxAttempts int) *Service {
+
+	cfg := &oms.Config{Webhook: config.Webhook{
+		Timeout:     5 * time.Second,
+		MaxAttempts: maxAttempts,
+		BackoffBase: time.Hour,
+		BackoffMax:  2 * time.Hour,
+		BatchSize:   10,
+	}}
+
+	s := NewService(cfg, nil, nil, zap.NewNop())
+	s.repository = repository
+
+	return s
+}
+
+func TestDispatchRetry(t *testing.T) {
+
+	ctx := context.Background()
+	receiver := &flakyReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	repository := newMemoryDeliveries(server.URL, 1)
+	s := newDispatchService(repository, 5)
+
+	require.NoError(t, s.Dispatch(ctx))
+
+	delivery := repository.get(1)
+	require.Equal(t, StatusPending, delivery["status"])
+	require.Equal(t, int32(1), delivery["attempts"])
+	require.Equal(t, http.StatusServiceUnavailable, delivery["response_code"])
+	require.NotNil(t, delivery["last_error"])
+	require.True(t, delivery["next_attempt_time"].(time.Time).After(time.Now().Add(50*time.Minute)))
+
+	// the delivery waits for its backoff
+	require.NoError(t, s.Dispatch(ctx))
+	require.Equal(t, 1, receiver.count())
+
+	repository.due(1)
+	require.NoError(t, s.Dispatch(ctx))
+
+	delivery = repository.get(1)
+	require.Equal(t, int32(2), delivery["attempts"])
+	require.True(t, delivery["next_attempt_time"].(time.Time).After(time.Now().Add(110*time.Minute)))
+
+	repository.due(1)
+	require.NoError(t, s.Dispatch(ctx))
+
+	delivery = repository.get(1)
+	require.Equal(t, StatusDelivered, delivery["status"])
+	require.Equal(t, int32(3), delivery["attempts"])
+	require.Equal(t, http.StatusNoContent, delivery["response_code"])
+	require.Nil(t, delivery["last_error"])
+	require.NotNil(t, delivery["delivered_at"])
+	require.Equal(t, 3, receiver.count())
+}
+
+func TestDispatchFinalFailure(t *testing.T) {
+
+	ctx := context.Background()
+	receiver := &flakyReceiver{failures: 100}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	repository := newMemoryDeliveries(server.URL, 1)
+	s := newDispatchService(repository, 2)
+
+	require.NoError(t, s.Dispatch(ctx))
+	repository.due(1)
+	require.NoError(t, s.Dispatch(ctx))
+
+	delivery := repository.get(1)
+	require.Equal(t, StatusFailed, delivery["status"])
+	require.Equal(t, int32(2), delivery["attempts"])
+	require.Equal(t, http.StatusServiceUnavailable, delivery["response_code"])
+	require.NotNil(t, delivery["last_error"])
+
+	// a failed delivery is not sent again
+	repository.due(1)
+	require.NoError(t, s.Dispatch(ctx))
+	require.Equal(t, 2, receiver.count())
+}
+
+func TestDispatchClaimedOnce(t *testing.T) {
+
+	ctx := context.Background()
+	receiver := &flakyReceiver{failures: 100}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	repository := newMemoryDeliveries(server.URL, 3)
+	s := newDispatchService(repository, 5)
+
+	// a claimed delivery is leased, concurrent dispatchers do not send it again
+	claimed, err := repository.ClaimDueDeliveries(ctx, StatusPending, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	require.NoError(t, s.Dispatch(ctx))
+	require.Equal(t, 2, receiver.count())
+	require.Equal(t, int32(0), repository.get(1)["attempts"])
+	require.Equal(t, int32(1), repository.get(2)["attempts"])
+	require.Equal(t, int32(1), repository.get(3)["attempts"])
+}
+
+func TestRedeliver(t *testing.T) {
+
+	ctx := context.Background()
+	receiver := &flakyReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	repository := newMemoryDeliveries(server.URL, 1)
+	s := newDispatchService(repository, 1)
+
+	require.NoError(t, s.Dispatch(ctx))
+	require.Equal(t, StatusFailed, repository.get(1)["status"])
+
+	require.NoError(t, s.Redeliver(ctx, 1))
+
+	delivery := repository.get(1)
+	require.Equal(t, StatusPending, delivery["status"])
+	require.Equal(t, int32(0), delivery["attempts"])
+	require.Nil(t, delivery["last_error"])
+
+	require.NoError(t, s.Dispatch(ctx))
+
+	delivery = repository.get(1)
+	require.Equal(t, StatusDelivered, delivery["status"])
+	require.Equal(t, int32(1), delivery["attempts"])
+
+	require.ErrorIs(t, s.Redeliver(ctx, 2), ErrNotFound)
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/log/zapadapter"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -12,11 +13,13 @@ import (
 )
 
 type Postgres struct {
-	conn   *pgxpool.Pool
-	conf   Config
-	log    *zap.Logger
-	ctx    context.Context
-	cancel context.CancelFunc
+	conn    *pgxpool.Pool
+	conf    Config
+	queries *queryLog
+	config  *pgx.ConnConfig
+	log     *zap.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 type Config struct {
@@ -26,6 +29,9 @@ type Config struct {
 	DBPort     string `envconfig:"db_port" default:"5432"`
 	DBName     string `envconfig:"db_name" default:"oms2"`
 	LogLevel   string `envconfig:"log_level" default:"error"`
+	MaxConns   int32  `envconfig:"max_conns" default:"10"`
+	// CountQueries makes Load report the queries and their duration, the adaptive concurrency of the robot needs them
+	CountQueries bool `ignored:"true"`
 }
 
 func NewPostgres(conf Config, log *zap.Logger) *Postgres {
@@ -41,26 +47,63 @@ func (p *Postgres) Conn(ctx context.Context) (*pgxpool.Pool, error) {
 	return p.conn, nil
 }
 
-func (p *Postgres) WithTransaction(ctx context.Context, fn func(ctx context.Context, tx pgx.Tx) error) error {
-	conn, err := p.Conn(ctx)
-	if err != nil {
-		return err
+// Querier is the part of pgxpool.Pool and pgx.Tx used by the repositories
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type txKey struct{}
+
+// TxFromContext returns the transaction started by WithTransaction for the context
+func TxFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// Querier returns the transaction of the context if any, the pool otherwise
+func (p *Postgres) Querier(ctx context.Context) (Querier, error) {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx, nil
 	}
 
-	tx, err := conn.Begin(ctx)
-	if err != nil {
-		return err
+	return p.Conn(ctx)
+}
+
+// WithTransaction runs fn in a transaction carried by the context passed to fn,
+// so repositories called with that context take part in it.
+// Inside a transaction of the context a savepoint is used instead.
+func (p *Postgres) WithTransaction(ctx context.Context, fn func(ctx context.Context, tx pgx.Tx) error) error {
+
+	var tx pgx.Tx
+	if outer, ok := TxFromContext(ctx); ok {
+		nested, err := outer.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		tx = nested
+	} else {
+		conn, err := p.Conn(ctx)
+		if err != nil {
+			return err
+		}
+
+		tx, err = conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	defer func() {
-		if err = tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
 			p.log.Error("Error with transaction rollback", zap.Error(err))
 		}
 	}()
 
-	err = fn(ctx, tx)
+	err := fn(context.WithValue(ctx, txKey{}, tx), tx)
 	if err != nil {
-		if err = tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
 			p.log.Error("Error with transaction rollback", zap.Error(err))
 		}
 
@@ -88,16 +131,21 @@ func (p *Postgres) Start(ctx context.Context) error {
 	}
 
 	poolConf.HealthCheckPeriod = 1 * time.Second
-	poolConf.MaxConns = 10
+	poolConf.MaxConns = p.conf.MaxConns
 	poolConf.MinConns = 4
-	poolConf.ConnConfig.Logger = zapadapter.NewLogger(p.log)
+	if poolConf.MinConns > poolConf.MaxConns {
+		poolConf.MinConns = poolConf.MaxConns
+	}
 	logLevel, err := pgx.LogLevelFromString(p.conf.LogLevel)
 	if err != nil {
 		return err
 	}
-	poolConf.ConnConfig.LogLevel = logLevel
+	p.queries = newQueryLog(zapadapter.NewLogger(p.log), logLevel, p.conf.CountQueries)
+	poolConf.ConnConfig.Logger = p.queries
+	poolConf.ConnConfig.LogLevel = p.queries.connLevel()
 	poolConf.ConnConfig.PreferSimpleProtocol = true
 
+	p.config = poolConf.ConnConfig.Copy()
 	p.conn, err = pgxpool.ConnectConfig(ctx, poolConf)
 	if err != nil {
 		p.log.Error("cannot connect to postgres", zap.Error(err))
@@ -115,8 +163,40 @@ func (p *Postgres) Stop(context.Context) error {
 	return nil
 }
 
+// PoolLoad is the load of the pool: the cumulative acquires and their wait, the cumulative queries
+// of the application and their duration on an acquired connection, and the connections in use
+type PoolLoad struct {
+	AcquireCount    int64
+	AcquireDuration time.Duration
+	QueryCount      int64
+	QueryDuration   time.Duration
+	AcquiredConns   int32
+	MaxConns        int32
+}
+
+// Load samples the pool and the queries run so far, the queries are counted with CountQueries only
+func (p *Postgres) Load() PoolLoad {
+
+	stat := p.conn.Stat()
+	load := PoolLoad{
+		AcquireCount:    stat.AcquireCount(),
+		AcquireDuration: stat.AcquireDuration(),
+		AcquiredConns:   stat.AcquiredConns(),
+		MaxConns:        stat.MaxConns(),
+	}
+	load.QueryCount, load.QueryDuration = p.queries.observed()
+
+	return load
+}
+
+// Connect opens a connection of its own outside of the pool, it does not take a connection
+// of the pool for as long as it lives and is closed by the caller
+func (p *Postgres) Connect(ctx context.Context) (*pgx.Conn, error) {
+	return pgx.ConnectConfig(ctx, p.config.Copy())
+}
+
 func (p *Postgres) IsReady(ctx context.Context) (bool, error) {
-	if _, err := p.conn.Exec(ctx, ";"); err != nil {
+	if _, err := p.conn.Exec(ctx, ping); err != nil {
 		return false, err
 	}
 
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// queryLog counts the queries of the connections and their duration from the messages pgx logs
+// on every Exec and Query, and passes on the messages up to the configured level to the logger.
+// The duration does not include the wait for a connection of the pool, the pings of IsReady are not counted.
+// Without count the connections log at the configured level and the queries are not counted.
+type queryLog struct {
+	next  pgx.Logger
+	level pgx.LogLevel
+	count bool
+
+	queries  int64
+	duration int64
+}
+
+const ping = ";"
+
+func newQueryLog(next pgx.Logger, level pgx.LogLevel, count bool) *queryLog {
+	return &queryLog{next: next, level: level, count: count}
+}
+
+// connLevel is the level the connections log at, the queries are logged at info,
+// so it is raised to info only to count them
+func (l *queryLog) connLevel() pgx.LogLevel {
+	if l.count && l.level < pgx.LogLevelInfo {
+		return pgx.LogLevelInfo
+	}
+
+	return l.level
+}
+
+func (l *queryLog) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+
+	if l.count && (msg == "Exec" || msg == "Query") && data["sql"] != ping {
+		if d, ok := data["time"].(time.Duration); ok {
+			atomic.AddInt64(&l.queries, 1)
+			atomic.AddInt64(&l.duration, int64(d))
+		}
+	}
+
+	if level <= l.level {
+		l.next.Log(ctx, level, msg, data)
+	}
+}
+
+// observed returns the number of the queries and their total duration so far
+func (l *queryLog) observed() (int64, time.Duration) {
+	return atomic.LoadInt64(&l.queries), time.Duration(atomic.LoadInt64(&l.duration))
+}
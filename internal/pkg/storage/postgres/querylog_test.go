@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type recordLog struct {
+	messages []string
+}
+
+func (r *recordLog) Log(_ context.Context, _ pgx.LogLevel, msg string, _ map[string]interface{}) {
+	r.messages = append(r.messages, msg)
+}
+
+func TestQueryLog(t *testing.T) {
+
+	next := &recordLog{}
+	l := newQueryLog(next, pgx.LogLevelError, true)
+
+	if l.connLevel() != pgx.LogLevelInfo {
+		t.Fatalf("expected the connections to log the queries at info, got %v", l.connLevel())
+	}
+
+	ctx := context.Background()
+	l.Log(ctx, pgx.LogLevelInfo, "Exec", map[string]interface{}{"sql": "update _inforeg_csr", "time": 3 * time.Millisecond})
+	l.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "select * from _ref_l", "time": 5 * time.Millisecond})
+	l.Log(ctx, pgx.LogLevelInfo, "Exec", map[string]interface{}{"sql": ping, "time": time.Second})
+	l.Log(ctx, pgx.LogLevelError, "Query", map[string]interface{}{"sql": "select 1/0", "err": "division by zero"})
+
+	count, duration := l.observed()
+	if count != 2 || duration != 8*time.Millisecond {
+		t.Fatalf("expected 2 queries in 8ms, got %d in %v", count, duration)
+	}
+
+	// only the messages of the configured level reach the logger
+	if len(next.messages) != 1 || next.messages[0] != "Query" {
+		t.Fatalf("expected the error passed on, got %v", next.messages)
+	}
+}
+
+func TestQueryLogLevel(t *testing.T) {
+
+	l := newQueryLog(&recordLog{}, pgx.LogLevelDebug, true)
+
+	if l.connLevel() != pgx.LogLevelDebug {
+		t.Fatalf("expected the configured level kept, got %v", l.connLevel())
+	}
+}
+
+func TestQueryLogWithoutCount(t *testing.T) {
+
+	next := &recordLog{}
+	l := newQueryLog(next, pgx.LogLevelError, false)
+
+	// without the adaptive concurrency the connections do not log the queries at info
+	if l.connLevel() != pgx.LogLevelError {
+		t.Fatalf("expected the configured level kept, got %v", l.connLevel())
+	}
+
+	ctx := context.Background()
+	l.Log(ctx, pgx.LogLevelInfo, "Exec", map[string]interface{}{"sql": "update _inforeg_csr", "time": 3 * time.Millisecond})
+	l.Log(ctx, pgx.LogLevelError, "Query", map[string]interface{}{"sql": "select 1/0", "err": "division by zero"})
+
+	if count, _ := l.observed(); count != 0 {
+		t.Fatalf("expected no queries counted, got %d", count)
+	}
+	if len(next.messages) != 1 {
+		t.Fatalf("expected the error passed on, got %v", next.messages)
+	}
+}
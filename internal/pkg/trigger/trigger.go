@@ -0,0 +1,107 @@
+// Package trigger evaluates the conditions of trigger nodes over the event types received by a lot.
+package trigger
+
+import (
+	"errors"
+	"fmt"
+
+	"oms2/internal/pkg/util"
+)
+
+const (
+	ModeAny  = "any"
+	ModeAll  = "all"
+	ModeNOfM = "n_of_m"
+)
+
+var (
+	ErrUnknownMode  = errors.New("unknown trigger mode")
+	ErrNoEvents     = errors.New("trigger has no event types")
+	ErrInvalidCount = errors.New("trigger count must be between 1 and the number of event types")
+)
+
+// Condition of a trigger node: any, all or Count of the Required event types
+type Condition struct {
+	Mode     string  `json:"mode"`
+	Count    int     `json:"count"`
+	Required []int64 `json:"required"`
+}
+
+func (c Condition) Check() error {
+
+	if len(c.Required) == 0 {
+		return ErrNoEvents
+	}
+
+	switch c.Mode {
+	case ModeAny, ModeAll:
+	case ModeNOfM:
+		if c.Count < 1 || c.Count > len(c.Required) {
+			return ErrInvalidCount
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMode, c.Mode)
+	}
+
+	return nil
+}
+
+// Evaluate reports whether the received event types satisfy the condition
+// and returns the required event types that are still missing, in the order of Required.
+// Received event types that are not required are ignored.
+func Evaluate(c Condition, received []int64) (bool, []int64, error) {
+
+	if err := c.Check(); err != nil {
+		return false, nil, err
+	}
+
+	got := make(map[int64]bool)
+	for _, eventType := range received {
+		got[eventType] = true
+	}
+
+	matched := 0
+	missing := make([]int64, 0)
+	for _, eventType := range c.Required {
+		if got[eventType] {
+			matched += 1
+		} else {
+			missing = append(missing, eventType)
+		}
+	}
+
+	need := len(c.Required)
+	switch c.Mode {
+	case ModeAny:
+		need = 1
+	case ModeNOfM:
+		need = c.Count
+	}
+
+	return matched >= need, missing, nil
+}
+
+// FromState reads the condition and the received event types from a trigger state row
+// with trigger_mode, trigger_count, required and received columns
+func FromState(row map[string]interface{}) (Condition, []int64) {
+
+	c := Condition{
+		Mode:     fmt.Sprint(row["trigger_mode"]),
+		Count:    int(util.ToInt64(row["trigger_count"])),
+		Required: ids(row["required"]),
+	}
+
+	return c, ids(row["received"])
+}
+
+func ids(value interface{}) []int64 {
+
+	items, _ := value.([]interface{})
+
+	result := make([]int64, 0, len(items))
+	for _, item := range items {
+		result = append(result, util.ToInt64(item))
+	}
+
+	return result
+}
@@ -0,0 +1,78 @@
+package trigger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+
+	paid, packed, confirmed := int64(1), int64(2), int64(3)
+
+	all := Condition{Mode: ModeAll, Required: []int64{paid, packed}}
+
+	ok, missing, err := Evaluate(all, []int64{paid})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, []int64{packed}, missing)
+
+	ok, missing, err = Evaluate(all, []int64{packed, paid, confirmed})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, missing)
+
+	anyOf := Condition{Mode: ModeAny, Required: []int64{paid, packed}}
+
+	ok, missing, err = Evaluate(anyOf, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, []int64{paid, packed}, missing)
+
+	ok, _, err = Evaluate(anyOf, []int64{packed})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	twoOfThree := Condition{Mode: ModeNOfM, Count: 2, Required: []int64{paid, packed, confirmed}}
+
+	ok, missing, err = Evaluate(twoOfThree, []int64{confirmed, confirmed})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, []int64{paid, packed}, missing)
+
+	ok, missing, err = Evaluate(twoOfThree, []int64{confirmed, paid})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []int64{packed}, missing)
+}
+
+func TestCheck(t *testing.T) {
+
+	require.True(t, errors.Is(Condition{Mode: ModeAll}.Check(), ErrNoEvents))
+	require.True(t, errors.Is(Condition{Mode: "some", Required: []int64{1}}.Check(), ErrUnknownMode))
+	require.True(t, errors.Is(Condition{Mode: ModeNOfM, Count: 3, Required: []int64{1, 2}}.Check(), ErrInvalidCount))
+	require.True(t, errors.Is(Condition{Mode: ModeNOfM, Required: []int64{1, 2}}.Check(), ErrInvalidCount))
+	require.NoError(t, Condition{Mode: ModeNOfM, Count: 2, Required: []int64{1, 2}}.Check())
+}
+
+func TestFromState(t *testing.T) {
+
+	row := map[string]interface{}{
+		"trigger_mode":  "n_of_m",
+		"trigger_count": int32(2),
+		"required":      []interface{}{1.0, 2.0, 3.0},
+		"received":      []interface{}{2.0},
+	}
+
+	c, received := FromState(row)
+	require.Equal(t, Condition{Mode: ModeNOfM, Count: 2, Required: []int64{1, 2, 3}}, c)
+	require.Equal(t, []int64{2}, received)
+
+	row["trigger_count"] = nil
+	row["received"] = []interface{}{}
+
+	c, received = FromState(row)
+	require.Equal(t, 0, c.Count)
+	require.Empty(t, received)
+}
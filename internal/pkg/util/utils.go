@@ -85,3 +85,65 @@ func GetGoroutineId() int {
 	}
 	return id
 }
+
+// ToJSON returns the raw document of a json/jsonb column value.
+// Values already decoded by the driver are marshalled back.
+func ToJSON(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return []byte("null"), nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case json.RawMessage:
+		return v, nil
+	}
+
+	return json.Marshal(value)
+}
+
+// ToMap converts a json/jsonb column value to a map, nil and empty values give an empty map
+func ToMap(value interface{}) (map[string]interface{}, error) {
+
+	if v, ok := value.(map[string]interface{}); ok {
+		return v, nil
+	}
+
+	data, err := ToJSON(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	if len(data) == 0 || string(data) == "null" {
+		return result, nil
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ToInt64 converts integer column values of any size, other values give 0
+func ToInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+
+	return 0
+}
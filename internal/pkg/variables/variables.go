@@ -0,0 +1,150 @@
+package variables
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	MergeNone      = "none"
+	MergeOverwrite = "merge"
+	MergeNamespace = "namespace"
+	MergeMapping   = "map"
+)
+
+var ErrUnknownMode = errors.New("unknown merge mode")
+
+// MergeRule describes how the payload of an event type is merged into lot variables.
+//
+//	none      - the payload is not merged
+//	merge     - top level keys of the payload are copied to variables
+//	namespace - the whole payload is stored under the Namespace variable
+//	map       - values found by payload paths (dot separated) are stored under the mapped variable names
+//
+// KeepExisting leaves variables that are already set untouched.
+type MergeRule struct {
+	Mode         string            `json:"mode"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Mapping      map[string]string `json:"mapping,omitempty"`
+	KeepExisting bool              `json:"keep_existing,omitempty"`
+}
+
+// Check validates the rule before it is stored
+func (r MergeRule) Check() error {
+
+	switch r.Mode {
+	case "", MergeNone, MergeOverwrite:
+	case MergeNamespace:
+		if len(r.Namespace) == 0 {
+			return errors.New("merge rule namespace is required for namespace mode")
+		}
+	case MergeMapping:
+		if len(r.Mapping) == 0 {
+			return errors.New("merge rule mapping is required for map mode")
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMode, r.Mode)
+	}
+
+	return nil
+}
+
+// Merge returns a copy of variables with the payload merged by rule
+func Merge(variables map[string]interface{}, payload map[string]interface{}, rule MergeRule) (map[string]interface{}, error) {
+
+	if err := rule.Check(); err != nil {
+		return nil, err
+	}
+
+	result := Copy(variables)
+
+	set := func(key string, value interface{}) {
+		if _, exists := result[key]; exists && rule.KeepExisting {
+			return
+		}
+		result[key] = value
+	}
+
+	switch rule.Mode {
+	case MergeOverwrite:
+		for key, value := range payload {
+			set(key, value)
+		}
+	case MergeNamespace:
+		set(rule.Namespace, payload)
+	case MergeMapping:
+		for path, key := range rule.Mapping {
+			if value, ok := Lookup(payload, path); ok {
+				set(key, value)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Patch returns a copy of variables with top level keys of patch applied, nil values remove variables
+func Patch(variables map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+
+	result := Copy(variables)
+	for key, value := range patch {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = value
+	}
+
+	return result
+}
+
+// Diff returns the patch turning before into after, removed variables have nil values
+func Diff(before map[string]interface{}, after map[string]interface{}) map[string]interface{} {
+
+	patch := make(map[string]interface{})
+	for key, value := range after {
+		if previous, ok := before[key]; !ok || !reflect.DeepEqual(previous, value) {
+			patch[key] = value
+		}
+	}
+
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			patch[key] = nil
+		}
+	}
+
+	return patch
+}
+
+// Lookup finds a value by a dot separated path, e.g. "payment.amount"
+func Lookup(data map[string]interface{}, path string) (interface{}, bool) {
+
+	var current interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Copy returns a shallow copy, nil gives an empty map
+func Copy(variables map[string]interface{}) map[string]interface{} {
+
+	result := make(map[string]interface{}, len(variables))
+	for key, value := range variables {
+		result[key] = value
+	}
+
+	return result
+}
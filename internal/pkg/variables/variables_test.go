@@ -0,0 +1,62 @@
+package variables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+
+	variables := map[string]interface{}{"status": "new", "amount": 10.0}
+	payload := map[string]interface{}{
+		"amount":  25.0,
+		"payment": map[string]interface{}{"id": "p-1"},
+	}
+
+	result, err := Merge(variables, payload, MergeRule{Mode: MergeNone})
+	require.NoError(t, err)
+	require.Equal(t, variables, result)
+
+	result, err = Merge(variables, payload, MergeRule{Mode: MergeOverwrite})
+	require.NoError(t, err)
+	require.Equal(t, 25.0, result["amount"])
+	require.Equal(t, "new", result["status"])
+	require.Equal(t, 10.0, variables["amount"])
+
+	result, err = Merge(variables, payload, MergeRule{Mode: MergeOverwrite, KeepExisting: true})
+	require.NoError(t, err)
+	require.Equal(t, 10.0, result["amount"])
+	require.Contains(t, result, "payment")
+
+	result, err = Merge(variables, payload, MergeRule{Mode: MergeNamespace, Namespace: "captured"})
+	require.NoError(t, err)
+	require.Equal(t, payload, result["captured"])
+
+	result, err = Merge(variables, payload, MergeRule{Mode: MergeMapping, Mapping: map[string]string{
+		"payment.id": "payment_id",
+		"missing":    "missing",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, "p-1", result["payment_id"])
+	require.NotContains(t, result, "missing")
+
+	_, err = Merge(variables, payload, MergeRule{Mode: "append"})
+	require.ErrorIs(t, err, ErrUnknownMode)
+}
+
+func TestPatch(t *testing.T) {
+
+	result := Patch(map[string]interface{}{"a": 1, "b": 2}, map[string]interface{}{"a": nil, "c": 3})
+	require.Equal(t, map[string]interface{}{"b": 2, "c": 3}, result)
+}
+
+func TestDiff(t *testing.T) {
+
+	before := map[string]interface{}{"a": 1, "b": 2, "c": map[string]interface{}{"x": 1}}
+	after := map[string]interface{}{"b": 3, "c": map[string]interface{}{"x": 1}, "d": 4}
+
+	patch := Diff(before, after)
+	require.Equal(t, map[string]interface{}{"a": nil, "b": 3, "d": 4}, patch)
+	require.Equal(t, after, Patch(before, patch))
+}
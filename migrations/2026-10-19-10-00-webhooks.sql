@@ -0,0 +1,39 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-10-00-_Ref_WS
+-- comment подписки на вебхуки
+CREATE TABLE _Ref_WS
+(
+    id            bigserial NOT NULL,
+    url           varchar   NOT NULL,
+    secret        varchar   NOT NULL,
+    node_id       int REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    event_type_id int REFERENCES _Ref_ET (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    kind          varchar,
+    active        boolean   NOT NULL       DEFAULT true,
+    created_at    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+-- rollback drop table _Ref_WS;
+
+-- changeset zinov:2026-10-19-10-00-_InfoReg_WD
+-- comment журнал доставки вебхуков
+CREATE TABLE _InfoReg_WD
+(
+    id                bigserial NOT NULL,
+    subscription_id   int REFERENCES _Ref_WS (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    kind              varchar   NOT NULL,
+    lot_id            int,
+    node_id           int,
+    payload           jsonb     NOT NULL,
+    status            varchar   NOT NULL       DEFAULT 'pending',
+    attempts          int       NOT NULL       DEFAULT 0,
+    next_attempt_time timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    response_code     int,
+    last_error        varchar,
+    created_at        timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at      timestamp WITH TIME ZONE,
+    PRIMARY KEY (id)
+);
+CREATE INDEX _InfoReg_WD_status_idx ON _InfoReg_WD (status, next_attempt_time);
+-- rollback drop table _InfoReg_WD;
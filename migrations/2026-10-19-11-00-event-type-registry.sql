@@ -0,0 +1,20 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-11-00-_Ref_ET
+-- comment реестр типов событий: код, описание и схема данных события
+ALTER TABLE _Ref_ET
+    ADD COLUMN code           varchar,
+    ADD COLUMN description    varchar NOT NULL DEFAULT '',
+    ADD COLUMN payload_schema jsonb;
+UPDATE _Ref_ET
+SET code = name;
+ALTER TABLE _Ref_ET
+    ALTER COLUMN code SET NOT NULL,
+    ADD CONSTRAINT _Ref_ET_code_key UNIQUE (code);
+-- rollback alter table _Ref_ET drop column code, drop column description, drop column payload_schema;
+
+-- changeset zinov:2026-10-19-11-00-_Ref_E
+-- comment данные события
+ALTER TABLE _Ref_E
+    ADD COLUMN payload jsonb NOT NULL DEFAULT '{}';
+-- rollback alter table _Ref_E drop column payload;
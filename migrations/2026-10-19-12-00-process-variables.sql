@@ -0,0 +1,13 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-12-00-_Ref_L
+-- comment переменные процесса лота
+ALTER TABLE _Ref_L
+    ADD COLUMN variables jsonb NOT NULL DEFAULT '{}';
+-- rollback alter table _Ref_L drop column variables;
+
+-- changeset zinov:2026-10-19-12-00-_Ref_ET
+-- comment правило переноса данных события в переменные лота
+ALTER TABLE _Ref_ET
+    ADD COLUMN merge_rule jsonb NOT NULL DEFAULT '{"mode": "none"}';
+-- rollback alter table _Ref_ET drop column merge_rule;
@@ -0,0 +1,23 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-13-00-_Ref_E
+-- comment внешний ключ идемпотентности события, уникален в пределах источника
+ALTER TABLE _Ref_E
+    ADD COLUMN source          varchar NOT NULL DEFAULT '',
+    ADD COLUMN idempotency_key varchar;
+CREATE UNIQUE INDEX _Ref_E_idempotency_idx ON _Ref_E (source, idempotency_key) WHERE idempotency_key IS NOT NULL;
+-- rollback drop index _Ref_E_idempotency_idx;
+-- rollback alter table _Ref_E drop column source, drop column idempotency_key;
+
+-- changeset zinov:2026-10-19-13-00-_InfoReg_ED
+-- comment повторные поступления событий (дубли)
+CREATE TABLE _InfoReg_ED
+(
+    id              bigserial NOT NULL,
+    event_id        int REFERENCES _Ref_E (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    source          varchar   NOT NULL,
+    idempotency_key varchar   NOT NULL,
+    hit_time        timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+-- rollback drop table _InfoReg_ED;
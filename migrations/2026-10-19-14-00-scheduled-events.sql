@@ -0,0 +1,24 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-14-00-_InfoReg_SE
+-- comment отложенные события
+CREATE TABLE _InfoReg_SE
+(
+    id            bigserial NOT NULL,
+    event_type_id int REFERENCES _Ref_ET (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    lot_id        int REFERENCES _Ref_L (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    name          varchar   NOT NULL,
+    payload       jsonb     NOT NULL       DEFAULT '{}',
+    fire_at       timestamp WITH TIME ZONE NOT NULL,
+    cancel_key    varchar,
+    status        varchar   NOT NULL       DEFAULT 'pending',
+    lease_until   timestamp WITH TIME ZONE,
+    event_id      int REFERENCES _Ref_E (id) ON DELETE SET NULL,
+    last_error    varchar,
+    created_at    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at  timestamp WITH TIME ZONE,
+    PRIMARY KEY (id)
+);
+CREATE INDEX _InfoReg_SE_status_idx ON _InfoReg_SE (status, fire_at);
+CREATE INDEX _InfoReg_SE_cancel_key_idx ON _InfoReg_SE (cancel_key) WHERE cancel_key IS NOT NULL;
+-- rollback drop table _InfoReg_SE;
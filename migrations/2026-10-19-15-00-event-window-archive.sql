@@ -0,0 +1,56 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-15-00-_Ref_ET
+-- comment окно ожидания события в секундах, пустое значение - окно по умолчанию из настроек
+ALTER TABLE _Ref_ET
+    ADD COLUMN window_seconds int CHECK (window_seconds > 0);
+-- rollback alter table _Ref_ET drop column window_seconds;
+
+-- changeset zinov:2026-10-19-15-00-_Ref_E
+-- comment время регистрации события
+ALTER TABLE _Ref_E
+    ADD COLUMN entry_time timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+CREATE INDEX _Ref_E_entry_time_idx ON _Ref_E (entry_time);
+-- rollback alter table _Ref_E drop column entry_time;
+
+-- changeset zinov:2026-10-19-15-00-_InfoReg_ES
+-- comment время, когда семафор перевел лот на следующий шаг
+ALTER TABLE _InfoReg_ES
+    ADD COLUMN consumed_time timestamp WITH TIME ZONE;
+CREATE INDEX _InfoReg_ES_entry_time_idx ON _InfoReg_ES (entry_time);
+-- rollback alter table _InfoReg_ES drop column consumed_time;
+
+-- changeset zinov:2026-10-19-15-00-_InfoReg_ESA
+-- comment архив семафоров обработки событий
+CREATE TABLE _InfoReg_ESA
+(
+    id            bigint  NOT NULL,
+    lot_id        int,
+    semaphore_id  int,
+    event_id      int,
+    order_id      int,
+    entry_time    timestamp WITH TIME ZONE,
+    consumed_time timestamp WITH TIME ZONE,
+    archive_time  timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+CREATE INDEX _InfoReg_ESA_lot_idx ON _InfoReg_ESA (lot_id);
+-- rollback drop table _InfoReg_ESA;
+
+-- changeset zinov:2026-10-19-15-00-_Ref_EA
+-- comment архив событий
+CREATE TABLE _Ref_EA
+(
+    id              bigint  NOT NULL,
+    name            varchar NOT NULL,
+    event_type_id   int,
+    lot_id          int,
+    payload         jsonb,
+    source          varchar,
+    idempotency_key varchar,
+    entry_time      timestamp WITH TIME ZONE,
+    archive_time    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+CREATE INDEX _Ref_EA_lot_idx ON _Ref_EA (lot_id);
+-- rollback drop table _Ref_EA;
@@ -0,0 +1,36 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-16-00-_Ref_M
+-- comment условие срабатывания триггера: any - любое событие, all - все события, n_of_m - trigger_count событий
+ALTER TABLE _Ref_M
+    ADD COLUMN trigger_mode  varchar NOT NULL DEFAULT 'any' CHECK (trigger_mode IN ('any', 'all', 'n_of_m')),
+    ADD COLUMN trigger_count int CHECK (trigger_count > 0),
+    ADD CONSTRAINT _Ref_M_trigger_count_check CHECK (trigger_mode <> 'n_of_m' OR trigger_count IS NOT NULL);
+-- rollback alter table _Ref_M drop constraint _Ref_M_trigger_count_check, drop column trigger_mode, drop column trigger_count;
+
+-- changeset zinov:2026-10-19-16-00-_RefVT_MT
+-- comment табличная часть типов событий условия триггера, вместе с event_trigger узла
+CREATE TABLE _RefVT_MT
+(
+    id            bigserial NOT NULL,
+    node_id       int REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    event_type_id int REFERENCES _Ref_ET (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    UNIQUE (node_id, event_type_id)
+);
+-- rollback drop table _RefVT_MT;
+
+-- changeset zinov:2026-10-19-16-00-_InfoReg_TS
+-- comment полученные лотом события условий триггеров (Trigger State)
+CREATE TABLE _InfoReg_TS
+(
+    id            bigserial NOT NULL,
+    lot_id        int REFERENCES _Ref_L (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    node_id       int REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    event_type_id int REFERENCES _Ref_ET (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    event_id      int,
+    entry_time    timestamp WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE (lot_id, node_id, event_type_id)
+);
+-- rollback drop table _InfoReg_TS;
@@ -0,0 +1,7 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-17-00-_Ref_M
+-- comment параметры узла, передаются действию и проверяются по схеме параметров действия; действие http берет из них адрес, метод, заголовки, тело, таймаут, ожидаемые статусы и перенос ответа в переменные
+ALTER TABLE _Ref_M
+    ADD COLUMN params jsonb NOT NULL DEFAULT '{}';
+-- rollback alter table _Ref_M drop column params;
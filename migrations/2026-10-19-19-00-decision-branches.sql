@@ -0,0 +1,15 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-19-00-_RefVT_MB
+-- comment ветви узла-решения: условия на Starlark проверяются по порядку, лот переходит на узел первой истинной ветви
+CREATE TABLE _RefVT_MB
+(
+    id             bigserial NOT NULL,
+    node_id        int       NOT NULL REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    position       int       NOT NULL DEFAULT 0,
+    condition      text      NOT NULL,
+    target_node_id int       NOT NULL REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    UNIQUE (node_id, position)
+);
+-- rollback drop table _RefVT_MB;
@@ -0,0 +1,29 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-20-00-_Ref_M
+-- comment ветвь ошибки и таймаут ожидания асинхронного действия узла
+ALTER TABLE _Ref_M
+    ADD COLUMN fail_node_id            int REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE SET NULL,
+    ADD COLUMN pending_timeout_seconds int CHECK (pending_timeout_seconds > 0);
+-- rollback alter table _Ref_M drop column fail_node_id, drop column pending_timeout_seconds;
+
+-- changeset zinov:2026-10-19-20-00-_InfoReg_AW
+-- comment ожидания асинхронных действий: лот ждет на узле завершения по токену через callback или событие
+CREATE TABLE _InfoReg_AW
+(
+    id             bigserial   NOT NULL,
+    lot_id         int         NOT NULL REFERENCES _Ref_L (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    node_id        int         NOT NULL REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token          varchar     NOT NULL UNIQUE,
+    status         varchar     NOT NULL DEFAULT 'pending' CHECK (status in ('pending', 'completed', 'failed', 'timed_out')),
+    created_time   timestamptz NOT NULL DEFAULT now(),
+    deadline       timestamptz NOT NULL,
+    completed_time timestamptz,
+    resolved_time  timestamptz,
+    result         jsonb       NOT NULL DEFAULT '{}',
+    error          text,
+    event_id       int,
+    PRIMARY KEY (id)
+);
+CREATE INDEX _InfoReg_AW_lot_node ON _InfoReg_AW (lot_id, node_id) WHERE resolved_time IS NULL;
+-- rollback drop table _InfoReg_AW;
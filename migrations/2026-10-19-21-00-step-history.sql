@@ -0,0 +1,24 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-21-00-_InfoReg_CSR
+-- comment номер попытки: число завершенных выполнений действий лота, входит в ключ идемпотентности lot:node:attempt
+ALTER TABLE _InfoReg_CSR
+    ADD COLUMN attempt int NOT NULL DEFAULT 0;
+-- rollback alter table _InfoReg_CSR drop column attempt;
+
+-- changeset zinov:2026-10-19-21-00-_InfoReg_SH
+-- comment история шагов лота, пишется в одной транзакции с переходом
+CREATE TABLE _InfoReg_SH
+(
+    id              bigserial   NOT NULL,
+    lot_id          int         NOT NULL REFERENCES _Ref_L (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    node_id         int         NOT NULL REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    prev_node_id    int REFERENCES _Ref_M (id) ON UPDATE CASCADE ON DELETE SET NULL,
+    attempt         int         NOT NULL DEFAULT 0,
+    idempotency_key varchar,
+    event_type_id   int,
+    created_time    timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+CREATE INDEX _InfoReg_SH_lot_id ON _InfoReg_SH (lot_id, id);
+-- rollback drop table _InfoReg_SH;
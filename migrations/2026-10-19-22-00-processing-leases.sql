@@ -0,0 +1,16 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-22-00-_InfoReg_PA
+-- comment аренда заказа экземпляром робота: заказ обрабатывает один экземпляр до lease_until, просроченную аренду забирает другой
+DELETE
+FROM _InfoReg_PA a USING _InfoReg_PA b
+WHERE a.order_id = b.order_id
+  AND a.id < b.id;
+ALTER TABLE _InfoReg_PA
+    ADD COLUMN instance_id varchar     NOT NULL DEFAULT '',
+    ADD COLUMN lease_until timestamptz NOT NULL DEFAULT now();
+CREATE UNIQUE INDEX _InfoReg_PA_order_id ON _InfoReg_PA (order_id);
+CREATE INDEX _InfoReg_PA_lease_until ON _InfoReg_PA (lease_until);
+-- rollback drop index _InfoReg_PA_lease_until;
+-- rollback drop index _InfoReg_PA_order_id;
+-- rollback alter table _InfoReg_PA drop column instance_id, drop column lease_until;
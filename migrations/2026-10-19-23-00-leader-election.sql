@@ -0,0 +1,13 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-23-00-_InfoReg_LE
+-- comment эпохи лидерства: номер эпохи - токен ограждения (fencing token) лидера, растет при каждой смене лидера
+CREATE TABLE _InfoReg_LE
+(
+    name          varchar     NOT NULL,
+    epoch         bigint      NOT NULL DEFAULT 1,
+    instance_id   varchar     NOT NULL,
+    acquired_time timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (name)
+);
+-- rollback drop table _InfoReg_LE;
@@ -0,0 +1,27 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-24-00-_InfoReg_PA
+-- comment время последнего heartbeat потока, аренда продлевается с каждым heartbeat
+ALTER TABLE _InfoReg_PA
+    ADD COLUMN heartbeat_time timestamptz NOT NULL DEFAULT now();
+-- rollback alter table _InfoReg_PA drop column heartbeat_time;
+
+-- changeset zinov:2026-10-19-24-01-_InfoReg_PAR
+-- comment журнал аренд заказов, освобожденных после истечения heartbeat (Processing Activity Reclaimed)
+CREATE TABLE _InfoReg_PAR
+(
+    id             bigserial PRIMARY KEY,
+    order_id       int         NOT NULL,
+    thread_key     varchar     NOT NULL,
+    thread_id      varchar     NOT NULL,
+    group_id       int         NOT NULL,
+    instance_id    varchar     NOT NULL,
+    start_time     timestamptz,
+    heartbeat_time timestamptz NOT NULL,
+    lease_until    timestamptz NOT NULL,
+    reclaimed_time timestamptz NOT NULL DEFAULT now(),
+    reclaimed_by   varchar     NOT NULL
+);
+CREATE INDEX _InfoReg_PAR_reclaimed_time ON _InfoReg_PAR (reclaimed_time);
+CREATE INDEX _InfoReg_PAR_order_id ON _InfoReg_PAR (order_id);
+-- rollback drop table _InfoReg_PAR;
@@ -0,0 +1,12 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-25-00-_InfoReg_RS
+-- comment настройки робота, измененные через API во время работы, общие для всех экземпляров (Robot Settings)
+CREATE TABLE _InfoReg_RS
+(
+    name         varchar     NOT NULL,
+    value        varchar     NOT NULL,
+    updated_time timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (name)
+);
+-- rollback drop table _InfoReg_RS;
@@ -0,0 +1,22 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-26-00-_Ref_PG
+-- comment группы обработки: id - group_id из _InfoReg_PG, лимит потоков, размер пакета, интервал опроса и признак включения
+CREATE TABLE _Ref_PG
+(
+    id                    int PRIMARY KEY,
+    code                  varchar     NOT NULL UNIQUE,
+    name                  varchar     NOT NULL,
+    concurrency_limit     int,
+    batch_size            int,
+    poll_interval_seconds int         NOT NULL DEFAULT 0,
+    enabled               boolean     NOT NULL DEFAULT true,
+    updated_time          timestamptz NOT NULL DEFAULT now(),
+    CHECK (concurrency_limit > 0),
+    CHECK (batch_size > 0),
+    CHECK (poll_interval_seconds >= 0)
+);
+INSERT INTO _Ref_PG(id, code, name)
+SELECT DISTINCT group_id, 'group_' || group_id, 'group_' || group_id
+FROM _InfoReg_PG;
+-- rollback drop table _Ref_PG;
@@ -0,0 +1,8 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-27-00-_Ref_PG
+-- comment вес группы в справедливом распределении потоков робота и гарантированный минимум потоков группы
+ALTER TABLE _Ref_PG
+    ADD COLUMN weight      int NOT NULL DEFAULT 1 CHECK (weight > 0),
+    ADD COLUMN min_threads int NOT NULL DEFAULT 0 CHECK (min_threads >= 0);
+-- rollback alter table _Ref_PG drop column weight, drop column min_threads;
@@ -0,0 +1,15 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-28-00-_InfoReg_PA
+-- comment пул обработчиков потока по группе узлов (_Ref_M.group_id) и лоты, переданные потоку
+ALTER TABLE _InfoReg_PA
+    ADD COLUMN pool    varchar NOT NULL DEFAULT 'default',
+    ADD COLUMN lot_ids int[]   NOT NULL DEFAULT '{}';
+-- rollback alter table _InfoReg_PA drop column pool, drop column lot_ids;
+
+-- changeset zinov:2026-10-19-28-01-_InfoReg_PAR
+-- comment пул и лоты освобожденной аренды
+ALTER TABLE _InfoReg_PAR
+    ADD COLUMN pool    varchar NOT NULL DEFAULT 'default',
+    ADD COLUMN lot_ids int[]   NOT NULL DEFAULT '{}';
+-- rollback alter table _InfoReg_PAR drop column pool, drop column lot_ids;
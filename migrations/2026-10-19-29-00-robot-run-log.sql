@@ -0,0 +1,27 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-29-00-_InfoReg_RL
+-- comment журнал работы робота (Run Log): строка на цикл модели и на поток, потоки цикла связаны cycle_id
+CREATE TABLE _InfoReg_RL
+(
+    id            bigserial PRIMARY KEY,
+    kind          varchar     NOT NULL CHECK (kind in ('cycle', 'thread')),
+    cycle_id      varchar     NOT NULL,
+    thread_key    varchar,
+    instance_id   varchar     NOT NULL,
+    model         varchar     NOT NULL,
+    group_id      int,
+    pool          varchar,
+    threads       int         NOT NULL DEFAULT 0,
+    orders        int         NOT NULL DEFAULT 0,
+    lots          int         NOT NULL DEFAULT 0,
+    transitions   int,
+    action_errors int,
+    error         varchar,
+    start_time    timestamptz NOT NULL,
+    end_time      timestamptz NOT NULL,
+    duration_ms   bigint      NOT NULL
+);
+CREATE INDEX _InfoReg_RL_start_time ON _InfoReg_RL (start_time);
+CREATE INDEX _InfoReg_RL_cycle_id ON _InfoReg_RL (cycle_id);
+-- rollback drop table _InfoReg_RL;
@@ -0,0 +1,30 @@
+-- liquibase formatted sql
+
+-- changeset zinov:2026-10-19-30-00-_InfoReg_EK
+-- comment ключи идемпотентности событий (Event Keys): хранятся отдельно от _Ref_E, архив событий их не удаляет
+CREATE TABLE _InfoReg_EK
+(
+    source          varchar     NOT NULL,
+    idempotency_key varchar     NOT NULL,
+    event_id        bigint      NOT NULL,
+    entry_time      timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (source, idempotency_key)
+);
+INSERT INTO _InfoReg_EK(source, idempotency_key, event_id, entry_time)
+SELECT source, idempotency_key, id, coalesce(entry_time, now())
+FROM _Ref_E
+WHERE idempotency_key IS NOT NULL
+UNION ALL
+SELECT coalesce(source, ''), idempotency_key, id, coalesce(entry_time, now())
+FROM _Ref_EA
+WHERE idempotency_key IS NOT NULL
+ON CONFLICT DO NOTHING;
+-- rollback drop table _InfoReg_EK;
+
+-- changeset zinov:2026-10-19-30-01-_InfoReg_ED
+-- comment дубль может ссылаться на событие в архиве, удаление события не удаляет журнал дублей
+ALTER TABLE _InfoReg_ED
+    DROP CONSTRAINT _inforeg_ed_event_id_fkey;
+CREATE INDEX _InfoReg_ED_event_id ON _InfoReg_ED (event_id);
+-- rollback drop index _InfoReg_ED_event_id;
+-- rollback alter table _InfoReg_ED add constraint _inforeg_ed_event_id_fkey foreign key (event_id) references _Ref_E (id) on update cascade on delete cascade;